@@ -0,0 +1,92 @@
+// accounts.go introduces paper trading account requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// AccountController handles paper trading account requests.
+type AccountController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *AccountController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method returns the statement of the user's account with open and pending positions.
+// Closed positions are included if the "all" parameter is true.
+func (this *AccountController) Get() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	all, err := this.GetBool("all", false)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.GameEngine.Statement(user.String(), all))
+}
+
+// Join method opens the position of the user in the proposal.
+// The request body looks like {"proposal": "...", "lots": "0.10"}.
+func (this *AccountController) Join() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		Proposal string         `json:"proposal"`
+		Lots     messages.Price `json:"lots"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.GameEngine.JoinProposal(user.String(), req.Proposal, req.Lots))
+}
+
+// Leave method removes the position of the user from the proposal which isn't filled yet.
+// The request body looks like {"proposal": "..."}.
+func (this *AccountController) Leave() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		Proposal string `json:"proposal"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(req, messages.GameEngine.LeaveProposal(user.String(), req.Proposal))
+}
+
+// Followers method lists the positions of all users who follow the proposal given by the "proposal" parameter.
+// Open positions are valued by the latest quote.
+func (this *AccountController) Followers() {
+	list, err := messages.ListFollowers(db.DB, this.GetString("proposal"))
+	if err == nil {
+		_, err = messages.ValuePositions(list, messages.GameEngine.Quotes())
+	}
+	this.send(list, err)
+}
@@ -0,0 +1,75 @@
+// alerts.go introduces alert rules requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// AlertController handles alert rules of the user.
+type AlertController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *AlertController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method lists the alert rules of the user.
+func (this *AlertController) Get() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.ListAlerts(db.DB, user.String()))
+}
+
+// Post method adds the alert rule of the user. The request body looks like
+// {"kind": "price", "symbol": "EURUSD", "level": "1.23500", "direction": "above"},
+// {"kind": "state", "proposalid": "..."}, {"kind": "author", "authorid": "..."} or {"kind": "mention"}.
+// The direction of the price alert is taken from the current quote if it is omitted.
+// The state alert without the proposal covers all proposals the user has voted on.
+func (this *AlertController) Post() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	a := messages.AlertRule{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &a); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.GameEngine.AddAlert(user.String(), a))
+}
+
+// Delete method removes the alert rule of the user. The request body looks like {"id": "..."}.
+func (this *AlertController) Delete() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		ID string `json:"id"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(req, messages.DeleteAlert(db.DB, user.String(), req.ID))
+}
@@ -0,0 +1,110 @@
+// auth.go identifies users of the requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+	"github.com/satori/go.uuid"
+)
+
+// SessionUser is a session key which stores the ID of the authenticated user.
+const SessionUser = "uid"
+
+var (
+	// errNotAuthenticated is returned when the request is sent by an anonymous user.
+	errNotAuthenticated = messages.NewAPIError(http.StatusUnauthorized, "not_authenticated", "user is not authenticated")
+	// errNotAdmin is returned when the user has no administrator rights.
+	errNotAdmin = messages.NewAPIError(http.StatusForbidden, "not_admin", "user is not an administrator")
+)
+
+// authUser returns the ID of the user who sent the request to the controller c.
+func authUser(c *beego.Controller) (uuid.UUID, error) {
+	idstr, ok := c.GetSession(SessionUser).(string)
+	if !ok || idstr == "" {
+		return uuid.Nil, errNotAuthenticated
+	}
+	return uuid.FromString(idstr)
+}
+
+// isAdmin checks whether the user is listed in the "admins" option of app.conf.
+// The option contains user IDs separated by semicolons.
+func isAdmin(user uuid.UUID) bool {
+	for _, id := range beego.AppConfig.Strings("admins") {
+		if id == user.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// authAdmin returns the ID of the administrator who sent the request to the controller c.
+func authAdmin(c *beego.Controller) (uuid.UUID, error) {
+	user, err := authUser(c)
+	if err != nil {
+		return user, err
+	}
+	if !isAdmin(user) {
+		return user, errNotAdmin
+	}
+	return user, nil
+}
+
+// AuthController registers users and starts their sessions.
+type AuthController struct {
+	beego.Controller
+}
+
+// credentials reads the name and the password from the request body {"name": "...", "password": "..."}.
+func (this *AuthController) credentials() (name, password string, err error) {
+	req := struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{}
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &req)
+	return req.Name, req.Password, err
+}
+
+// login starts the session of the user and writes the user.
+func (this *AuthController) login(u messages.User, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	this.SetSession(SessionUser, u.ID)
+	data, _ := json.Marshal(u)
+	this.Ctx.WriteString(string(data))
+}
+
+// Register method creates the user with the name and the password and starts the session.
+func (this *AuthController) Register() {
+	name, password, err := this.credentials()
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	this.login(messages.Register(db.DB, name, password))
+}
+
+// Login method starts the session of the user with the name and the password.
+func (this *AuthController) Login() {
+	name, password, err := this.credentials()
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	this.login(messages.Login(db.DB, name, password))
+}
+
+// Logout method finishes the session of the user.
+func (this *AuthController) Logout() {
+	this.DelSession(SessionUser)
+	this.Ctx.WriteString("{}")
+}
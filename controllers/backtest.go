@@ -0,0 +1,119 @@
+// backtest.go introduces backtest requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+	"github.com/satori/go.uuid"
+)
+
+// BacktestController handles backtest requests.
+type BacktestController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *BacktestController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Post method backtests proposals against historical ticks and returns the list of messages.BacktestResult.
+// The proposals are given by "ids" of stored proposals, by the list of hypothetical "proposals"
+// and by the batch of all stored proposals selected by "symbol", "author", "from" and "to" creation times.
+// Ticks are read from the file "ticks" of the directory given by "tickdir" option of app.conf
+// or from the CSV text "csv". The request body looks like {"ids": ["..."], "ticks": "eurusd-2017-04.csv"}.
+func (this *BacktestController) Post() {
+	if _, err := authUser(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		IDs       []string            `json:"ids"`
+		Proposals []messages.Proposal `json:"proposals"`
+		Symbol    string              `json:"symbol"`
+		Author    string              `json:"author"`
+		From      int64               `json:"from"`
+		To        int64               `json:"to"`
+		Ticks     string              `json:"ticks"`
+		CSV       string              `json:"csv"`
+	}{}
+	if err := json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	proposals := req.Proposals
+	for _, id := range req.IDs {
+		pid, err := uuid.FromString(id)
+		if err != nil {
+			this.send(nil, err)
+			return
+		}
+		p := messages.Proposal{}
+		data, err := db.DB.Read(db.PROPOSALS, pid.Bytes())
+		if err == nil {
+			err = json.Unmarshal(data, &p)
+		}
+		if err != nil {
+			this.send(nil, err)
+			return
+		}
+		proposals = append(proposals, p)
+	}
+	if req.Symbol != "" || req.Author != "" {
+		// The batch is read page by page until the last one
+		f := messages.ProposalFilter{Symbol: req.Symbol, Author: req.Author, CreatedFrom: req.From, CreatedTo: req.To, Limit: messages.MaxPageSize}
+		for {
+			page, err := messages.ListProposals(db.DB, f)
+			if err != nil {
+				this.send(nil, err)
+				return
+			}
+			proposals = append(proposals, page.Data...)
+			if page.Next == "" {
+				break
+			}
+			f.Cursor = page.Next
+		}
+	}
+	if len(proposals) == 0 {
+		this.send(nil, messages.BadRequest("no proposals to backtest"))
+		return
+	}
+	var src messages.FeedSource
+	var err error
+	switch {
+	case req.CSV != "":
+		src = messages.NewCSVSource(ioutil.NopCloser(strings.NewReader(req.CSV)))
+	case req.Ticks != "" && beego.AppConfig.String("tickdir") != "":
+		// Only the files of the tick directory are available
+		src, err = messages.OpenTicks(filepath.Join(beego.AppConfig.String("tickdir"), filepath.Base(req.Ticks)))
+		if os.IsNotExist(err) {
+			err = messages.NewAPIError(http.StatusNotFound, messages.CodeNotFound, "tick file is not found")
+		}
+	default:
+		err = messages.BadRequest("ticks or csv must be given")
+	}
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	defer src.Close()
+	this.send(messages.Backtest(db.DB, proposals, src))
+}
@@ -0,0 +1,51 @@
+// candles.go introduces chart data requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// CandleController handles chart data requests.
+type CandleController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *CandleController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method returns OHLC candles of the instrument given by "symbol" and "tf" (1m, 5m, 1h or 1d) parameters.
+// Optional "from" and "to" parameters limit the range of candles in UNIX milliseconds,
+// "limit" is the maximal number of the most recent candles within the range.
+func (this *CandleController) Get() {
+	from, err := this.GetInt64("from", 0)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	to, err := this.GetInt64("to", 0)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	limit, err := this.GetInt("limit", messages.DefaultCandles)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	symbol, tf := this.GetString("symbol", messages.DefaultSymbol), this.GetString("tf", "1m")
+	this.send(messages.GameEngine.Candles(symbol, tf, from, to, limit))
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"encoding/json"
+	"time"
 	"github.com/astaxie/beego"
 	"union/messages"
 	"union/db"
@@ -13,24 +15,143 @@ type ChatController struct {
 }
 
 // Get method handles Chat requests for ChatController.
+// If the id parameter is given the chat bucket is returned.
+// Buckets of private conversations are returned only to their participants.
+// Otherwise the page of messages of the room(general by default) written before
+// the "before" cursor is returned. The newest page is returned if "before" is missing.
 func (this *ChatController) Get() {
+	if this.GetString("id") == "" {
+		this.page()
+		return
+	}
 	// Read url param
 	idbytes, err := chatUUIDstring(this.GetString("id"))
 	if err != nil {
-		messages.SendError(this.Ctx.WriteString, err)
+		sendError(this.Ctx, err)
 		return
 	}
+	// Anonymous users get public buckets only
+	var userID string
+	if user, err := authUser(&this.Controller); err == nil {
+		userID = user.String()
+	}
 	// Read the underlying data
-	var data []byte
-	data, err = db.DB.Read(db.CHAT, idbytes)
+	var cb messages.ChatBucket
+	cb, err = messages.ReadBucket(db.DB, uuid.FromBytesOrNil(idbytes), userID)
 	if err != nil {
-		messages.SendError(this.Ctx.WriteString, err)
+		sendError(this.Ctx, err)
 		return
 	}
+	data, _ := json.Marshal(cb)
 	this.Ctx.WriteString(string(data))
 	return
 }
 
+// page writes the page of chat messages.
+func (this *ChatController) page() {
+	limit, err := this.GetInt("limit", messages.DefaultChatPage)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	var page messages.ChatPage
+	// Private conversations are not available here
+	room := this.GetString("room", messages.GeneralRoom)
+	if _, _, err = messages.ParseRoomID(room); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	page, err = messages.ChatHistory(db.DB, room, this.GetString("before"), limit)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(page)
+	this.Ctx.WriteString(string(data))
+}
+
+// Post method posts the message to the chat room.
+// The request body looks like {"room": "general", "text": "Hello", "replyto": "...", "mentions": ["..."]}.
+// replyto and mentions are optional.
+func (this *ChatController) Post() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	m := messages.ChatMessage{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &m); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	if m.Room == "" {
+		m.Room = messages.GeneralRoom
+	}
+	if m.Text == "" {
+		sendError(this.Ctx, messages.FieldErrors{"text": "must not be empty"})
+		return
+	}
+	m.AuthorID = author.String()
+	m.Time = time.Now().Unix()
+	if err = messages.GameEngine.PostChat(m.Room, m); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(m)
+	this.Ctx.WriteString(string(data))
+}
+
+// Edit method replaces the text of the message of the authenticated user.
+// The request body looks like {"id": "...", "text": "Hello"}.
+func (this *ChatController) Edit() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	req := struct {
+		ID   string `json:"id"`
+		Text string `json:"text"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	m, err := messages.GameEngine.EditChat(author.String(), req.ID, req.Text)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(m)
+	this.Ctx.WriteString(string(data))
+}
+
+// React method adds or removes the reaction of the authenticated user to the message.
+// The request body looks like {"id": "...", "emoji": "👍", "remove": false}.
+func (this *ChatController) React() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	req := struct {
+		ID     string `json:"id"`
+		Emoji  string `json:"emoji"`
+		Remove bool   `json:"remove"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	m, err := messages.GameEngine.React(user.String(), req.ID, req.Emoji, !req.Remove)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(m)
+	this.Ctx.WriteString(string(data))
+}
+
 // Converts idstr into the uuid byte sequence.
 // If idstr is empty it fetches the last bucket
 func chatUUIDstring(idstr string) (idbytes []byte, err error) {
@@ -0,0 +1,102 @@
+// direct.go introduces private messages between traders
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+	"time"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// DirectController handles private message requests.
+type DirectController struct {
+	beego.Controller
+}
+
+// Get method lists the conversations of the authenticated user.
+// If the "with" parameter is given it returns the page of the conversation with that user
+// and marks the conversation as read. The page is defined by "before" and "limit" parameters.
+func (this *DirectController) Get() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	var v interface{}
+	if with := this.GetString("with"); with == "" {
+		v, err = this.conversations(user)
+	} else {
+		v, err = this.history(user, with)
+	}
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// conversations returns the page of conversations of the user.
+func (this *DirectController) conversations(user uuid.UUID) ([]messages.Conversation, error) {
+	before, err := this.GetInt64("before", 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "before")
+	}
+	var limit int
+	if limit, err = this.GetInt("limit", messages.DefaultPageSize); err != nil {
+		return nil, errors.Wrap(err, "limit")
+	}
+	return messages.ListConversations(db.DB, user.String(), before, limit)
+}
+
+// history returns the page of the conversation between the user and the user with.
+func (this *DirectController) history(user uuid.UUID, with string) (page messages.ChatPage, err error) {
+	var limit int
+	if limit, err = this.GetInt("limit", messages.DefaultChatPage); err != nil {
+		return
+	}
+	if page, err = messages.ConversationHistory(db.DB, user.String(), with, this.GetString("before"), limit); err != nil {
+		return
+	}
+	err = messages.GameEngine.MarkRead(messages.ConversationID(user.String(), with), user.String())
+	return
+}
+
+// Post method sends the private message.
+// The request body looks like {"to": "<user id>", "text": "Hello"}.
+func (this *DirectController) Post() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	req := struct {
+		To      string `json:"to"`
+		Text    string `json:"text"`
+		ReplyTo string `json:"replyto"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	if req.Text == "" {
+		sendError(this.Ctx, messages.FieldErrors{"text": "must not be empty"})
+		return
+	}
+	m := messages.ChatMessage{AuthorID: author.String(), Text: req.Text, Time: time.Now().Unix(), ReplyTo: req.ReplyTo}
+	var conv messages.Conversation
+	if conv, err = messages.GameEngine.SendDirect(req.To, m); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(conv)
+	this.Ctx.WriteString(string(data))
+}
@@ -0,0 +1,47 @@
+// errors.go sends errors to API clients
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/messages"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/satori/go.uuid"
+)
+
+// RequestIDHeader is the header which carries the ID of the request.
+// The ID sent by the client is kept, otherwise a new one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// requestID returns the ID of the request and adds it to the response headers.
+func requestID(ctx *context.Context) string {
+	if id, ok := ctx.Input.GetData(RequestIDHeader).(string); ok {
+		return id
+	}
+	id := ctx.Input.Header(RequestIDHeader)
+	if id == "" || len(id) > 64 {
+		id = uuid.NewV4().String()
+	}
+	ctx.Input.SetData(RequestIDHeader, id)
+	ctx.Output.Header(RequestIDHeader, id)
+	return id
+}
+
+// sendError writes the error as json with the corresponding HTTP status.
+// Internal errors are logged because their messages are not sent to the client.
+func sendError(ctx *context.Context, err error) {
+	ae := messages.ToAPIError(err)
+	ae.RequestID = requestID(ctx)
+	if ae.Status >= 500 {
+		beego.Error("Request", ae.RequestID, "failed:", err)
+	}
+	data, _ := json.Marshal(ae)
+	ctx.Output.Header("Content-Type", "application/json; charset=utf-8")
+	ctx.Output.SetStatus(ae.Status)
+	ctx.Output.Body(data)
+}
@@ -0,0 +1,138 @@
+// forum.go introduces forum functionality
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+	"time"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// ForumController handles forum requests.
+type ForumController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *ForumController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Categories method lists all forum categories.
+func (this *ForumController) Categories() {
+	this.send(messages.ListCategories(db.DB))
+}
+
+// AddCategory method creates or updates the forum category. It is available for administrators only.
+// The request body looks like {"id": "strategies", "title": "Strategies", "description": "..."}.
+func (this *ForumController) AddCategory() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	c := messages.Category{}
+	if err := json.Unmarshal(this.Ctx.Input.RequestBody, &c); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(c, messages.AddCategory(db.DB, c))
+}
+
+// Threads method lists the threads of the category given by "category", "cursor" and "limit" parameters.
+func (this *ForumController) Threads() {
+	limit, err := this.GetInt("limit", messages.DefaultPageSize)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.ListThreads(db.DB, this.GetString("category"), this.GetString("cursor"), limit))
+}
+
+// AddThread method creates a new thread of the authenticated user.
+// The request body looks like {"category": "strategies", "title": "Title", "text": "First post"}.
+func (this *ForumController) AddThread() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		messages.Thread
+		Text string `json:"text"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	req.AuthorID = author.String()
+	this.send(messages.CreateThread(db.DB, req.Thread, req.Text, time.Now().Unix()))
+}
+
+// ThreadFlags method pins or locks the thread. It is available for administrators only.
+// The request body looks like {"id": "<thread id>", "pinned": true, "locked": false}.
+func (this *ForumController) ThreadFlags() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	t := messages.Thread{}
+	if err := json.Unmarshal(this.Ctx.Input.RequestBody, &t); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.SetThreadFlags(db.DB, t.ID, t.Pinned, t.Locked))
+}
+
+// Posts method lists the posts of the thread given by "thread", "cursor" and "limit" parameters.
+func (this *ForumController) Posts() {
+	limit, err := this.GetInt("limit", messages.DefaultPageSize)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.ListPosts(db.DB, this.GetString("thread"), this.GetString("cursor"), limit))
+}
+
+// AddPost method replies to the thread or to the post.
+// The request body looks like {"thread": "<thread id>", "parent": "<post id>", "text": "Reply"}.
+func (this *ForumController) AddPost() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	p := messages.Post{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &p); err != nil {
+		this.send(nil, err)
+		return
+	}
+	p.AuthorID = author.String()
+	this.send(messages.Reply(db.DB, p, time.Now().Unix()))
+}
+
+// EditPost method changes the text of the post of the authenticated user.
+// The request body looks like {"id": "<post id>", "text": "New text"}.
+func (this *ForumController) EditPost() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	p := messages.Post{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &p); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.EditPost(db.DB, p.ID, author.String(), p.Text, time.Now().Unix()))
+}
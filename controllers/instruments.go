@@ -0,0 +1,68 @@
+// instruments.go introduces the instrument catalogue requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// InstrumentController handles instrument catalogue requests.
+type InstrumentController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *InstrumentController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method lists all instruments or returns the instrument given by the symbol parameter.
+func (this *InstrumentController) Get() {
+	if symbol := this.GetString("symbol"); symbol != "" {
+		this.send(messages.ReadInstrument(db.DB, symbol))
+		return
+	}
+	this.send(messages.ListInstruments(db.DB))
+}
+
+// Post method creates or updates the instrument. It is available for administrators only.
+// The request body looks like {"symbol": "EURUSD", "name": "Euro / US Dollar", "ticksize": "0.00001",
+// "pipsize": "0.0001", "spread": "0.0002", "contractsize": 100000, "hours": [{"day": 1, "open": 0, "close": 1440}]}.
+func (this *InstrumentController) Post() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	inst := messages.Instrument{}
+	if err := json.Unmarshal(this.Ctx.Input.RequestBody, &inst); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(inst, messages.SaveInstrument(db.DB, inst))
+}
+
+// Delete method removes the instrument given by the symbol parameter. It is available for administrators only.
+func (this *InstrumentController) Delete() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	symbol := this.GetString("symbol")
+	if symbol == "" {
+		this.send(nil, messages.BadRequest("symbol parameter is missing"))
+		return
+	}
+	this.send(struct{}{}, messages.DeleteInstrument(db.DB, symbol))
+}
@@ -0,0 +1,73 @@
+// leaderboards.go introduces leaderboards and trading seasons requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// LeaderboardController handles leaderboards and trading seasons requests.
+type LeaderboardController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *LeaderboardController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method returns the leaderboard given by "metric" (pnl by default) and "period" (7d by default) parameters.
+// The number of places is limited by "limit" parameter.
+func (this *LeaderboardController) Get() {
+	limit, err := this.GetInt("limit", messages.LeaderboardSize)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	lb, err := messages.ReadLeaderboard(db.DB, this.GetString("metric", messages.MetricPnL), this.GetString("period", "7d"))
+	if err == nil && limit >= 0 && len(lb.Entries) > limit {
+		lb.Entries = lb.Entries[:limit]
+	}
+	this.send(lb, err)
+}
+
+// Refresh method recomputes all leaderboards. It is available for administrators only.
+func (this *LeaderboardController) Refresh() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(struct{}{}, messages.GameEngine.RefreshLeaderboards())
+}
+
+// Seasons method lists all trading seasons from the latest one.
+func (this *LeaderboardController) Seasons() {
+	this.send(messages.ListSeasons(db.DB))
+}
+
+// AddSeason method adds the trading season. It is available for administrators only.
+// The request body looks like {"id": "2024-q1", "name": "Winter 2024", "start": 1704067200, "end": 1711929600}.
+func (this *LeaderboardController) AddSeason() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	s := messages.Season{}
+	if err := json.Unmarshal(this.Ctx.Input.RequestBody, &s); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.AddSeason(db.DB, s))
+}
@@ -0,0 +1,105 @@
+// moderation.go introduces chat moderation requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// ModerationController handles moderation requests.
+type ModerationController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *ModerationController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Message method deletes, hides or unhides the chat message.
+// The request body looks like {"id": "...", "action": "hide", "reason": "spam"}.
+func (this *ModerationController) Message() {
+	mod, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		ID     string `json:"id"`
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(req, messages.GameEngine.ModerateMessage(mod.String(), req.ID, req.Action, req.Reason))
+}
+
+// Sanction method mutes or bans the user till the UNIX time "until".
+// The request body looks like {"userid": "...", "kind": "mute", "room": "general", "until": 1500000000, "reason": "flood"}.
+// Empty room applies the sanction to all rooms, "until" in the past lifts the sanction.
+func (this *ModerationController) Sanction() {
+	mod, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	s := messages.Sanction{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &s); err != nil {
+		this.send(nil, err)
+		return
+	}
+	s.ModeratorID = mod.String()
+	this.send(s, messages.GameEngine.Sanction(s))
+}
+
+// Log method lists moderation actions by "cursor" and "limit" parameters. It is available for moderators only.
+func (this *ModerationController) Log() {
+	mod, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	if !isAdmin(mod) && !messages.IsModerator(db.DB, mod.String()) {
+		this.send(nil, messages.ErrNotModerator)
+		return
+	}
+	limit, err := this.GetInt("limit", messages.DefaultPageSize)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.ListModLog(db.DB, this.GetString("cursor"), limit))
+}
+
+// Role method sets the role of the user. It is available for administrators only.
+// The request body looks like {"userid": "...", "role": "moderator"}.
+func (this *ModerationController) Role() {
+	admin, err := authAdmin(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		UserID string `json:"userid"`
+		Role   string `json:"role"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(req, messages.GameEngine.SetRole(admin.String(), req.UserID, req.Role))
+}
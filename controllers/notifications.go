@@ -0,0 +1,93 @@
+// notifications.go introduces the notification inbox requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// NotificationController handles the notification inbox of the user.
+type NotificationController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *NotificationController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// readResult is the response of read requests: the number of notifications which were
+// marked as read and the number of remaining unread ones.
+type readResult struct {
+	Read   int `json:"read"`
+	Unread int `json:"unread"`
+}
+
+// Get method returns the page of notifications of the user from the most recent one
+// with the number of unread notifications. Only unread notifications are listed if "unread" parameter is true.
+// "cursor" and "limit" are pagination parameters.
+func (this *NotificationController) Get() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	unread, err := this.GetBool("unread", false)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	limit, err := this.GetInt("limit", messages.DefaultNotifications)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.ListNotifications(db.DB, user.String(), this.GetString("cursor"), unread, limit))
+}
+
+// Read method marks the notifications of the user as read. The request body looks like {"ids": ["...", "..."]}.
+func (this *NotificationController) Read() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		IDs []string `json:"ids"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	res := readResult{}
+	if res.Read, err = messages.MarkRead(db.DB, user.String(), req.IDs); err == nil {
+		res.Unread, err = messages.CountUnread(db.DB, user.String())
+	}
+	this.send(res, err)
+}
+
+// ReadAll method marks all notifications of the user as read.
+func (this *NotificationController) ReadAll() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	res := readResult{}
+	if res.Read, err = messages.MarkAllRead(db.DB, user.String()); err == nil {
+		res.Unread, err = messages.CountUnread(db.DB, user.String())
+	}
+	this.send(res, err)
+}
@@ -6,6 +6,8 @@
 package controllers
 
 import (
+	"encoding/json"
+
 	"union/db"
 	"union/messages"
 
@@ -24,23 +26,200 @@ func (this *ProposalController) Get() {
 	// Check the id for availability
 	idstr := this.GetString("id")
 	if idstr == "" {
-		messages.SendError(this.Ctx.WriteString, errors.New("id parameter is missing"))
+		sendError(this.Ctx, messages.BadRequest("id parameter is missing"))
 		return
 	}
 	// Convert id string into the uuid
 	id, err := uuid.FromString(idstr)
 	if err != nil {
-		messages.SendError(this.Ctx.WriteString, err)
+		sendError(this.Ctx, err)
 		return
 	}
 	// Read the data
 	var data []byte
 	data, err = db.DB.Read(db.PROPOSALS, id.Bytes())
 	if err != nil {
-		messages.SendError(this.Ctx.WriteString, err)
+		sendError(this.Ctx, err)
 		return
 	}
 	// Write the response
 	this.Ctx.WriteString(string(data))
 	return
 }
+
+// Post method creates a new proposal from the JSON request body.
+// The author of the proposal is the authenticated user.
+func (this *ProposalController) Post() {
+	author, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	// Parse and validate the proposal
+	p := messages.Proposal{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &p); err != nil {
+		sendError(this.Ctx, errors.Wrap(err, "cannot parse the proposal"))
+		return
+	}
+	p.AuthorID = author.String()
+	p.History = nil
+	var inst *messages.Instrument
+	if inst, err = messages.LookupInstrument(db.DB, p.Symbol); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	if err = p.Validate(messages.GameClock.Now().Unix(), inst); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	// The price is checked against the market if the quote is known
+	if q, ok := messages.GameEngine.Quotes().Get(p.Symbol); ok {
+		if err = p.CheckMarket(q); err != nil {
+			sendError(this.Ctx, err)
+			return
+		}
+	}
+	// Store the proposal
+	var id uuid.UUID
+	id, err = messages.GameEngine.AddProposal(p)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	// Write the created proposal
+	var data []byte
+	data, err = db.DB.Read(db.PROPOSALS, id.Bytes())
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	this.Ctx.WriteString(string(data))
+}
+
+// Vote method adds the vote of the authenticated user to the proposal and writes the updated proposal.
+// The request body looks like {"proposal": "..."}.
+func (this *ProposalController) Vote() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	req := struct {
+		Proposal string `json:"proposal"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	var id uuid.UUID
+	if id, err = uuid.FromString(req.Proposal); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	if err = messages.GameEngine.VoteProposal(id, user); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	var data []byte
+	data, err = db.DB.Read(db.PROPOSALS, id.Bytes())
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	this.Ctx.WriteString(string(data))
+}
+
+// ProposalsController handles the requests for the list of proposals.
+type ProposalsController struct {
+	beego.Controller
+}
+
+// Get method lists the proposals which satisfy the url parameters:
+//	state, type - proposal state and type
+//	author - ID of the author
+//	symbol - instrument of the proposal
+//	createdfrom, createdto, deadlinefrom, deadlineto - UNIX timestamp ranges
+//	minscore - minimal score of the proposal
+//	sort - "created"(default), "deadline" or "score"
+//	order - "asc"(default) or "desc"
+//	cursor, limit - pagination parameters
+func (this *ProposalsController) Get() {
+	f, err := this.filter()
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	var page messages.ProposalPage
+	page, err = messages.ListProposals(db.DB, f)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(page)
+	this.Ctx.WriteString(string(data))
+}
+
+// filter reads the proposal filter from url parameters.
+func (this *ProposalsController) filter() (f messages.ProposalFilter, err error) {
+	f.State, err = this.getByte("state")
+	if err != nil {
+		return
+	}
+	f.Type, err = this.getByte("type")
+	if err != nil {
+		return
+	}
+	if f.Author = this.GetString("author"); f.Author != "" {
+		if _, err = uuid.FromString(f.Author); err != nil {
+			return
+		}
+	}
+	f.Symbol = this.GetString("symbol")
+	for param, v := range map[string]*int64{
+		"createdfrom":  &f.CreatedFrom,
+		"createdto":    &f.CreatedTo,
+		"deadlinefrom": &f.DeadlineFrom,
+		"deadlineto":   &f.DeadlineTo,
+	} {
+		if *v, err = this.GetInt64(param, 0); err != nil {
+			err = errors.Wrap(err, param)
+			return
+		}
+	}
+	if f.MinScore, err = this.GetFloat("minscore", 0); err != nil {
+		err = errors.Wrap(err, "minscore")
+		return
+	}
+	switch f.Sort = this.GetString("sort", messages.SortCreated); f.Sort {
+	case messages.SortCreated, messages.SortDeadline, messages.SortScore:
+	default:
+		err = messages.BadRequest("sort parameter must be created, deadline or score")
+		return
+	}
+	switch this.GetString("order", "asc") {
+	case "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		err = messages.BadRequest("order parameter must be asc or desc")
+		return
+	}
+	f.Cursor = this.GetString("cursor")
+	if f.Limit, err = this.GetInt("limit", messages.DefaultPageSize); err != nil {
+		err = errors.Wrap(err, "limit")
+	}
+	return
+}
+
+// getByte reads the optional byte url parameter.
+func (this *ProposalsController) getByte(param string) (*byte, error) {
+	if this.GetString(param) == "" {
+		return nil, nil
+	}
+	v, err := this.GetInt(param)
+	if err != nil || v < 0 || v > 255 {
+		return nil, messages.BadRequest(param + " parameter must be within the range [0, 255]")
+	}
+	b := byte(v)
+	return &b, nil
+}
@@ -0,0 +1,36 @@
+// quotes.go introduces market quote requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// QuoteController handles market quote requests.
+type QuoteController struct {
+	beego.Controller
+}
+
+// Get method returns the latest quotes of all instruments or the quote of the instrument given by the symbol parameter.
+func (this *QuoteController) Get() {
+	var v interface{}
+	if symbol := this.GetString("symbol"); symbol != "" {
+		q, ok := messages.GameEngine.Quotes().Get(symbol)
+		if !ok {
+			sendError(this.Ctx, messages.NewAPIError(http.StatusNotFound, messages.CodeNotFound, "quote is not found"))
+			return
+		}
+		v = q
+	} else {
+		v = messages.GameEngine.Quotes().All()
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
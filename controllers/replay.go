@@ -0,0 +1,84 @@
+// replay.go introduces the control of the tick replay
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// errNoReplay is returned when the server doesn't replay ticks.
+var errNoReplay = messages.NewAPIError(http.StatusNotFound, messages.CodeNotFound, "replay is not running")
+
+// ReplayController handles tick replay requests. They are available for administrators only.
+type ReplayController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *ReplayController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// replay returns the current replay if the user is an administrator.
+func (this *ReplayController) replay() (*messages.Replay, error) {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		return nil, err
+	}
+	if messages.CurrentReplay == nil {
+		return nil, errNoReplay
+	}
+	return messages.CurrentReplay, nil
+}
+
+// Get method returns the progress of the replay.
+func (this *ReplayController) Get() {
+	r, err := this.replay()
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(r.Status(), nil)
+}
+
+// Post method changes the mode of the replay and returns its progress.
+// {"speed": 10} replays ticks 10 times faster than they were recorded, {"speed": 0} as fast as possible,
+// {"pause": true} switches to the stepwise mode and {"step": 5} releases 5 ticks in the stepwise mode.
+func (this *ReplayController) Post() {
+	r, err := this.replay()
+	if err != nil {
+		this.send(nil, err)
+		return
+	}
+	req := struct {
+		Speed *float64 `json:"speed"`
+		Pause bool     `json:"pause"`
+		Step  int      `json:"step"`
+	}{}
+	if err = json.Unmarshal(this.Ctx.Input.RequestBody, &req); err != nil {
+		this.send(nil, err)
+		return
+	}
+	switch {
+	case req.Speed != nil && *req.Speed < 0:
+		this.send(nil, messages.FieldErrors{"speed": "must not be negative"})
+		return
+	case req.Speed != nil:
+		r.SetSpeed(*req.Speed)
+	case req.Pause:
+		r.Pause()
+	}
+	r.Step(req.Step)
+	this.send(r.Status(), nil)
+}
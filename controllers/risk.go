@@ -0,0 +1,59 @@
+// risk.go introduces the administration of risk rules
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// RiskController handles risk rules requests. They are available for administrators only.
+type RiskController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *RiskController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method returns the risk rules with the current exposure of users and instruments.
+func (this *RiskController) Get() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	this.send(messages.GameEngine.Exposure())
+}
+
+// Post method replaces the risk rules. The request body looks like
+// {"maxpositions": 10, "maxlots": "5.00", "maxexposure": "20.00", "dailyloss": "500.00", "minstoppips": "10"}.
+// Omitted or zero rules mean no limit.
+func (this *RiskController) Post() {
+	if _, err := authAdmin(&this.Controller); err != nil {
+		this.send(nil, err)
+		return
+	}
+	rules := messages.RiskRules{}
+	if err := json.Unmarshal(this.Ctx.Input.RequestBody, &rules); err != nil {
+		this.send(nil, err)
+		return
+	}
+	if rules.MaxPositions < 0 || rules.MaxLots.Sign() < 0 || rules.MaxExposure.Sign() < 0 ||
+		rules.DailyLoss.Sign() < 0 || rules.MinStopPips.Sign() < 0 {
+		this.send(nil, messages.BadRequest("risk rules must not be negative"))
+		return
+	}
+	messages.GameEngine.SetRiskRules(rules)
+	this.send(rules, nil)
+}
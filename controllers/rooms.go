@@ -0,0 +1,72 @@
+// rooms.go introduces chat room functionality
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// RoomController handles chat room requests.
+type RoomController struct {
+	beego.Controller
+}
+
+// Get method lists all chat rooms or returns the room with the given id.
+func (this *RoomController) Get() {
+	var v interface{}
+	var err error
+	if id := this.GetString("id"); id != "" {
+		v, err = messages.ReadRoom(db.DB, id)
+	} else {
+		v, err = messages.ListRooms(db.DB)
+	}
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Join method adds the authenticated user to the room given by the id parameter.
+func (this *RoomController) Join() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	var room messages.Room
+	room, err = messages.GameEngine.JoinRoom(this.GetString("id"), user.String())
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(room)
+	this.Ctx.WriteString(string(data))
+}
+
+// Leave method removes the authenticated user from the room given by the id parameter.
+func (this *RoomController) Leave() {
+	user, err := authUser(&this.Controller)
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	id := this.GetString("id")
+	if id == "" {
+		sendError(this.Ctx, messages.BadRequest("id parameter is missing"))
+		return
+	}
+	if err = messages.GameEngine.LeaveRoom(id, user.String()); err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	this.Ctx.WriteString("{}")
+}
@@ -0,0 +1,45 @@
+// stats.go introduces performance statistics requests
+// 866
+// All Rights Reserved
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"union/db"
+	"union/messages"
+
+	"github.com/astaxie/beego"
+)
+
+// StatsController handles performance statistics requests.
+type StatsController struct {
+	beego.Controller
+}
+
+// send writes the result v as json or the error err.
+func (this *StatsController) send(v interface{}, err error) {
+	if err != nil {
+		sendError(this.Ctx, err)
+		return
+	}
+	data, _ := json.Marshal(v)
+	this.Ctx.WriteString(string(data))
+}
+
+// Get method returns the statistics of the user given by "user" parameter in the role given by
+// "role" parameter: author (by default) or trader. The authenticated user gets own statistics
+// if the user is omitted.
+func (this *StatsController) Get() {
+	userID := this.GetString("user")
+	if userID == "" {
+		user, err := authUser(&this.Controller)
+		if err != nil {
+			this.send(nil, err)
+			return
+		}
+		userID = user.String()
+	}
+	this.send(messages.ReadStats(db.DB, this.GetString("role", messages.RoleAuthor), userID))
+}
@@ -6,9 +6,8 @@ package controllers
 
 import (
 	"fmt"
-	"math/rand"
 	"net/http"
-	"time"
+	"strings"
 
 	"union/messages"
 
@@ -44,32 +43,42 @@ func (this *WebSocketController) Get() {
 	}
 	// Upgrade from http request to WebSocket.
 	ws, err := u.Upgrade(this.Ctx.ResponseWriter, this.Ctx.Request, nil)
-	defer ws.Close()
 	if _, ok := err.(websocket.HandshakeError); ok {
-		http.Error(this.Ctx.ResponseWriter, "Not a websocket handshake", 400)
+		sendError(this.Ctx, messages.BadRequest("not a websocket handshake"))
 		return
 	} else if err != nil {
 		beego.Error("Cannot setup WebSocket connection:", err)
 		return
 	}
+	defer ws.Close()
 	beego.Info(fmt.Sprintf("Websocket connection: %s", ws.RemoteAddr().String()))
 
-	// Generating random data
-	wsdata := messages.WSData{}
-	wsdata.FillRandom(0, 40, 0, 15)
-	send, err := wsdata.Jsonify()
-	if err != nil {
-		beego.BeeLogger.Error("wsdata.Jsonify error: %#v", err)
-		return
+	// Register the client in the game engine.
+	// Anonymous users receive only public messages.
+	var userID string
+	if user, err := authUser(&this.Controller); err == nil {
+		userID = user.String()
 	}
-	// Send messages until everything ok.
-	for ws.WriteMessage(websocket.TextMessage, send) == nil {
-		time.Sleep(time.Millisecond * time.Duration(rand.Intn(3000)+900))
-		wsdata.FillRandom(0, 40, 0, 15)
-		send, err = wsdata.Jsonify()
+	client := messages.NewClient(ws, userID)
+	messages.GameEngine.AddClient(client)
+	defer messages.GameEngine.RemoveClient(client)
+	// Quotes of the instruments listed in the quotes parameter are sent right away.
+	if quotes := this.GetString("quotes"); quotes != "" {
+		client.Subscribe(strings.Split(quotes, ","), true)
+	}
+	// Notifications stored while the user was offline are sent first
+	if err = messages.GameEngine.DeliverNotifications(client); err != nil {
+		beego.Error("Cannot deliver notifications:", err)
+	}
+	// The engine sends messages to the client while its commands are read
+	// until the connection is closed. Market data comes from the price feed.
+	for {
+		_, data, err := ws.ReadMessage()
 		if err != nil {
-			beego.BeeLogger.Error("wsdata.Jsonify error: %#v", err)
-			return
+			break
+		}
+		if err = client.HandleCommand(data); err != nil {
+			client.SendError(err)
 		}
 	}
 	beego.BeeLogger.Info("Disconnected: %s", ws.RemoteAddr().String())
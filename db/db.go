@@ -6,14 +6,29 @@
 package db
 
 // DBHandler is an instrument for working with any key-value storage.
-// It can write, read and update values.
+// It can write, read, update, delete and scan values.
 type DBHandler interface {
 	Read(string, []byte) ([]byte, error)
 	Write(string, []byte, []byte) error
 	Modify(string, []byte, Modifier) error
+	ModifyAll(...Change) error
+	Delete(string, []byte) error
+	Scan(string, []byte, bool, Visitor) error
+	Rebuild(string) error
 	Close()
 }
 
+// Change is the modification of the value at address Key of the database DB by Modifier.
+type Change struct {
+	DB       string
+	Key      []byte
+	Modifier Modifier
+}
+
+// Visitor is called for every key-value pair found by Scan.
+// Scan stops when Visitor returns false.
+type Visitor func(key, val []byte) bool
+
 const (
 	// CHAT names the db which stores chat messages.
 	CHAT = "chat"
@@ -25,6 +40,38 @@ const (
 	USERS = "users"
 	// DYNAMIC names dynamic db which stores dynamic data of proposals.
 	DYNAMIC = "dynamic"
+	// ROOMS names the db which stores chat rooms and their members.
+	ROOMS = "rooms"
+	// CONVERSATIONS names the db which stores private conversations between users.
+	CONVERSATIONS = "conversations"
+	// CATEGORIES names the db which stores forum categories.
+	CATEGORIES = "categories"
+	// THREADS names the db which stores forum threads.
+	THREADS = "threads"
+	// POSTS names the db which stores forum posts and their revisions.
+	POSTS = "posts"
+	// SANCTIONS names the db which stores mutes and bans of users.
+	SANCTIONS = "sanctions"
+	// MODLOG names the db which records moderation actions.
+	MODLOG = "modlog"
+	// INSTRUMENTS names the db which stores the catalogue of traded instruments.
+	INSTRUMENTS = "instruments"
+	// CANDLES names the db which stores OHLC candles of instruments.
+	CANDLES = "candles"
+	// ACCOUNTS names the db which stores paper trading accounts of users.
+	ACCOUNTS = "accounts"
+	// POSITIONS names the db which stores positions of users in proposals.
+	POSITIONS = "positions"
+	// LEADERBOARDS names the db which stores precomputed leaderboards.
+	LEADERBOARDS = "leaderboards"
+	// SEASONS names the db which stores trading seasons.
+	SEASONS = "seasons"
+	// STATS names the db which stores performance statistics of users.
+	STATS = "stats"
+	// ALERTS names the db which stores alert rules of users.
+	ALERTS = "alerts"
+	// NOTIFICATIONS names the db which stores notifications of users.
+	NOTIFICATIONS = "notifications"
 )
 
 var (
@@ -39,5 +86,7 @@ var (
 func init() {
 	// Initialize db stuff
 	LastCB = []byte{0}
-	DBList = []string{PRIVATE, PROPOSALS, USERS, CHAT, DYNAMIC}
+	DBList = []string{PRIVATE, PROPOSALS, USERS, CHAT, DYNAMIC, ROOMS, CONVERSATIONS,
+		CATEGORIES, THREADS, POSTS, SANCTIONS, MODLOG, INSTRUMENTS, CANDLES, ACCOUNTS, POSITIONS,
+		LEADERBOARDS, SEASONS, STATS, ALERTS, NOTIFICATIONS}
 }
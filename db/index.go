@@ -0,0 +1,91 @@
+// index.go introduces secondary indexes of the databases
+// 866
+// All Rights Reserved
+
+package db
+
+import (
+	"bytes"
+)
+
+// KeyFunc extracts the index keys from the record val stored at address key.
+// It returns nil if the record shouldn't be indexed.
+type KeyFunc func(key, val []byte) [][]byte
+
+// Index is a secondary index of the database DB.
+// The index is stored in the separate database Name.
+// Every index entry has the key which consists of the extracted key and the primary key.
+// The value of the entry is the primary key.
+type Index struct {
+	Name string
+	DB   string
+	Keys KeyFunc
+}
+
+// indexes maps the database name to the list of its indexes.
+var indexes = map[string][]*Index{}
+
+// DeclareIndex declares the index name of the database db with the key extraction function keys.
+// The index is maintained in the same write transaction as the primary write.
+// It should be called before the creation of DBHandler, e.g. in init function.
+func DeclareIndex(name, db string, keys KeyFunc) *Index {
+	idx := &Index{name, db, keys}
+	indexes[db] = append(indexes[db], idx)
+	DBList = append(DBList, name)
+	return idx
+}
+
+// entries returns the keys of the index entries for the record val stored at key.
+func (idx *Index) entries(key, val []byte) [][]byte {
+	ikeys := idx.Keys(key, val)
+	for i := range ikeys {
+		ikeys[i] = append(append([]byte{}, ikeys[i]...), key...)
+	}
+	return ikeys
+}
+
+// Range visits the index entries whose keys lie within [lo, hi] range in the key order.
+// The extracted key and the primary key of each entry are passed to visit.
+// Empty bound means no limit. after is the key of the index entry visited before;
+// if it is not nil the scan continues right after it.
+func (idx *Index) Range(dbh DBHandler, lo, hi, after []byte, reverse bool, visit Visitor) error {
+	if len(lo) == 0 {
+		lo = nil
+	}
+	if len(hi) == 0 {
+		hi = nil
+	}
+	from := lo
+	if reverse {
+		from = nil
+		if hi != nil {
+			from = append(append([]byte{}, hi...), bytes.Repeat([]byte{0xff}, 64)...)
+		}
+	}
+	if after != nil {
+		from = after
+	}
+	return dbh.Scan(idx.Name, from, reverse, func(key, pkey []byte) bool {
+		if after != nil && bytes.Equal(key, after) {
+			return true
+		}
+		ikey := key[:len(key)-len(pkey)]
+		if (reverse && lo != nil && bytes.Compare(ikey, lo) < 0) ||
+			(!reverse && hi != nil && bytes.Compare(ikey, hi) > 0 && !bytes.HasPrefix(ikey, hi)) {
+			return false
+		}
+		return visit(ikey, pkey)
+	})
+}
+
+// RebuildIndexes recreates all declared indexes of the database handler dbh.
+func RebuildIndexes(dbh DBHandler) error {
+	for _, list := range indexes {
+		for _, idx := range list {
+			if err := dbh.Rebuild(idx.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// 866
+// All Rights Reserved
+
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+// testIndex indexes USERS records by their first byte.
+var testIndex *Index
+
+func init() {
+	testIndex = DeclareIndex("users.first", USERS, func(key, val []byte) [][]byte {
+		if len(val) == 0 {
+			return nil
+		}
+		return [][]byte{val[:1]}
+	})
+}
+
+// indexed returns the primary keys found in the index within the range [lo, hi].
+func indexed(dbh DBHandler, lo, hi string, reverse bool, t *testing.T) string {
+	var keys []string
+	err := testIndex.Range(dbh, []byte(lo), []byte(hi), nil, reverse, func(ikey, pkey []byte) bool {
+		keys = append(keys, string(pkey))
+		return true
+	})
+	if err != nil {
+		t.Errorf("Index.Range error: %v", err)
+	}
+	return fmt.Sprint(keys)
+}
+
+func TestIndex(t *testing.T) {
+	lmdb, err := MakeLMDBHandler(dbPath)
+	if err != nil {
+		t.Errorf("MakeLMDBHandler error: %v", err)
+	}
+	defer lmdb.Close()
+	for k, v := range map[string]string{"k1": "a1", "k2": "b1", "k3": "b2", "k4": "c1"} {
+		if err = lmdb.Write(USERS, []byte(k), []byte(v)); err != nil {
+			t.Errorf("lmdb.Write error: %v", err)
+		}
+	}
+	if keys := indexed(lmdb, "b", "c", false, t); keys != "[k2 k3 k4]" {
+		t.Errorf("Index range [b, c] contains %s", keys)
+	}
+	// Rewrite, modify and delete the records
+	if err = lmdb.Write(USERS, []byte("k1"), []byte("c2")); err != nil {
+		t.Errorf("lmdb.Write error: %v", err)
+	}
+	if err = lmdb.Modify(USERS, []byte("k2"), Update{[]byte("a2")}); err != nil {
+		t.Errorf("lmdb.Modify error: %v", err)
+	}
+	if err = lmdb.Delete(USERS, []byte("k4")); err != nil {
+		t.Errorf("lmdb.Delete error: %v", err)
+	}
+	if keys := indexed(lmdb, "b", "c", true, t); keys != "[k1 k3]" {
+		t.Errorf("Index range [b, c] in reverse order contains %s", keys)
+	}
+	if keys := indexed(lmdb, "a", "a", false, t); keys != "[k2]" {
+		t.Errorf("Index range [a, a] contains %s", keys)
+	}
+	// Rebuild must produce the same index
+	if err = lmdb.Rebuild(testIndex.Name); err != nil {
+		t.Errorf("lmdb.Rebuild error: %v", err)
+	}
+	if keys := indexed(lmdb, "", "", false, t); keys != "[k2 k3 k1]" {
+		t.Errorf("Rebuilt index contains %s", keys)
+	}
+	if err = lmdb.Rebuild("unknown"); err == nil {
+		t.Errorf("lmdb.Rebuild of unknown index should fail")
+	}
+}
@@ -5,9 +5,11 @@
 package db
 
 import (
+	"bytes"
 	"runtime"
 
 	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/pkg/errors"
 )
 
 // Modifier is a functor interface that changes the content.
@@ -16,6 +18,22 @@ type Modifier interface {
 	Apply([]byte) ([]byte, error)
 }
 
+// Put is a Modifier which replaces the value by Data.
+// ModifyAll writes it even if the key doesn't exist, so new values are created within the transaction.
+type Put struct {
+	Data []byte
+}
+
+// Apply returns Data.
+func (p Put) Apply([]byte) ([]byte, error) {
+	return p.Data, nil
+}
+
+// IsNotFound checks whether the error means that the key doesn't exist.
+func IsNotFound(err error) bool {
+	return lmdb.IsNotFound(errors.Cause(err))
+}
+
 // lmdbop is a basic lmdb operation
 type lmdbop struct {
 	op  lmdb.TxnOp
@@ -47,11 +65,53 @@ func (dbh *LMDB) writer() {
 	}
 }
 
+// put writes the content val at address key within the transaction txn.
+// It updates all indexes of the db.
+func (dbh *LMDB) put(txn *lmdb.Txn, db string, key, val []byte) (err error) {
+	if err = dbh.unindex(txn, db, key); err != nil {
+		return
+	}
+	if err = txn.Put(dbh.dbs[db], key, val, 0); err != nil {
+		return
+	}
+	for _, idx := range indexes[db] {
+		for _, ikey := range idx.entries(key, val) {
+			if err = txn.Put(dbh.dbs[idx.Name], ikey, key, 0); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// unindex removes the index entries of the value stored at address key within the transaction txn.
+func (dbh *LMDB) unindex(txn *lmdb.Txn, db string, key []byte) (err error) {
+	if len(indexes[db]) == 0 {
+		return
+	}
+	var old []byte
+	old, err = txn.Get(dbh.dbs[db], key)
+	if lmdb.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	for _, idx := range indexes[db] {
+		for _, ikey := range idx.entries(key, old) {
+			err = txn.Del(dbh.dbs[idx.Name], ikey, nil)
+			if err != nil && !lmdb.IsNotFound(err) {
+				return
+			}
+		}
+	}
+	return nil
+}
+
 // Write writes the content val at address key.
 // If DBHandler is not initialized the function panics.
 func (dbh *LMDB) Write(db string, key, val []byte) error {
 	return dbh.update(func(txn *lmdb.Txn) (err error) {
-		return txn.Put(dbh.dbs[db], key, val, 0)
+		return dbh.put(txn, db, key, val)
 	})
 }
 
@@ -81,7 +141,123 @@ func (dbh *LMDB) Modify(db string, key []byte, m Modifier) error {
 			return
 		}
 		// Write the update
-		err = txn.Put(dbh.dbs[db], key, v, 0)
+		err = dbh.put(txn, db, key, v)
+		return
+	})
+}
+
+// ModifyAll applies the changes in their order within a single write transaction.
+// Nothing is written if some change fails.
+// If DBHandler is not initialized the function panics.
+func (dbh *LMDB) ModifyAll(changes ...Change) error {
+	return dbh.update(func(txn *lmdb.Txn) (err error) {
+		for _, c := range changes {
+			var v []byte
+			v, err = txn.Get(dbh.dbs[c.DB], c.Key)
+			if _, put := c.Modifier.(Put); put && lmdb.IsNotFound(err) {
+				err = nil
+			} else if err != nil {
+				return
+			}
+			if v, err = c.Modifier.Apply(v); err != nil {
+				return
+			}
+			if err = dbh.put(txn, c.DB, c.Key, v); err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// Delete removes the value at address key.
+// If DBHandler is not initialized the function panics.
+func (dbh *LMDB) Delete(db string, key []byte) error {
+	return dbh.update(func(txn *lmdb.Txn) (err error) {
+		if err = dbh.unindex(txn, db, key); err != nil {
+			return
+		}
+		return txn.Del(dbh.dbs[db], key, nil)
+	})
+}
+
+// Rebuild recreates the index with the given name from scratch.
+func (dbh *LMDB) Rebuild(index string) error {
+	return dbh.update(func(txn *lmdb.Txn) (err error) {
+		for _, list := range indexes {
+			for _, idx := range list {
+				if idx.Name == index {
+					return dbh.rebuild(txn, idx)
+				}
+			}
+		}
+		return errors.Errorf("index %s is not declared", index)
+	})
+}
+
+// rebuild drops all entries of the index idx and indexes every record of its db within the transaction txn.
+func (dbh *LMDB) rebuild(txn *lmdb.Txn, idx *Index) (err error) {
+	if err = txn.Drop(dbh.dbs[idx.Name], false); err != nil {
+		return
+	}
+	var cur *lmdb.Cursor
+	cur, err = txn.OpenCursor(dbh.dbs[idx.DB])
+	if err != nil {
+		return
+	}
+	defer cur.Close()
+	var k, v []byte
+	for k, v, err = cur.Get(nil, nil, lmdb.First); err == nil; k, v, err = cur.Get(nil, nil, lmdb.Next) {
+		for _, ikey := range idx.entries(k, v) {
+			if err = txn.Put(dbh.dbs[idx.Name], ikey, k, 0); err != nil {
+				return
+			}
+		}
+	}
+	if lmdb.IsNotFound(err) {
+		err = nil
+	}
+	return
+}
+
+// Scan visits key-value pairs of the db in the key order starting from the key from.
+// If from is empty the scan starts from the first key(or the last one in reverse order).
+// In reverse order the scan starts from the greatest key which is less or equal to from.
+// The scan stops when visit returns false or there are no more keys.
+func (dbh *LMDB) Scan(db string, from []byte, reverse bool, visit Visitor) error {
+	return dbh.env.View(func(txn *lmdb.Txn) (err error) {
+		var cur *lmdb.Cursor
+		cur, err = txn.OpenCursor(dbh.dbs[db])
+		if err != nil {
+			return
+		}
+		defer cur.Close()
+		// Find the first position
+		var k, v []byte
+		next := uint(lmdb.Next)
+		if reverse {
+			next = lmdb.Prev
+		}
+		switch {
+		case len(from) == 0 && reverse:
+			k, v, err = cur.Get(nil, nil, lmdb.Last)
+		case len(from) == 0:
+			k, v, err = cur.Get(nil, nil, lmdb.First)
+		default:
+			k, v, err = cur.Get(from, nil, lmdb.SetRange)
+			if reverse && lmdb.IsNotFound(err) {
+				k, v, err = cur.Get(nil, nil, lmdb.Last)
+			} else if reverse && err == nil && bytes.Compare(k, from) > 0 {
+				k, v, err = cur.Get(nil, nil, lmdb.Prev)
+			}
+		}
+		// Iterate over the keys
+		for err == nil && visit(k, v) {
+			k, v, err = cur.Get(nil, nil, next)
+		}
+		if lmdb.IsNotFound(err) {
+			err = nil
+		}
 		return
 	})
 }
@@ -107,7 +283,7 @@ func MakeLMDBHandler(path string) (l *LMDB, err error) {
 		return
 	}
 	// Change this value if you want to have more dbs
-	err = env.SetMaxDBs(10)
+	err = env.SetMaxDBs(64)
 	if err != nil {
 		return
 	}
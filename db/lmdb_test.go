@@ -4,6 +4,7 @@
 package db
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
 	"path"
@@ -70,6 +71,101 @@ func TestUpdate(t *testing.T) {
 	lmdb.Close()
 }
 
+// failure is a fake Modifier which always fails.
+type failure struct{}
+
+// Apply returns the error.
+func (failure) Apply(input []byte) ([]byte, error) {
+	return nil, fmt.Errorf("failed")
+}
+
+func TestModifyAll(t *testing.T) {
+	lmdb, err := MakeLMDBHandler(dbPath)
+	if err != nil {
+		t.Errorf("MakeLMDBHandler error: %v", err)
+	}
+	defer lmdb.Close()
+	for _, db := range []string{PROPOSALS, DYNAMIC} {
+		if err = lmdb.Write(db, []byte("all"), []byte("1")); err != nil {
+			t.Errorf("lmdb.Write error: %v", err)
+		}
+	}
+	// The failed change discards the previous ones
+	err = lmdb.ModifyAll(Change{PROPOSALS, []byte("all"), Update{[]byte("2")}}, Change{DYNAMIC, []byte("all"), failure{}})
+	if err == nil {
+		t.Errorf("lmdb.ModifyAll should fail")
+	}
+	read, _ := lmdb.Read(PROPOSALS, []byte("all"))
+	compareBytes(read, []byte("1"), t)
+	// Both changes are written
+	err = lmdb.ModifyAll(Change{PROPOSALS, []byte("all"), Update{[]byte("2")}}, Change{DYNAMIC, []byte("all"), Update{[]byte("3")}})
+	if err != nil {
+		t.Errorf("lmdb.ModifyAll error: %v", err)
+	}
+	read, _ = lmdb.Read(PROPOSALS, []byte("all"))
+	compareBytes(read, []byte("2"), t)
+	read, _ = lmdb.Read(DYNAMIC, []byte("all"))
+	compareBytes(read, []byte("3"), t)
+	// Put creates the missing value, other modifiers fail on it
+	lmdb.Delete(PROPOSALS, []byte("new"))
+	if err = lmdb.ModifyAll(Change{PROPOSALS, []byte("new"), Update{[]byte("4")}}); !IsNotFound(err) {
+		t.Errorf("lmdb.ModifyAll expected not found error, got %v", err)
+	}
+	if err = lmdb.ModifyAll(Change{PROPOSALS, []byte("new"), Put{[]byte("4")}}); err != nil {
+		t.Errorf("lmdb.ModifyAll error: %v", err)
+	}
+	read, _ = lmdb.Read(PROPOSALS, []byte("new"))
+	compareBytes(read, []byte("4"), t)
+}
+
+func TestScan(t *testing.T) {
+	lmdb, err := MakeLMDBHandler(dbPath)
+	if err != nil {
+		t.Errorf("MakeLMDBHandler error: %v", err)
+	}
+	defer lmdb.Close()
+	for _, k := range []string{"b1", "b3", "b5", "c1"} {
+		if err = lmdb.Write(CHAT, []byte(k), []byte(k)); err != nil {
+			t.Errorf("lmdb.Write error: %v", err)
+		}
+	}
+	if err = lmdb.Delete(CHAT, []byte("c1")); err != nil {
+		t.Errorf("lmdb.Delete error: %v", err)
+	}
+	// collect returns the keys visited by Scan
+	collect := func(from string, reverse bool) (keys []string) {
+		var start []byte
+		if from != "" {
+			start = []byte(from)
+		}
+		err := lmdb.Scan(CHAT, start, reverse, func(k, v []byte) bool {
+			keys = append(keys, string(k))
+			return len(keys) < 2
+		})
+		if err != nil {
+			t.Errorf("lmdb.Scan error: %v", err)
+		}
+		return
+	}
+	for _, c := range []struct {
+		from     string
+		reverse  bool
+		expected string
+	}{
+		{"", false, "[b1 b3]"},
+		{"b2", false, "[b3 b5]"},
+		{"b4", true, "[b3 b1]"},
+		{"b3", true, "[b3 b1]"},
+		{"z", true, "[b5 b3]"},
+		{"", true, "[b5 b3]"},
+		{"c", false, "[]"},
+	} {
+		if keys := fmt.Sprint(collect(c.from, c.reverse)); keys != c.expected {
+			t.Errorf("Scan from %q(reverse %v) visited %s, expected %s", c.from, c.reverse, keys, c.expected)
+		}
+	}
+}
+
 func BenchmarkWrite100bytesEntries(b *testing.B) {
 	message := make([]byte, 100)
 	for i := range message {
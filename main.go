@@ -6,12 +6,17 @@ package main
 
 import (
 	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"time"
 
 	"union/db"
 	"union/messages"
 	_ "union/routers"
 
 	"github.com/astaxie/beego"
+	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 )
 
@@ -23,6 +28,28 @@ func initLMDB() {
 	if err != nil {
 		panic(err)
 	}
+	// Index the records written by older versions
+	if err = db.RebuildIndexes(lmdb); err != nil {
+		panic(err)
+	}
+	// Convert float prices written by older versions
+	if n, err := messages.MigratePrices(lmdb); err != nil {
+		panic(err)
+	} else if n > 0 {
+		beego.Info("Proposals migrated to decimal prices: ", n)
+	}
+	// Store the default instrument and assign it to proposals written by older versions
+	if n, err := messages.InitInstruments(lmdb); err != nil {
+		panic(err)
+	} else if n > 0 {
+		beego.Info("Proposals migrated to the default instrument: ", n)
+	}
+	// Compute statistics of proposals closed by older versions
+	if n, err := messages.RebuildStats(lmdb); err != nil {
+		panic(err)
+	} else if n > 0 {
+		beego.Info("Trades counted in statistics: ", n)
+	}
 	// Add random proposal to the database
 	id := uuid.NewV4()
 	beego.Info("Prop ID: ", id.String())
@@ -45,9 +72,152 @@ func initLMDB() {
 	data, _ = json.Marshal(chatb)
 	lmdb.Write(db.CHAT, id.Bytes(), data)
 
+	lmdb.Write(db.CHAT, db.LastCB, id.Bytes())
 	beego.Info("Chat Bucket ID: ", id.String())
 	// Global database
 	db.DB = lmdb
+	// Global game engine
+	src, trigger, err := openFeed(lmdb, beego.AppConfig.String("feed"))
+	if err != nil {
+		panic(err)
+	}
+	engine := messages.MakeTCPWSEngine(lmdb, trigger)
+	engine.SetChatFilter(beego.AppConfig.Strings("chatfilter"))
+	rules, err := readRiskRules()
+	if err != nil {
+		panic(err)
+	}
+	engine.SetRiskRules(rules)
+	messages.GameEngine = engine
+	go refreshLeaderboards(engine, time.Duration(beego.AppConfig.DefaultInt("leaderboardinterval", 60))*time.Second)
+	if src != nil {
+		go func() {
+			err := engine.RunFeed(src, func(err error) {
+				beego.Error("Price feed error:", err)
+			})
+			if err != nil {
+				beego.Error("Price feed failed:", err)
+			}
+			beego.Info("Price feed is finished.")
+		}()
+	}
+}
+
+// openFeed opens the quote source given by the "feed" option of app.conf:
+// "tcp://host:port" connects to the trigger server, "file://path" reads quotes from the file,
+// "sim://EURUSD:1.10000,..." runs the market simulator in the development mode,
+// "replay://path" replays recorded ticks of the CSV or binary file.
+// The source is nil if the option is empty. trigger is the connection to the trigger server.
+func openFeed(dbh db.DBHandler, feed string) (src messages.FeedSource, trigger net.Conn, err error) {
+	switch {
+	case feed == "":
+	case strings.HasPrefix(feed, "tcp://"):
+		if trigger, err = net.Dial("tcp", strings.TrimPrefix(feed, "tcp://")); err == nil {
+			src = messages.NewLineSource(trigger)
+		}
+	case strings.HasPrefix(feed, "file://"):
+		var f *os.File
+		if f, err = os.Open(strings.TrimPrefix(feed, "file://")); err == nil {
+			src = messages.NewLineSource(f)
+		}
+	case strings.HasPrefix(feed, "sim://"):
+		var sim *messages.Simulator
+		if sim, err = openSimulator(dbh, strings.Split(strings.TrimPrefix(feed, "sim://"), ",")); err == nil {
+			src = sim
+		}
+	case strings.HasPrefix(feed, "replay://"):
+		var ticks messages.FeedSource
+		if ticks, err = messages.OpenTicks(strings.TrimPrefix(feed, "replay://")); err == nil {
+			src = openReplay(ticks)
+		}
+	default:
+		err = errors.New("feed option must start with tcp://, file://, sim:// or replay://")
+	}
+	return
+}
+
+// refreshLeaderboards recomputes leaderboards of the engine with the interval given by
+// "leaderboardinterval" option of app.conf in seconds. Zero interval disables refreshes.
+func refreshLeaderboards(engine *messages.TCPWSEngine, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := engine.RefreshLeaderboards(); err != nil {
+			beego.Error("Leaderboards refresh failed:", err)
+		}
+	}
+}
+
+// readRiskRules reads the risk rules from "riskmaxpositions", "riskmaxlots", "riskmaxexposure",
+// "riskdailyloss" and "riskminstop" options of app.conf. Empty options mean no limit.
+func readRiskRules() (rules messages.RiskRules, err error) {
+	rules.MaxPositions = beego.AppConfig.DefaultInt("riskmaxpositions", 0)
+	for key, price := range map[string]*messages.Price{
+		"riskmaxlots":     &rules.MaxLots,
+		"riskmaxexposure": &rules.MaxExposure,
+		"riskdailyloss":   &rules.DailyLoss,
+		"riskminstop":     &rules.MinStopPips,
+	} {
+		if s := beego.AppConfig.String(key); s != "" {
+			if *price, err = messages.ParsePrice(s); err != nil {
+				return rules, errors.Wrapf(err, "wrong %s option", key)
+			}
+		}
+	}
+	return
+}
+
+// openSimulator makes the market simulator of the instruments "SYMBOL:START[:SPREAD[:VOLATILITY]]".
+// Instruments must be in the catalogue. Their spreads are used if the spread is omitted.
+// The default volatility, the random seed and the interval between quotes in milliseconds
+// are given by "simvolatility", "simseed" and "siminterval" options of app.conf.
+// Zero seed makes different prices on every start.
+func openSimulator(dbh db.DBHandler, specs []string) (*messages.Simulator, error) {
+	volatility := beego.AppConfig.DefaultFloat("simvolatility", 0.2)
+	seed := beego.AppConfig.DefaultInt64("simseed", 0)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	interval := time.Duration(beego.AppConfig.DefaultInt("siminterval", 1000)) * time.Millisecond
+	var instruments []messages.SimInstrument
+	for _, spec := range specs {
+		si, err := messages.ParseSimInstrument(spec)
+		if err != nil {
+			return nil, err
+		}
+		inst, err := messages.LookupInstrument(dbh, si.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if inst == nil {
+			return nil, errors.Errorf("simulated instrument %s is not in the catalogue", si.Symbol)
+		}
+		if si.Spread.Sign() == 0 {
+			si.Spread = inst.Spread
+		}
+		if si.Volatility == 0 {
+			si.Volatility = volatility
+		}
+		instruments = append(instruments, si)
+	}
+	sim, err := messages.NewSimulator(seed, interval, time.Now(), instruments...)
+	if err != nil {
+		return nil, err
+	}
+	sim.Realtime = true
+	beego.Info("Market simulator is started with the seed ", seed)
+	return sim, nil
+}
+
+// openReplay makes the replay of the ticks at the speed given by "replayspeed" option of app.conf.
+// The replay starts in the stepwise mode if "replaystep" option is set.
+// The game clock follows the replayed ticks so deadlines and expirations match the recorded market.
+func openReplay(ticks messages.FeedSource) *messages.Replay {
+	replay := messages.NewReplay(ticks, beego.AppConfig.DefaultFloat("replayspeed", 1))
+	if beego.AppConfig.DefaultBool("replaystep", false) {
+		replay.Pause()
+	}
+	messages.CurrentReplay = replay
+	messages.GameClock = replay.Clock
+	return replay
 }
 
 func main() {
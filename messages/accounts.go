@@ -0,0 +1,405 @@
+// accounts.go introduces paper trading accounts and positions of users in proposals
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// MoneyDigits is the number of decimal digits of account money.
+const MoneyDigits = 2
+
+// Paper trading parameters
+var (
+	// StartingBalance is the balance of new accounts.
+	StartingBalance = NewPrice(1000000, MoneyDigits)
+	// MarginRate is the part of the position value which is reserved as the margin, i.e. the leverage is 1:100.
+	MarginRate = NewPrice(1, 2)
+	// LotStep is the minimal lot size and the step of lot sizes.
+	LotStep = NewPrice(1, 2)
+	// MaxLots is the maximal lot size of the position.
+	MaxLots = NewPrice(100, 0)
+)
+
+var (
+	// ErrNoMargin is returned when the account hasn't enough free margin for the position.
+	ErrNoMargin = errors.New("not enough free margin")
+	// ErrJoinClosed is returned when the proposal cannot be joined anymore.
+	ErrJoinClosed = errors.New("proposal cannot be joined anymore")
+	// ErrAlreadyJoined is returned when the user joins the same proposal twice.
+	ErrAlreadyJoined = errors.New("user has already joined the proposal")
+)
+
+// PosByUser indexes positions by the user and the join time.
+var PosByUser *db.Index
+
+func init() {
+	PosByUser = db.DeclareIndex("positions.user", db.POSITIONS, func(key, val []byte) [][]byte {
+		pos := Position{}
+		if json.Unmarshal(val, &pos) != nil {
+			return nil
+		}
+		return [][]byte{join(idBytes(pos.UserID), encInt64(pos.Joined))}
+	})
+}
+
+// Account is a paper trading account of the user stored in ACCOUNTS database.
+// Money is counted in the quote currency of instruments. Margin is reserved by positions
+// which are not closed yet, Realized is the total profit of closed positions.
+type Account struct {
+	UserID   string `json:"userid"`
+	Balance  Price  `json:"balance"`
+	Margin   Price  `json:"margin"`
+	Realized Price  `json:"realized"`
+	Created  int64  `json:"created"`
+}
+
+// Position is the share of the user in the proposal stored in POSITIONS database.
+// Its state follows the state of the proposal. Lots are multiplied by the contract size
+// of the instrument. Profit is realized when the position is closed.
+type Position struct {
+	ProposalID   string `json:"proposalid"`
+	UserID       string `json:"userid"`
+	Symbol       string `json:"symbol"`
+	Type         byte   `json:"type"`
+	State        byte   `json:"state"`
+	Lots         Price  `json:"lots"`
+	ContractSize int64  `json:"contractsize"`
+	Margin       Price  `json:"margin"`
+	EntryPrice   Price  `json:"entryprice"`
+	ExitPrice    Price  `json:"exitprice"`
+	Profit       Price  `json:"profit"`
+	Joined       int64  `json:"joined"`
+	Opened       int64  `json:"opened,omitempty"`
+	Closed       int64  `json:"closed,omitempty"`
+}
+
+// positionKey returns the key of the position in POSITIONS database: proposal ID + user ID.
+func positionKey(propID, userID string) []byte {
+	return join(idBytes(propID), idBytes(userID))
+}
+
+// IsBuy checks whether the position buys the instrument.
+func (pos *Position) IsBuy() bool {
+	return pos.Type == BuyStop || pos.Type == BuyLimit
+}
+
+// ProfitAt returns the profit of the open position if it is closed at the price.
+// It fails if the profit overflows.
+func (pos *Position) ProfitAt(exit Price) (Price, error) {
+	diff := exit.Sub(pos.EntryPrice)
+	if !pos.IsBuy() {
+		diff = Price{}.Sub(diff)
+	}
+	return product(MoneyDigits, diff, pos.Lots, NewPrice(pos.ContractSize, 0))
+}
+
+// ExitPriceOf returns the market price which closes the position.
+func (pos *Position) ExitPriceOf(q Quote) Price {
+	if pos.IsBuy() {
+		return q.Bid
+	}
+	return q.Ask
+}
+
+// Finished checks whether the position is closed or cancelled.
+func (pos *Position) Finished() bool {
+	return pos.State != StateProposal && pos.State != StatePending && pos.State != StatePosition
+}
+
+// RequiredMargin returns the margin of lots of the instrument at the price.
+// It fails if the margin overflows.
+func RequiredMargin(lots Price, contractSize int64, price Price) (Price, error) {
+	return product(MoneyDigits, lots, NewPrice(contractSize, 0), price, MarginRate)
+}
+
+// product returns the product of the factors rounded to scale digits.
+func product(scale uint8, factors ...Price) (Price, error) {
+	r := NewPrice(1, 0)
+	for _, f := range factors {
+		var err error
+		if r, err = r.Mul(f); err != nil {
+			return r, err
+		}
+	}
+	return r.Round(scale), nil
+}
+
+// CheckLots checks the lot size and returns FieldErrors if it is wrong.
+func CheckLots(lots Price) error {
+	step, l := align(LotStep, lots)
+	switch {
+	case lots.Sign() <= 0:
+		return FieldErrors{"lots": "must be positive"}
+	case lots.Cmp(MaxLots) > 0:
+		return FieldErrors{"lots": "must not exceed " + MaxLots.String()}
+	case l.Units%step.Units != 0:
+		return FieldErrors{"lots": "must be a multiple of " + LotStep.String()}
+	}
+	return nil
+}
+
+// ReadAccount reads the account of the user. The user who hasn't traded yet
+// gets the new account with StartingBalance which is not stored.
+func ReadAccount(dbh db.DBHandler, userID string) (acc Account, err error) {
+	err = readJSON(dbh, db.ACCOUNTS, idBytes(userID), &acc)
+	if db.IsNotFound(err) {
+		return Account{UserID: userID, Balance: StartingBalance, Margin: NewPrice(0, MoneyDigits),
+			Realized: NewPrice(0, MoneyDigits), Created: wallNow()}, nil
+	}
+	return
+}
+
+// modifyAccount changes the account of the user by the function. The account is created if it doesn't exist.
+// It must be called under accountsmu.
+func (e *TCPWSEngine) modifyAccount(userID string, change func(acc *Account) error) error {
+	acc, err := ReadAccount(e.db, userID)
+	if err != nil {
+		return err
+	}
+	if err = change(&acc); err != nil {
+		return err
+	}
+	return writeJSON(e.db, db.ACCOUNTS, idBytes(userID), acc)
+}
+
+// ListPositions returns the positions of the user from the most recent one.
+// Finished positions are skipped unless all is set.
+func ListPositions(dbh db.DBHandler, userID string, all bool) (list []Position, err error) {
+	list = []Position{}
+	var keys [][]byte
+	prefix := idBytes(userID)
+	err = PosByUser.Range(dbh, prefix, prefix, nil, true, func(ikey, pkey []byte) bool {
+		keys = append(keys, append([]byte{}, pkey...))
+		return true
+	})
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		pos := Position{}
+		if err = readJSON(dbh, db.POSITIONS, key, &pos); db.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return
+		}
+		if all || !pos.Finished() {
+			list = append(list, pos)
+		}
+	}
+	return list, nil
+}
+
+// ValuePositions sets the exit price and the unrealized profit of open positions by the latest quotes.
+// It returns the total unrealized profit.
+func ValuePositions(positions []Position, quotes *QuoteCache) (Price, error) {
+	total := NewPrice(0, MoneyDigits)
+	for i := range positions {
+		pos := &positions[i]
+		if pos.State != StatePosition {
+			continue
+		}
+		if q, ok := quotes.Get(pos.Symbol); ok {
+			var err error
+			pos.ExitPrice = pos.ExitPriceOf(q)
+			if pos.Profit, err = pos.ProfitAt(pos.ExitPrice); err != nil {
+				return total, err
+			}
+			total = total.Add(pos.Profit)
+		}
+	}
+	return total, nil
+}
+
+// Statement is the state of the account valued by the latest quotes.
+// Equity is the balance with the unrealized profit of open positions,
+// FreeMargin is the equity which is not reserved by positions.
+type Statement struct {
+	Account
+	Unrealized Price      `json:"unrealized"`
+	Equity     Price      `json:"equity"`
+	FreeMargin Price      `json:"freemargin"`
+	Positions  []Position `json:"positions"`
+}
+
+// MakeStatement returns the statement of the user's account. Open positions get their unrealized
+// profit from the quote cache. Finished positions are included if all is set.
+func MakeStatement(dbh db.DBHandler, quotes *QuoteCache, userID string, all bool) (st Statement, err error) {
+	if st.Account, err = ReadAccount(dbh, userID); err != nil {
+		return
+	}
+	if st.Positions, err = ListPositions(dbh, userID, all); err != nil {
+		return
+	}
+	if st.Unrealized, err = ValuePositions(st.Positions, quotes); err != nil {
+		return
+	}
+	st.Equity = st.Balance.Add(st.Unrealized)
+	st.FreeMargin = st.Equity.Sub(st.Margin)
+	return
+}
+
+// Statement returns the statement of the user's account.
+func (e *TCPWSEngine) Statement(userID string, all bool) (Statement, error) {
+	return MakeStatement(e.db, e.quotes, userID, all)
+}
+
+// JoinProposal opens the position of the user in the proposal with the lot size.
+// The proposal must collect votes or wait for the price, the market of the instrument must be open. The margin of the position
+// at the proposal price is reserved if the account has enough free margin.
+// The position must not break the risk rules.
+func (e *TCPWSEngine) JoinProposal(userID, propID string, lots Price) (pos Position, err error) {
+	if err = CheckLots(lots); err != nil {
+		return
+	}
+	// Lots like "1.00000000" are brought to the scale of LotStep which keeps the margin small
+	lots = lots.Round(LotStep.Scale)
+	pid, err := uuid.FromString(propID)
+	if err != nil {
+		return
+	}
+	e.accountsmu.Lock()
+	defer e.accountsmu.Unlock()
+	p, err := e.readProposal(pid)
+	if err != nil {
+		return
+	}
+	if p.State != StateProposal && p.State != StatePending {
+		return pos, ErrJoinClosed
+	}
+	key := positionKey(p.ID, userID)
+	if _, err = e.db.Read(db.POSITIONS, key); err == nil {
+		return pos, ErrAlreadyJoined
+	} else if !db.IsNotFound(err) {
+		return
+	}
+	inst, err := LookupInstrument(e.db, p.Symbol)
+	if err != nil {
+		return
+	}
+	if inst == nil {
+		inst = &DefaultInstrument
+	}
+	if !inst.IsOpen(GameClock.Now()) {
+		return pos, errors.Wrap(ErrMarketClosed, p.Symbol)
+	}
+	pos = Position{ProposalID: p.ID, UserID: userID, Symbol: p.Symbol, Type: p.Type, State: p.State,
+		Lots: lots, ContractSize: inst.ContractSize, Joined: wallNow()}
+	if pos.Margin, err = RequiredMargin(lots, inst.ContractSize, p.Price); err != nil {
+		return
+	}
+	if e.risk != (RiskRules{}) {
+		var positions []Position
+		if positions, err = ListPositions(e.db, userID, true); err != nil {
+			return
+		}
+		if err = e.risk.CheckJoin(pos, positions, wallNow()); err != nil {
+			return
+		}
+	}
+	st, err := e.Statement(userID, false)
+	if err != nil {
+		return
+	}
+	if st.FreeMargin.Cmp(pos.Margin) < 0 {
+		return pos, errors.Wrapf(ErrNoMargin, "%s is required, %s is free", pos.Margin, st.FreeMargin)
+	}
+	err = e.modifyAccount(userID, func(acc *Account) error {
+		acc.Margin = acc.Margin.Add(pos.Margin)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if err = writeJSON(e.db, db.POSITIONS, key, pos); err != nil {
+		return
+	}
+	err = e.db.Modify(db.PROPOSALS, pid.Bytes(), &jsonModifier{&p, func() error {
+		p.Involved = append(p.Involved, userID)
+		return nil
+	}})
+	return
+}
+
+// settle brings the positions of the proposal to its state. Positions are opened
+// and closed by the prices of the proposal events. Closed positions realize their profit,
+// finished positions release their margin. Users are notified about their changed positions.
+// Closed positions update the statistics of the author and the traders.
+func (e *TCPWSEngine) settle(p Proposal) (err error) {
+	if len(p.History) == 0 {
+		return nil
+	}
+	ev := p.History[len(p.History)-1]
+	e.accountsmu.Lock()
+	defer e.accountsmu.Unlock()
+	var keys [][]byte
+	prefix := idBytes(p.ID)
+	err = e.db.Scan(db.POSITIONS, prefix, false, func(key, val []byte) bool {
+		if len(key) < len(prefix) || string(key[:len(prefix)]) != string(prefix) {
+			return false
+		}
+		keys = append(keys, append([]byte{}, key...))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var changed, closed []Position
+	defer func() {
+		if nerr := e.notifyFollowers(changed); err == nil {
+			err = nerr
+		}
+	}()
+	for _, key := range keys {
+		pos := Position{}
+		finished := false
+		err = e.db.Modify(db.POSITIONS, key, &jsonModifier{&pos, func() error {
+			if pos.State == p.State || pos.Finished() {
+				return nil
+			}
+			pos.State = p.State
+			finished = pos.Finished()
+			switch p.State {
+			case StatePosition:
+				pos.Opened, pos.EntryPrice = ev.Time, ev.Value
+			case StateStopLoss, StateTakeProfit, StateExpiredPosition:
+				pos.Closed, pos.ExitPrice = ev.Time, ev.Value
+				// The position which hasn't been opened has no entry price and no profit
+				if pos.Opened != 0 {
+					var err error
+					if pos.Profit, err = pos.ProfitAt(ev.Value); err != nil {
+						return err
+					}
+					closed = append(closed, pos)
+				}
+			case StateExpiredProposal, StateExpiredPending:
+				pos.Closed = ev.Time
+			}
+			changed = append(changed, pos)
+			return nil
+		}})
+		if err != nil {
+			return err
+		}
+		if !finished {
+			continue
+		}
+		err = e.modifyAccount(pos.UserID, func(acc *Account) error {
+			acc.Margin = acc.Margin.Sub(pos.Margin)
+			acc.Balance = acc.Balance.Add(pos.Profit)
+			acc.Realized = acc.Realized.Add(pos.Profit)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return e.recordStats(p, closed)
+}
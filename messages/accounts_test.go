@@ -0,0 +1,105 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+func TestAccounts(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	p := Proposal{
+		ID: uuid.NewV4().String(), Symbol: DefaultSymbol, Type: BuyStop, State: StatePending,
+		Price: NewPrice(123500, 5), StopLoss: NewPrice(123000, 5), TakeProfit: NewPrice(124000, 5),
+		Created: 1000, PendingExp: MaxPendingExp, PositionExp: MaxPositionExp,
+		History: []Event{{1000, NewPrice(123500, 5), StatePending}},
+	}
+	if err := writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	user := uuid.NewV4().String()
+	if _, err := e.JoinProposal(user, p.ID, NewPrice(100, 0)); errors.Cause(err) != ErrNoMargin {
+		t.Errorf("JoinProposal expected ErrNoMargin, got %v", err)
+	}
+	if _, err := e.JoinProposal(user, p.ID, NewPrice(1, 3)); err == nil {
+		t.Errorf("JoinProposal expected an error for wrong lots")
+	}
+	// Lots of a greater scale don't overflow the margin
+	if _, err := e.JoinProposal(user, p.ID, NewPrice(10000000000, 8)); errors.Cause(err) != ErrNoMargin {
+		t.Errorf("JoinProposal expected ErrNoMargin, got %v", err)
+	}
+	pos, err := e.JoinProposal(user, p.ID, NewPrice(1, 0))
+	if err != nil {
+		t.Fatalf("JoinProposal error: %v", err)
+	}
+	if pos.Margin.String() != "1235.00" {
+		t.Errorf("Unexpected margin: %s", pos.Margin)
+	}
+	if _, err = e.JoinProposal(user, p.ID, NewPrice(1, 0)); err != ErrAlreadyJoined {
+		t.Errorf("JoinProposal expected ErrAlreadyJoined, got %v", err)
+	}
+	// The order is filled and the position is valued by the latest quote
+	quote := func(bid int64, sec int64) {
+		if err := e.PublishQuote(Quote{DefaultSymbol, NewPrice(bid, 5), NewPrice(bid+2, 5), sec * 1000}); err != nil {
+			t.Fatalf("PublishQuote error: %v", err)
+		}
+	}
+	quote(123500, 1100)
+	quote(123700, 1200)
+	st, err := e.Statement(user, false)
+	if err != nil {
+		t.Fatalf("Statement error: %v", err)
+	}
+	if len(st.Positions) != 1 || st.Positions[0].EntryPrice.String() != "1.23502" ||
+		st.Unrealized.String() != "198.00" || st.Equity.String() != "10198.00" || st.FreeMargin.String() != "8963.00" {
+		t.Errorf("Unexpected statement: %+v", st)
+	}
+	// Take profit realizes the profit and releases the margin
+	quote(124000, 1300)
+	if st, err = e.Statement(user, true); err != nil {
+		t.Fatalf("Statement error: %v", err)
+	}
+	if st.Balance.String() != "10498.00" || st.Margin.Sign() != 0 || st.Realized.String() != "498.00" ||
+		len(st.Positions) != 1 || st.Positions[0].State != StateTakeProfit {
+		t.Errorf("Unexpected statement: %+v", st)
+	}
+	if st, _ = e.Statement(user, false); len(st.Positions) != 0 {
+		t.Errorf("Unexpected open positions: %+v", st.Positions)
+	}
+	if err = readJSON(dbh, db.PROPOSALS, idBytes(p.ID), &p); err != nil || len(p.Involved) != 1 || p.Involved[0] != user {
+		t.Errorf("Unexpected involved users: %v, %v", p.Involved, err)
+	}
+	if _, err = e.JoinProposal(uuid.NewV4().String(), p.ID, NewPrice(1, 0)); err != ErrJoinClosed {
+		t.Errorf("JoinProposal expected ErrJoinClosed, got %v", err)
+	}
+	// The position which hasn't been opened realizes no profit
+	q := p
+	q.ID, q.State, q.History = uuid.NewV4().String(), StatePending, q.History[:1]
+	if err = writeJSON(dbh, db.PROPOSALS, idBytes(q.ID), q); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	other := uuid.NewV4().String()
+	if pos, err = e.JoinProposal(other, q.ID, NewPrice(1000, 3)); err != nil || pos.Lots.String() != "1.00" || pos.Margin.String() != "1235.00" {
+		t.Errorf("Unexpected position: %+v, %v", pos, err)
+	}
+	q.State = StateStopLoss
+	q.History = append(q.History, Event{1400, NewPrice(123000, 5), StateStopLoss})
+	if err = e.settle(q); err != nil {
+		t.Fatalf("settle error: %v", err)
+	}
+	if st, err = e.Statement(other, true); err != nil || st.Balance.String() != "10000.00" || st.Realized.Sign() != 0 ||
+		st.Margin.Sign() != 0 || len(st.Positions) != 1 || st.Positions[0].Profit.Sign() != 0 {
+		t.Errorf("Unexpected statement: %+v, %v", st, err)
+	}
+}
@@ -0,0 +1,350 @@
+// alerts.go evaluates alert rules of users against quotes and engine events
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Kinds of alert rules and notifications
+const (
+	// AlertPrice fires once when the bid of the instrument crosses the level.
+	AlertPrice = "price"
+	// AlertState fires when the proposal changes its state. The rule without the proposal
+	// covers all proposals the user has voted on.
+	AlertState = "state"
+	// AlertAuthor fires when the author adds the proposal.
+	AlertAuthor = "author"
+	// AlertMention fires when the user is mentioned in the chat. Mentioned users without
+	// the rule are notified too, the rule is only referred by their notifications.
+	AlertMention = "mention"
+	// NotifyPosition is the kind of notifications about changed positions of the user.
+	// It has no alert rule.
+	NotifyPosition = "position"
+)
+
+// Directions of price alerts
+const (
+	Above = "above"
+	Below = "below"
+)
+
+// MaxAlerts is the maximal number of alert rules of the user.
+const MaxAlerts = 50
+
+var (
+	// ErrAlertNotFound is returned when the alert rule of the user doesn't exist.
+	ErrAlertNotFound = errors.New("alert is not found")
+	// ErrTooManyAlerts is returned when the user has MaxAlerts rules.
+	ErrTooManyAlerts = errors.New("too many alerts")
+)
+
+// StateNames are the names of proposal states used in notifications.
+var StateNames = []string{"proposal", "pending order", "position", "expired proposal", "expired pending order",
+	"expired position", "closed by stop loss", "closed by take profit"}
+
+// stateName returns the name of the proposal state for notifications.
+func stateName(state byte) string {
+	if int(state) < len(StateNames) {
+		return StateNames[state]
+	}
+	return "in state " + strconv.Itoa(int(state))
+}
+
+// AlertRule is the condition of notifications of the user stored in ALERTS database.
+// Symbol, Level and Direction describe price alerts, Triggered is the time the price alert has fired.
+// ProposalID describes state alerts, AuthorID describes author alerts.
+type AlertRule struct {
+	ID         string `json:"id"`
+	UserID     string `json:"userid"`
+	Kind       string `json:"kind"`
+	Symbol     string `json:"symbol,omitempty"`
+	Level      *Price `json:"level,omitempty"`
+	Direction  string `json:"direction,omitempty"`
+	ProposalID string `json:"proposalid,omitempty"`
+	AuthorID   string `json:"authorid,omitempty"`
+	Created    int64  `json:"created"`
+	Triggered  int64  `json:"triggered,omitempty"`
+}
+
+// AlertByTarget indexes active alert rules by their kinds and targets: kind + 0 + target.
+// Targets are symbols of price alerts, proposal IDs of state alerts (nil uuid for voted proposals),
+// author IDs of author alerts and user IDs of mention alerts.
+var AlertByTarget *db.Index
+
+func init() {
+	AlertByTarget = db.DeclareIndex("alerts.target", db.ALERTS, func(key, val []byte) [][]byte {
+		a := AlertRule{}
+		if json.Unmarshal(val, &a) != nil || a.Triggered != 0 {
+			return nil
+		}
+		return [][]byte{alertTarget(a.Kind, a.target())}
+	})
+}
+
+// target returns the target of the alert rule.
+func (a *AlertRule) target() []byte {
+	switch a.Kind {
+	case AlertPrice:
+		return append([]byte(a.Symbol), 0)
+	case AlertState:
+		return idBytes(a.ProposalID)
+	case AlertAuthor:
+		return idBytes(a.AuthorID)
+	}
+	return idBytes(a.UserID)
+}
+
+// alertTarget returns the index key prefix of the alert rules of the kind with the target.
+func alertTarget(kind string, target []byte) []byte {
+	return join([]byte(kind), []byte{0}, target)
+}
+
+// alertKey returns the key of the alert rule in ALERTS database: user ID + rule ID.
+func alertKey(userID, id string) []byte {
+	return join(idBytes(userID), idBytes(id))
+}
+
+// Validate checks the alert rule and returns FieldErrors if some fields are wrong.
+// The direction of the price alert is taken from the quote q if it is omitted.
+func (a *AlertRule) Validate(dbh db.DBHandler, q *Quote) error {
+	fe := FieldErrors{}
+	switch a.Kind {
+	case AlertPrice:
+		inst, err := LookupInstrument(dbh, a.Symbol)
+		if err != nil {
+			return err
+		}
+		if inst == nil {
+			fe["symbol"] = "must be a known instrument"
+		}
+		switch {
+		case a.Level == nil || a.Level.Sign() <= 0:
+			fe["level"] = "must be positive"
+		case a.Direction == "" && q != nil && a.Level.Cmp(q.Bid) > 0:
+			a.Direction = Above
+		case a.Direction == "" && q != nil:
+			a.Direction = Below
+		}
+		if a.Direction != Above && a.Direction != Below {
+			fe["direction"] = "must be above or below"
+		}
+	case AlertState:
+		if a.ProposalID != "" {
+			if _, err := uuid.FromString(a.ProposalID); err != nil {
+				fe["proposalid"] = "must be a proposal ID"
+			}
+		}
+	case AlertAuthor:
+		if _, err := uuid.FromString(a.AuthorID); err != nil {
+			fe["authorid"] = "must be a user ID"
+		}
+	case AlertMention:
+	default:
+		fe["kind"] = "must be price, state, author or mention"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+// AddAlert validates and stores the new alert rule of the user.
+func (e *TCPWSEngine) AddAlert(userID string, a AlertRule) (AlertRule, error) {
+	a.ID = uuid.NewV4().String()
+	a.UserID = userID
+	a.Created = wallNow()
+	a.Triggered = 0
+	var q *Quote
+	if cur, ok := e.quotes.Get(a.Symbol); ok {
+		q = &cur
+	}
+	if err := a.Validate(e.db, q); err != nil {
+		return a, err
+	}
+	list, err := ListAlerts(e.db, userID)
+	if err != nil {
+		return a, err
+	}
+	if len(list) >= MaxAlerts {
+		return a, ErrTooManyAlerts
+	}
+	return a, writeJSON(e.db, db.ALERTS, alertKey(userID, a.ID), a)
+}
+
+// ListAlerts returns all alert rules of the user.
+func ListAlerts(dbh db.DBHandler, userID string) (list []AlertRule, err error) {
+	list = []AlertRule{}
+	var jerr error
+	prefix := idBytes(userID)
+	err = dbh.Scan(db.ALERTS, prefix, false, func(key, val []byte) bool {
+		if len(key) < len(prefix) || string(key[:len(prefix)]) != string(prefix) {
+			return false
+		}
+		a := AlertRule{}
+		if jerr = json.Unmarshal(val, &a); jerr != nil {
+			return false
+		}
+		list = append(list, a)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
+
+// DeleteAlert removes the alert rule of the user.
+func DeleteAlert(dbh db.DBHandler, userID, id string) error {
+	key := alertKey(userID, id)
+	if _, err := dbh.Read(db.ALERTS, key); db.IsNotFound(err) {
+		return ErrAlertNotFound
+	} else if err != nil {
+		return err
+	}
+	return dbh.Delete(db.ALERTS, key)
+}
+
+// findAlerts returns the active alert rules of the kind with the target.
+func findAlerts(dbh db.DBHandler, kind string, target []byte) ([]AlertRule, error) {
+	var keys [][]byte
+	prefix := alertTarget(kind, target)
+	err := AlertByTarget.Range(dbh, prefix, prefix, nil, false, func(ikey, pkey []byte) bool {
+		keys = append(keys, append([]byte{}, pkey...))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := []AlertRule{}
+	for _, key := range keys {
+		a := AlertRule{}
+		if err = readJSON(dbh, db.ALERTS, key, &a); db.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// checkPriceAlerts fires the price alerts of the instrument which levels are crossed by the quote.
+func (e *TCPWSEngine) checkPriceAlerts(q Quote) error {
+	list, err := findAlerts(e.db, AlertPrice, append([]byte(q.Symbol), 0))
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		c := q.Bid.Cmp(*a.Level)
+		if a.Direction == Above && c < 0 || a.Direction == Below && c > 0 {
+			continue
+		}
+		err = e.db.Modify(db.ALERTS, alertKey(a.UserID, a.ID), &jsonModifier{&a, func() error {
+			a.Triggered = q.Time / 1000
+			return nil
+		}})
+		if err != nil {
+			return err
+		}
+		price := q.Bid
+		err = e.notify(Notification{UserID: a.UserID, Kind: AlertPrice, RuleID: a.ID, Symbol: q.Symbol, Price: &price,
+			Text: q.Symbol + " has crossed " + a.Direction + " " + a.Level.String()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alertState notifies the users who watch the proposal or have voted on it about its new state.
+func (e *TCPWSEngine) alertState(p Proposal) error {
+	list, err := findAlerts(e.db, AlertState, idBytes(p.ID))
+	if err != nil {
+		return err
+	}
+	voted, err := findAlerts(e.db, AlertState, uuid.Nil.Bytes())
+	if err != nil {
+		return err
+	}
+	if len(voted) > 0 {
+		dyn := DynProp{}
+		if err = readJSON(e.db, db.DYNAMIC, idBytes(p.ID), &dyn); err != nil && !db.IsNotFound(err) {
+			return err
+		}
+		voters := map[string]bool{}
+		for _, v := range dyn.Votes {
+			voters[v] = true
+		}
+		for _, a := range voted {
+			if voters[a.UserID] {
+				list = append(list, a)
+			}
+		}
+	}
+	state := stateName(p.State)
+	// Every user is notified once
+	notified := map[string]bool{}
+	for _, a := range list {
+		if notified[a.UserID] {
+			continue
+		}
+		notified[a.UserID] = true
+		err = e.notify(Notification{UserID: a.UserID, Kind: AlertState, RuleID: a.ID, ProposalID: p.ID,
+			Symbol: p.Symbol, Text: "Proposal " + p.ID + " on " + p.Symbol + " is " + state + " now"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alertAuthor notifies the users who follow the author about the new proposal.
+func (e *TCPWSEngine) alertAuthor(p Proposal) error {
+	list, err := findAlerts(e.db, AlertAuthor, idBytes(p.AuthorID))
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		err = e.notify(Notification{UserID: a.UserID, Kind: AlertAuthor, RuleID: a.ID, ProposalID: p.ID,
+			Symbol: p.Symbol, Text: "New proposal on " + p.Symbol + " by the followed author"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alertMentions notifies the mentioned users. Notifications of the users
+// who have mention alerts refer to their rules.
+func (e *TCPWSEngine) alertMentions(m ChatMessage) error {
+	for _, user := range m.Mentions {
+		list, err := findAlerts(e.db, AlertMention, idBytes(user))
+		if err != nil {
+			return err
+		}
+		rule := ""
+		if len(list) > 0 {
+			rule = list[0].ID
+		}
+		text := m.Text
+		if utf8.RuneCountInString(text) > QuoteLength {
+			text = string([]rune(text)[:QuoteLength]) + "…"
+		}
+		err = e.notify(Notification{UserID: user, Kind: AlertMention, RuleID: rule, MessageID: m.ID,
+			Room: m.Room, Text: "You are mentioned: " + text})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestAlerts(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	user, author := uuid.NewV4().String(), uuid.NewV4().String()
+	quote := func(bid int64, sec int64) {
+		if err := e.PublishQuote(Quote{DefaultSymbol, NewPrice(bid, 5), NewPrice(bid+2, 5), sec * 1000}); err != nil {
+			t.Fatalf("PublishQuote error: %v", err)
+		}
+	}
+	quote(123000, 1000)
+	level := NewPrice(123500, 5)
+	// The direction is taken from the current quote
+	price, err := e.AddAlert(user, AlertRule{Kind: AlertPrice, Symbol: DefaultSymbol, Level: &level})
+	if err != nil {
+		t.Fatalf("AddAlert error: %v", err)
+	}
+	if price.Direction != Above {
+		t.Errorf("Unexpected direction: %s", price.Direction)
+	}
+	if _, err = e.AddAlert(user, AlertRule{Kind: AlertPrice, Symbol: "XXX"}); err == nil {
+		t.Errorf("AddAlert expected an error for the wrong price alert")
+	}
+	for _, a := range []AlertRule{{Kind: AlertState}, {Kind: AlertAuthor, AuthorID: author}, {Kind: AlertMention}} {
+		if _, err = e.AddAlert(user, a); err != nil {
+			t.Fatalf("AddAlert error: %v", err)
+		}
+	}
+	// The price alert fires once
+	quote(123400, 1100)
+	quote(123600, 1200)
+	quote(123700, 1300)
+	// The user has voted on the proposal which becomes a position
+	p := Proposal{
+		ID: uuid.NewV4().String(), AuthorID: author, Symbol: DefaultSymbol, Type: BuyStop, State: StatePending,
+		Price: NewPrice(123800, 5), StopLoss: NewPrice(123000, 5), TakeProfit: NewPrice(124500, 5),
+		Created: 1000, PendingExp: MaxPendingExp, PositionExp: MaxPositionExp,
+		History: []Event{{1000, NewPrice(123800, 5), StatePending}},
+	}
+	if err = writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if err = writeJSON(dbh, db.DYNAMIC, idBytes(p.ID), DynProp{ID: p.ID, Votes: []string{user}}); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	quote(123800, 1400)
+	// The followed author adds the proposal
+	p.ID = ""
+	if _, err = e.AddProposal(p); err != nil {
+		t.Fatalf("AddProposal error: %v", err)
+	}
+	if err = e.alertMentions(ChatMessage{ID: uuid.NewV4().String(), Text: "look", Mentions: []string{user, author}}); err != nil {
+		t.Fatalf("alertMentions error: %v", err)
+	}
+	inbox, err := ListNotifications(dbh, user, "", false, 0)
+	if err != nil {
+		t.Fatalf("ListNotifications error: %v", err)
+	}
+	kinds := []string{}
+	for _, n := range inbox.Data {
+		kinds = append(kinds, n.Kind)
+	}
+	if len(kinds) != 4 || kinds[3] != AlertPrice || kinds[2] != AlertState || kinds[1] != AlertAuthor || kinds[0] != AlertMention {
+		t.Errorf("Unexpected notifications: %v", kinds)
+	}
+	// Mentioned users without the rule are notified too
+	if inbox, _ = ListNotifications(dbh, author, "", false, 0); len(inbox.Data) != 1 || inbox.Data[0].Kind != AlertMention || inbox.Data[0].RuleID != "" {
+		t.Errorf("Unexpected notifications of the author: %+v", inbox.Data)
+	}
+	if err = DeleteAlert(dbh, user, price.ID); err != nil {
+		t.Errorf("DeleteAlert error: %v", err)
+	}
+	if err = DeleteAlert(dbh, user, price.ID); err != ErrAlertNotFound {
+		t.Errorf("DeleteAlert expected ErrAlertNotFound, got %v", err)
+	}
+	if alerts, _ := ListAlerts(dbh, user); len(alerts) != 3 {
+		t.Errorf("Unexpected alerts: %+v", alerts)
+	}
+}
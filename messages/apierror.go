@@ -0,0 +1,144 @@
+// apierror.go describes errors which are returned to API clients
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// Error codes which are not bound to a particular error
+const (
+	CodeBadRequest  = "bad_request"
+	CodeInvalidJSON = "invalid_json"
+	CodeValidation  = "validation_failed"
+	CodeNotFound    = "not_found"
+	CodeInternal    = "internal_error"
+)
+
+// APIError is an error which is sent to clients over HTTP and websockets.
+// Code is stable and can be used by clients to distinguish errors, Message is human readable.
+// Fields contains the errors of the request fields, RequestID identifies the failed HTTP request.
+type APIError struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Fields    FieldErrors `json:"fields,omitempty"`
+	RequestID string      `json:"requestid,omitempty"`
+}
+
+// Error returns the message of the error.
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError returns the error with the given HTTP status, code and message.
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// BadRequest returns the error of the malformed request.
+func BadRequest(message string) *APIError {
+	return NewAPIError(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+// apiErrors maps known errors to their HTTP statuses and codes.
+var apiErrors = map[error]APIError{}
+
+// RegisterError binds the error to the HTTP status and the code.
+// It must be called during the initialization of packages.
+func RegisterError(err error, status int, code string) {
+	apiErrors[err] = APIError{Status: status, Code: code}
+}
+
+func init() {
+	RegisterError(ErrSelfVote, http.StatusConflict, "self_vote")
+	RegisterError(ErrDuplicateVote, http.StatusConflict, "duplicate_vote")
+	RegisterError(ErrVotingClosed, http.StatusConflict, "voting_closed")
+	RegisterError(ErrWrongCursor, http.StatusBadRequest, "wrong_cursor")
+	RegisterError(ErrWrongRoom, http.StatusBadRequest, "wrong_room")
+	RegisterError(ErrNotMember, http.StatusForbidden, "not_member")
+	RegisterError(ErrSelfMessage, http.StatusBadRequest, "self_message")
+	RegisterError(ErrConversationNotFound, http.StatusNotFound, "conversation_not_found")
+	RegisterError(ErrBucketNotFound, http.StatusNotFound, "bucket_not_found")
+	RegisterError(ErrThreadLocked, http.StatusConflict, "thread_locked")
+	RegisterError(ErrNotAuthor, http.StatusForbidden, "not_author")
+	RegisterError(ErrWrongParent, http.StatusBadRequest, "wrong_parent")
+	RegisterError(ErrNotModerator, http.StatusForbidden, "not_moderator")
+	RegisterError(ErrMuted, http.StatusForbidden, "muted")
+	RegisterError(ErrBanned, http.StatusForbidden, "banned")
+	RegisterError(ErrMessageNotFound, http.StatusNotFound, "message_not_found")
+	RegisterError(ErrWrongAction, http.StatusBadRequest, "wrong_action")
+	RegisterError(ErrEditWindow, http.StatusConflict, "edit_window_passed")
+	RegisterError(ErrWrongReply, http.StatusBadRequest, "wrong_reply")
+	RegisterError(ErrWrongEmoji, http.StatusBadRequest, "wrong_emoji")
+	RegisterError(ErrTooManyReactions, http.StatusConflict, "too_many_reactions")
+	RegisterError(ErrTooManyMentions, http.StatusBadRequest, "too_many_mentions")
+	RegisterError(ErrWrongPrice, http.StatusBadRequest, "wrong_price")
+	RegisterError(ErrPriceOverflow, http.StatusBadRequest, "price_overflow")
+	RegisterError(ErrWrongQuote, http.StatusBadRequest, "wrong_quote")
+	RegisterError(ErrWrongCommand, http.StatusBadRequest, "wrong_command")
+	RegisterError(ErrWrongTimeframe, http.StatusBadRequest, "wrong_timeframe")
+	RegisterError(ErrWrongTicks, http.StatusBadRequest, "wrong_ticks")
+	RegisterError(ErrNoMargin, http.StatusUnprocessableEntity, "no_margin")
+	RegisterError(ErrJoinClosed, http.StatusConflict, "join_closed")
+	RegisterError(ErrMarketClosed, http.StatusConflict, "market_closed")
+	RegisterError(ErrAlreadyJoined, http.StatusConflict, "already_joined")
+	RegisterError(ErrNotJoined, http.StatusNotFound, "not_joined")
+	RegisterError(ErrMaxPositions, http.StatusUnprocessableEntity, "max_positions")
+	RegisterError(ErrMaxLots, http.StatusUnprocessableEntity, "max_lots")
+	RegisterError(ErrMaxExposure, http.StatusUnprocessableEntity, "max_exposure")
+	RegisterError(ErrDailyLoss, http.StatusUnprocessableEntity, "daily_loss")
+	RegisterError(ErrLeaveClosed, http.StatusConflict, "leave_closed")
+	RegisterError(ErrWrongMetric, http.StatusBadRequest, "wrong_metric")
+	RegisterError(ErrWrongPeriod, http.StatusBadRequest, "wrong_period")
+	RegisterError(ErrSeasonExists, http.StatusConflict, "season_exists")
+	RegisterError(ErrWrongRole, http.StatusBadRequest, "wrong_role")
+	RegisterError(ErrAlertNotFound, http.StatusNotFound, "alert_not_found")
+	RegisterError(ErrTooManyAlerts, http.StatusConflict, "too_many_alerts")
+	RegisterError(ErrNotificationNotFound, http.StatusNotFound, "notification_not_found")
+	RegisterError(ErrNameTaken, http.StatusConflict, "name_taken")
+	RegisterError(ErrWrongCredentials, http.StatusUnauthorized, "wrong_credentials")
+}
+
+// ToAPIError converts the error into APIError.
+// Unknown errors become internal errors which don't disclose their messages.
+func ToAPIError(err error) *APIError {
+	cause := errors.Cause(err)
+	if ae, ok := cause.(*APIError); ok {
+		copied := *ae
+		return &copied
+	}
+	// Errors of uncomparable types such as FieldErrors cannot be looked up
+	if reflect.TypeOf(cause).Comparable() {
+		if ae, ok := apiErrors[cause]; ok {
+			ae.Message = err.Error()
+			return &ae
+		}
+	}
+	switch c := cause.(type) {
+	case FieldErrors:
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeValidation, Message: c.Error(), Fields: c}
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return NewAPIError(http.StatusBadRequest, CodeInvalidJSON, err.Error())
+	case *strconv.NumError:
+		return BadRequest(err.Error())
+	}
+	switch {
+	case db.IsNotFound(err):
+		return NewAPIError(http.StatusNotFound, CodeNotFound, err.Error())
+	case strings.HasPrefix(cause.Error(), "uuid: "):
+		// go.uuid doesn't export its parsing errors
+		return BadRequest(err.Error())
+	}
+	return NewAPIError(http.StatusInternalServerError, CodeInternal, "internal server error")
+}
@@ -0,0 +1,41 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+func TestToAPIError(t *testing.T) {
+	_, uuidErr := uuid.FromString("x")
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errors.Wrap(ErrNotMember, "cannot post"), http.StatusForbidden, "not_member"},
+		{FieldErrors{"text": "must not be empty"}, http.StatusUnprocessableEntity, CodeValidation},
+		{json.Unmarshal([]byte("{"), &struct{}{}), http.StatusBadRequest, CodeInvalidJSON},
+		{uuidErr, http.StatusBadRequest, CodeBadRequest},
+		{BadRequest("id parameter is missing"), http.StatusBadRequest, CodeBadRequest},
+		{errors.New("secret \"details\""), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, c := range cases {
+		if ae := ToAPIError(c.err); ae.Status != c.status || ae.Code != c.code {
+			t.Errorf("Unexpected API error of %v: %+v", c.err, ae)
+		}
+	}
+	// Messages with quotes are serialized as valid json
+	ae := ToAPIError(errors.Wrap(ErrWrongRoom, `room "x"`))
+	data, _ := json.Marshal(ae)
+	v := map[string]interface{}{}
+	if err := json.Unmarshal(data, &v); err != nil || v["message"] != `room "x": wrong room id` {
+		t.Errorf("Unexpected json of API error: %s, %v", data, err)
+	}
+}
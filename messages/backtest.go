@@ -0,0 +1,158 @@
+// backtest.go runs proposals against historical ticks
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"io"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// Exit reasons of backtested proposals
+const (
+	// ExitStopLoss means the position is closed by the stop loss.
+	ExitStopLoss = "stoploss"
+	// ExitTakeProfit means the position is closed by the take profit.
+	ExitTakeProfit = "takeprofit"
+	// ExitExpired means the position is closed by the position expiration.
+	ExitExpired = "expired"
+	// ExitNotFilled means the pending order has expired before the fill.
+	ExitNotFilled = "notfilled"
+	// ExitOpen means the ticks are over while the position is open.
+	ExitOpen = "open"
+	// ExitPending means the ticks are over before the fill.
+	ExitPending = "pending"
+)
+
+// BacktestResult describes how the proposal would have played out.
+// Entry and exit times are UNIX seconds. Pips is the profit of the position,
+// open positions are valued by the last tick. Duration is the time in position in seconds.
+type BacktestResult struct {
+	ID         string  `json:"id"`
+	Symbol     string  `json:"symbol"`
+	State      byte    `json:"state"`
+	Exit       string  `json:"exit"`
+	Filled     bool    `json:"filled"`
+	EntryTime  int64   `json:"entrytime,omitempty"`
+	EntryPrice Price   `json:"entryprice"`
+	ExitTime   int64   `json:"exittime,omitempty"`
+	ExitPrice  Price   `json:"exitprice"`
+	Pips       Price   `json:"pips"`
+	Duration   int64   `json:"duration"`
+	History    []Event `json:"history"`
+}
+
+// ProfitPips returns the profit of the proposal position opened at entry and closed at exit in pips.
+func (p *Proposal) ProfitPips(pr Precision, entry, exit Price) Price {
+	pips := pr.Pips(entry, exit)
+	if !p.IsBuy() {
+		pips = Price{}.Sub(pips)
+	}
+	return pips
+}
+
+// backtestRun is the proposal under the backtest.
+type backtestRun struct {
+	p     Proposal
+	pr    Precision
+	start int64
+}
+
+// Backtest runs the proposals against the ticks of the source by the same rules which are applied
+// to live quotes. Every proposal starts as a pending order at the moment it has become pending
+// or at its creation time if it hasn't. Ticks before that moment are skipped.
+// Prices are compared with the precision of the instruments. The source is read to the end or
+// until all proposals are finished. Results are returned in the order of proposals.
+func Backtest(dbh db.DBHandler, proposals []Proposal, src FeedSource) ([]BacktestResult, error) {
+	runs := make([]backtestRun, len(proposals))
+	for i, p := range proposals {
+		run := backtestRun{p: p, pr: DefaultPrecision, start: p.Since(StatePending)}
+		inst, err := LookupInstrument(dbh, p.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if inst != nil {
+			run.pr = inst.Precision()
+		}
+		run.p.State = StatePending
+		run.p.History = []Event{{run.start, p.Price, StatePending}}
+		runs[i] = run
+	}
+	last := map[string]Quote{}
+	active := len(runs)
+	for active > 0 {
+		q, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if errors.Cause(err) == ErrWrongQuote {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		last[q.Symbol] = q
+		for i := range runs {
+			run := &runs[i]
+			if run.p.Symbol != q.Symbol || !run.p.Active() || q.Time/1000 < run.start {
+				continue
+			}
+			if ev, ok := run.p.Next(q); ok {
+				run.p.State = ev.State
+				run.p.History = append(run.p.History, ev)
+				if !run.p.Active() {
+					active--
+				}
+			}
+		}
+	}
+	results := make([]BacktestResult, len(runs))
+	for i := range runs {
+		results[i] = runs[i].result(last[runs[i].p.Symbol])
+	}
+	return results, nil
+}
+
+// result reports the outcome of the run. The last quote values the open position.
+func (run *backtestRun) result(last Quote) BacktestResult {
+	p := &run.p
+	r := BacktestResult{ID: p.ID, Symbol: p.Symbol, State: p.State, History: p.History}
+	switch p.State {
+	case StatePending:
+		r.Exit = ExitPending
+	case StateExpiredPending:
+		r.Exit = ExitNotFilled
+	case StatePosition:
+		r.Exit = ExitOpen
+	case StateExpiredPosition:
+		r.Exit = ExitExpired
+	case StateStopLoss:
+		r.Exit = ExitStopLoss
+	case StateTakeProfit:
+		r.Exit = ExitTakeProfit
+	}
+	var entry Event
+	for _, ev := range p.History {
+		if ev.State == StatePosition {
+			entry, r.Filled = ev, true
+		}
+	}
+	if !r.Filled {
+		return r
+	}
+	r.EntryTime, r.EntryPrice = entry.Time, entry.Value
+	if p.State == StatePosition {
+		r.ExitTime, r.ExitPrice = last.Time/1000, last.Bid
+		if !p.IsBuy() {
+			r.ExitPrice = last.Ask
+		}
+	} else {
+		exit := p.History[len(p.History)-1]
+		r.ExitTime, r.ExitPrice = exit.Time, exit.Value
+	}
+	r.Pips = p.ProfitPips(run.pr, r.EntryPrice, r.ExitPrice)
+	r.Duration = r.ExitTime - r.EntryTime
+	return r
+}
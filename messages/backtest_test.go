@@ -0,0 +1,67 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestBacktest(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	ticks := `symbol,bid,ask,time
+EURUSD,1.23400,1.23420,1000000
+EURUSD,1.23500,1.23520,1100000
+GBPUSD,1.50000,1.50030,1150000
+EURUSD,1.23900,1.23920,1200000
+EURUSD,1.23100,1.23120,1300000
+EURUSD,1.23200,1.23220,9000000
+`
+	base := Proposal{
+		Symbol: DefaultSymbol, Created: 1000, PendingExp: MinPendingExp, PositionExp: MinPositionExp,
+	}
+	buy, sell, late, never := base, base, base, base
+	buy.ID, buy.Type, buy.Price = "buy", BuyStop, NewPrice(123500, 5)
+	buy.StopLoss, buy.TakeProfit = NewPrice(123300, 5), NewPrice(123800, 5)
+	sell.ID, sell.Type, sell.Price = "sell", SellLimit, NewPrice(123500, 5)
+	sell.StopLoss, sell.TakeProfit = NewPrice(123900, 5), NewPrice(123000, 5)
+	// The position is open when ticks are over
+	late.ID, late.Type, late.Price, late.Created = "late", SellStop, NewPrice(123150, 5), 1250
+	late.StopLoss, late.TakeProfit = NewPrice(125000, 5), NewPrice(120000, 5)
+	late.PositionExp = 100000
+	never.ID, never.Type, never.Price = "never", BuyLimit, NewPrice(120000, 5)
+	never.StopLoss, never.TakeProfit = NewPrice(119000, 5), NewPrice(121000, 5)
+	src := NewCSVSource(ioutil.NopCloser(strings.NewReader(ticks)))
+	results, err := Backtest(dbh, []Proposal{buy, sell, late, never}, src)
+	if err != nil {
+		t.Fatalf("Backtest error: %v", err)
+	}
+	expected := []struct {
+		exit     string
+		entry    string
+		exitp    string
+		pips     string
+		duration int64
+	}{
+		{ExitTakeProfit, "1.23520", "1.23900", "38.0", 100},
+		{ExitStopLoss, "1.23500", "1.23920", "-42.0", 100},
+		{ExitOpen, "1.23100", "1.23220", "-12.0", 7700},
+		{ExitNotFilled, "0", "0", "0", 0},
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Exit != e.exit || r.EntryPrice.String() != e.entry || r.ExitPrice.String() != e.exitp ||
+			r.Pips.String() != e.pips || r.Duration != e.duration {
+			t.Errorf("Unexpected result of %s: %+v", r.ID, r)
+		}
+	}
+	if n := len(results[0].History); n != 3 || results[0].History[2].State != StateTakeProfit {
+		t.Errorf("Unexpected history: %+v", results[0].History)
+	}
+}
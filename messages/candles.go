@@ -0,0 +1,223 @@
+// candles.go aggregates quotes into OHLC candles
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// Limits of the candle list
+const (
+	DefaultCandles = 500
+	MaxCandles     = 5000
+)
+
+// ErrWrongTimeframe is returned for unknown candle timeframes.
+var ErrWrongTimeframe = errors.New("timeframe must be 1m, 5m, 1h or 1d")
+
+// Timeframe is a period of candles. Its duration is given in milliseconds.
+type Timeframe struct {
+	Name     string
+	Duration int64
+}
+
+// Timeframes lists all timeframes of candles.
+var Timeframes = []Timeframe{
+	{"1m", 60 * 1000},
+	{"5m", 5 * 60 * 1000},
+	{"1h", 60 * 60 * 1000},
+	{"1d", 24 * 60 * 60 * 1000},
+}
+
+// FindTimeframe returns the timeframe with the given name.
+func FindTimeframe(name string) (Timeframe, error) {
+	for _, tf := range Timeframes {
+		if tf.Name == name {
+			return tf, nil
+		}
+	}
+	return Timeframe{}, ErrWrongTimeframe
+}
+
+// Candle is an OHLC candle of bid prices which is stored in CANDLES database.
+// Time is the start of the candle period in UNIX milliseconds. Ticks is the number of quotes.
+// It is sent to the clients subscribed to "SYMBOL:TIMEFRAME" as type 12 message.
+type Candle struct {
+	Symbol    string `json:"symbol"`
+	Timeframe string `json:"tf"`
+	Time      int64  `json:"time"`
+	Open      Price  `json:"open"`
+	High      Price  `json:"high"`
+	Low       Price  `json:"low"`
+	Close     Price  `json:"close"`
+	Ticks     int    `json:"ticks"`
+}
+
+// CandleTopic returns the subscription topic of the candles of the instrument.
+func CandleTopic(symbol, tf string) string {
+	return symbol + ":" + tf
+}
+
+// candlePrefix returns the common key prefix of the candles: symbol + 0 + timeframe + 0.
+func candlePrefix(symbol, tf string) []byte {
+	return join([]byte(symbol), []byte{0}, []byte(tf), []byte{0})
+}
+
+// key returns the key of the candle in CANDLES database.
+func (c *Candle) key() []byte {
+	return join(candlePrefix(c.Symbol, c.Timeframe), encInt64(c.Time))
+}
+
+// add updates the candle by the price.
+func (c *Candle) add(p Price) {
+	if c.Ticks == 0 {
+		c.Open, c.High, c.Low = p, p, p
+	}
+	if p.Cmp(c.High) > 0 {
+		c.High = p
+	}
+	if p.Cmp(c.Low) < 0 {
+		c.Low = p
+	}
+	c.Close = p
+	c.Ticks++
+}
+
+// candleAggregator keeps the forming candles in memory.
+// Candles are written to the database when their period is over and on Flush.
+type candleAggregator struct {
+	mu      sync.Mutex
+	db      db.DBHandler
+	current map[string]*Candle
+}
+
+// newCandleAggregator returns the aggregator which stores candles in dbh.
+func newCandleAggregator(dbh db.DBHandler) *candleAggregator {
+	return &candleAggregator{db: dbh, current: map[string]*Candle{}}
+}
+
+// add updates the candles of all timeframes by the quote and returns them.
+func (a *candleAggregator) add(q Quote) ([]Candle, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	updated := make([]Candle, 0, len(Timeframes))
+	for _, tf := range Timeframes {
+		start := q.Time - q.Time%tf.Duration
+		topic := CandleTopic(q.Symbol, tf.Name)
+		c := a.current[topic]
+		if c == nil || c.Time != start {
+			if c != nil {
+				if err := writeJSON(a.db, db.CANDLES, c.key(), c); err != nil {
+					return nil, err
+				}
+			}
+			// The candle could be written before the restart
+			c = &Candle{Symbol: q.Symbol, Timeframe: tf.Name, Time: start}
+			if err := readJSON(a.db, db.CANDLES, c.key(), c); err != nil && !db.IsNotFound(err) {
+				return nil, err
+			}
+			a.current[topic] = c
+		}
+		c.add(q.Bid)
+		updated = append(updated, *c)
+	}
+	return updated, nil
+}
+
+// get returns the forming candle.
+func (a *candleAggregator) get(symbol, tf string) (Candle, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.current[CandleTopic(symbol, tf)]
+	if c == nil {
+		return Candle{}, false
+	}
+	return *c, true
+}
+
+// flush writes the forming candles to the database.
+func (a *candleAggregator) flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range a.current {
+		if err := writeJSON(a.db, db.CANDLES, c.key(), c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListCandles returns stored candles of the instrument which start within the range [from, to]
+// in UNIX milliseconds. Zero bounds mean no limit. If there are more than limit candles
+// the most recent ones are returned. Candles are sorted by time.
+func ListCandles(dbh db.DBHandler, symbol, tf string, from, to int64, limit int) (candles []Candle, err error) {
+	if _, err = FindTimeframe(tf); err != nil {
+		return
+	}
+	if limit <= 0 || limit > MaxCandles {
+		limit = DefaultCandles
+	}
+	prefix := candlePrefix(symbol, tf)
+	// The scan starts from the last candle before to
+	start := join(prefix, encInt64(math.MaxInt64))
+	if to != 0 {
+		start = join(prefix, encInt64(to))
+	}
+	lo := join(prefix, encInt64(from))
+	candles = []Candle{}
+	var jerr error
+	err = dbh.Scan(db.CANDLES, start, true, func(key, val []byte) bool {
+		if len(key) < len(prefix) || string(key[:len(prefix)]) != string(prefix) {
+			return false
+		}
+		if from != 0 && string(key) < string(lo) {
+			return false
+		}
+		c := Candle{}
+		if jerr = json.Unmarshal(val, &c); jerr != nil {
+			return false
+		}
+		candles = append(candles, c)
+		return len(candles) < limit
+	})
+	if err == nil {
+		err = jerr
+	}
+	for i, j := 0, len(candles)-1; i < j; i, j = i+1, j-1 {
+		candles[i], candles[j] = candles[j], candles[i]
+	}
+	return
+}
+
+// Candles returns the candles of the instrument like ListCandles does including the forming candle.
+func (e *TCPWSEngine) Candles(symbol, tf string, from, to int64, limit int) ([]Candle, error) {
+	if limit <= 0 || limit > MaxCandles {
+		limit = DefaultCandles
+	}
+	candles, err := ListCandles(e.db, symbol, tf, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := e.candles.get(symbol, tf)
+	if !ok || c.Time < from || (to != 0 && c.Time > to) {
+		return candles, nil
+	}
+	// The forming candle replaces its stored version
+	if n := len(candles); n > 0 && candles[n-1].Time == c.Time {
+		candles[n-1] = c
+	} else if n == 0 || candles[n-1].Time < c.Time {
+		candles = append(candles, c)
+		if len(candles) > limit {
+			candles = candles[1:]
+		}
+	}
+	return candles, nil
+}
@@ -0,0 +1,70 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+)
+
+func TestCandles(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	quotes := []struct {
+		bid  int64
+		time int64
+	}{
+		{123450, 60000}, {123480, 70000}, {123430, 80000}, {123440, 90000},
+		{123500, 120000}, {123510, 130000},
+	}
+	for _, q := range quotes {
+		bid := NewPrice(q.bid, 5)
+		if err := e.PublishQuote(Quote{DefaultSymbol, bid, bid.Add(NewPrice(2, 5)), q.time}); err != nil {
+			t.Fatalf("PublishQuote error: %v", err)
+		}
+	}
+	// The first minute is stored when the second one starts
+	stored, err := ListCandles(dbh, DefaultSymbol, "1m", 0, 0, 0)
+	if err != nil {
+		t.Fatalf("ListCandles error: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("Unexpected stored candles: %+v", stored)
+	}
+	c := stored[0]
+	if c.Time != 60000 || c.Open.String() != "1.23450" || c.High.String() != "1.23480" ||
+		c.Low.String() != "1.23430" || c.Close.String() != "1.23440" || c.Ticks != 4 {
+		t.Errorf("Unexpected candle: %+v", c)
+	}
+	candles, err := e.Candles(DefaultSymbol, "1m", 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Candles error: %v", err)
+	}
+	if len(candles) != 2 || candles[1].Time != 120000 || candles[1].Close.String() != "1.23510" || candles[1].Ticks != 2 {
+		t.Errorf("Unexpected candles: %+v", candles)
+	}
+	// The range and the limit select the most recent candles
+	if candles, _ = e.Candles(DefaultSymbol, "1m", 0, 0, 1); len(candles) != 1 || candles[0].Time != 120000 {
+		t.Errorf("Unexpected limited candles: %+v", candles)
+	}
+	if candles, _ = e.Candles(DefaultSymbol, "1m", 0, 60000, 0); len(candles) != 1 || candles[0].Time != 60000 {
+		t.Errorf("Unexpected candles before 60000: %+v", candles)
+	}
+	if candles, _ = e.Candles(DefaultSymbol, "5m", 0, 0, 0); len(candles) != 1 || candles[0].Time != 0 || candles[0].Ticks != 6 {
+		t.Errorf("Unexpected 5m candles: %+v", candles)
+	}
+	if _, err = e.Candles(DefaultSymbol, "2m", 0, 0, 0); err != ErrWrongTimeframe {
+		t.Errorf("Candles expected ErrWrongTimeframe, got %v", err)
+	}
+	// Forming candles are stored on flush
+	if err = e.candles.flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	if stored, _ = ListCandles(dbh, DefaultSymbol, "1m", 0, 0, 0); len(stored) != 2 {
+		t.Errorf("Unexpected stored candles after flush: %+v", stored)
+	}
+}
@@ -0,0 +1,310 @@
+// chatedit.go introduces editing, reactions, replies and mentions of chat messages
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"unicode"
+	"unicode/utf8"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Limits of chat messages
+const (
+	// EditWindow is the number of seconds during which the author can edit the message.
+	EditWindow = 900
+	// QuoteLength is the maximal number of runes of the replied message which are quoted.
+	QuoteLength = 100
+	// MaxMentions is the maximal number of users mentioned in the message.
+	MaxMentions = 10
+	// MaxReactions is the maximal number of different emojis of the message.
+	MaxReactions = 20
+)
+
+// Kinds of chat deltas
+const (
+	DeltaEdit    = "edit"
+	DeltaReact   = "react"
+	DeltaUnreact = "unreact"
+)
+
+var (
+	// ErrEditWindow is returned when the author edits the message after EditWindow.
+	ErrEditWindow = errors.New("message cannot be edited anymore")
+	// ErrWrongReply is returned when the message replies to the message of another room or to the hidden message.
+	ErrWrongReply = errors.New("replied message belongs to another room or it is hidden")
+	// ErrWrongEmoji is returned for reactions which are not a short emoji.
+	ErrWrongEmoji = errors.New("reaction must be a short emoji")
+	// ErrTooManyReactions is returned when the message has MaxReactions different emojis.
+	ErrTooManyReactions = errors.New("too many reactions")
+	// ErrTooManyMentions is returned when the message mentions more than MaxMentions users.
+	ErrTooManyMentions = errors.New("too many mentions")
+)
+
+// ChatDelta is sent to the clients as type 7 message when the chat message is edited or reacted.
+// Count is the number of users who reacted with the emoji after the change.
+type ChatDelta struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Room   string `json:"room"`
+	Text   string `json:"text,omitempty"`
+	Edited int64  `json:"edited,omitempty"`
+	Emoji  string `json:"emoji,omitempty"`
+	UserID string `json:"userid,omitempty"`
+	Count  int    `json:"count,omitempty"`
+}
+
+// findBucket returns the key of the chat bucket which contains the message.
+func findBucket(dbh db.DBHandler, msgID string) (bucket []byte, err error) {
+	id := idBytes(msgID)
+	err = ChatByMessage.Range(dbh, id, id, nil, false, func(ikey, pkey []byte) bool {
+		bucket = pkey
+		return false
+	})
+	if err == nil && bucket == nil {
+		err = ErrMessageNotFound
+	}
+	return
+}
+
+// ReadMessage reads the chat message with the given ID.
+func ReadMessage(dbh db.DBHandler, msgID string) (ChatMessage, error) {
+	bucket, err := findBucket(dbh, msgID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	cb := ChatBucket{}
+	if err = readJSON(dbh, db.CHAT, bucket, &cb); err != nil {
+		return ChatMessage{}, err
+	}
+	for _, m := range cb.Data {
+		if m.ID == msgID {
+			return m, nil
+		}
+	}
+	return ChatMessage{}, ErrMessageNotFound
+}
+
+// messageModifier is a Modifier which changes the message of the chat bucket by means of the function.
+// Change receives the unmarshalled bucket and the index of the message.
+type messageModifier struct {
+	ID     string
+	Change func(cb *ChatBucket, i int) error
+	Result ChatMessage
+	Found  bool
+}
+
+// Apply changes the message of the marshalled chat bucket.
+func (m *messageModifier) Apply(data []byte) ([]byte, error) {
+	cb := ChatBucket{}
+	if err := json.Unmarshal(data, &cb); err != nil {
+		return nil, err
+	}
+	for i := range cb.Data {
+		if cb.Data[i].ID != m.ID {
+			continue
+		}
+		m.Found = true
+		m.Result = cb.Data[i]
+		if err := m.Change(&cb, i); err != nil {
+			return nil, err
+		}
+		// The tombstone of the deleted message has no ID
+		if cb.Data[i].ID == m.ID {
+			m.Result = cb.Data[i]
+		}
+		return json.Marshal(cb)
+	}
+	return data, nil
+}
+
+// modifyMessage changes the chat message and returns its new version.
+func (e *TCPWSEngine) modifyMessage(msgID string, change func(cb *ChatBucket, i int) error) (ChatMessage, error) {
+	bucket, err := findBucket(e.db, msgID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	mm := &messageModifier{ID: msgID, Change: change}
+	e.chatmu.Lock()
+	err = e.db.Modify(db.CHAT, bucket, mm)
+	e.chatmu.Unlock()
+	if err == nil && !mm.Found {
+		err = ErrMessageNotFound
+	}
+	return mm.Result, err
+}
+
+// roomUsers returns the members of the room or the participants of the conversation.
+func roomUsers(dbh db.DBHandler, room string) ([]string, error) {
+	if r, err := ReadRoom(dbh, room); err == nil {
+		return r.Members, nil
+	} else if !db.IsNotFound(err) {
+		return nil, err
+	}
+	c, err := ReadConversation(dbh, room)
+	return c.Users, err
+}
+
+// notifyRoom sends the message to active clients of the room members or the conversation participants.
+func (e *TCPWSEngine) notifyRoom(room string, m Message) {
+	if users, err := roomUsers(e.db, room); err == nil {
+		e.SendTo(users, m)
+	}
+}
+
+// prepareChat checks the reply and mentions of the new message and resets the fields set by the server.
+func (e *TCPWSEngine) prepareChat(m *ChatMessage) error {
+	m.ID = uuid.NewV4().String()
+	m.Hidden, m.Edited, m.Reactions, m.Quote = false, 0, nil, ""
+	if m.ReplyTo != "" {
+		replied, err := ReadMessage(e.db, m.ReplyTo)
+		if err != nil {
+			return errors.Wrap(err, "cannot read the replied message")
+		}
+		// Quotes must not republish hidden messages
+		if replied.Room != m.Room || replied.Hidden {
+			return ErrWrongReply
+		}
+		m.Quote = replied.Text
+		if utf8.RuneCountInString(m.Quote) > QuoteLength {
+			m.Quote = string([]rune(m.Quote)[:QuoteLength]) + "…"
+		}
+	}
+	// Mentioned users must exist
+	mentions := []string{}
+	seen := map[string]bool{m.AuthorID: true}
+	for _, u := range m.Mentions {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		if _, err := readUser(e.db, u); err != nil {
+			return errors.Wrap(err, "cannot read the mentioned user")
+		}
+		mentions = append(mentions, u)
+	}
+	if len(mentions) > MaxMentions {
+		return ErrTooManyMentions
+	}
+	m.Mentions = nil
+	if len(mentions) > 0 {
+		m.Mentions = mentions
+	}
+	return nil
+}
+
+// EditChat replaces the text of the chat message by its author.
+// Muted and banned authors cannot edit their messages.
+// The change is sent to the members of the room as type 7 message.
+func (e *TCPWSEngine) EditChat(userID, msgID, text string) (ChatMessage, error) {
+	if text == "" {
+		return ChatMessage{}, FieldErrors{"text": "must not be empty"}
+	}
+	t := wallNow()
+	old, err := ReadMessage(e.db, msgID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if err = checkSanctions(e.db, userID, old.Room, t, ActBan, ActMute); err != nil {
+		return ChatMessage{}, err
+	}
+	e.chatmu.Lock()
+	filter := e.filter
+	e.chatmu.Unlock()
+	text = FilterText(text, filter)
+	m, err := e.modifyMessage(msgID, func(cb *ChatBucket, i int) error {
+		if cb.Data[i].AuthorID != userID {
+			return ErrNotAuthor
+		}
+		if t-cb.Data[i].Time > EditWindow {
+			return ErrEditWindow
+		}
+		cb.Data[i].Text = text
+		cb.Data[i].Edited = t
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	e.notifyRoom(m.Room, Message{MsgChatDelta, ChatDelta{Kind: DeltaEdit, ID: m.ID, Room: m.Room, Text: m.Text, Edited: m.Edited}})
+	return m, nil
+}
+
+// validEmoji checks that the reaction is a short sequence of symbols without letters, digits and spaces.
+func validEmoji(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > 8 {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// React adds or removes the reaction of the user to the chat message.
+// The user must be able to read the message. The change is sent to the members of the room as type 7 message.
+func (e *TCPWSEngine) React(userID, msgID, emoji string, add bool) (ChatMessage, error) {
+	if !validEmoji(emoji) {
+		return ChatMessage{}, ErrWrongEmoji
+	}
+	m, err := ReadMessage(e.db, msgID)
+	if err != nil {
+		return m, err
+	}
+	users, err := roomUsers(e.db, m.Room)
+	if err != nil {
+		return m, err
+	}
+	member := false
+	for _, u := range users {
+		member = member || u == userID
+	}
+	if !member {
+		return m, ErrNotMember
+	}
+	m, err = e.modifyMessage(msgID, func(cb *ChatBucket, i int) error {
+		r := cb.Data[i].Reactions
+		reacted := r[emoji]
+		found := -1
+		for j, u := range reacted {
+			if u == userID {
+				found = j
+			}
+		}
+		if add && found < 0 {
+			if r == nil {
+				r = map[string][]string{}
+			} else if len(reacted) == 0 && len(r) >= MaxReactions {
+				return ErrTooManyReactions
+			}
+			reacted = append(reacted, userID)
+		} else if !add && found >= 0 {
+			reacted = append(reacted[:found], reacted[found+1:]...)
+		}
+		if len(reacted) == 0 {
+			delete(r, emoji)
+		} else {
+			r[emoji] = reacted
+		}
+		cb.Data[i].Reactions = r
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	kind := DeltaReact
+	if !add {
+		kind = DeltaUnreact
+	}
+	e.notifyRoom(m.Room, Message{MsgChatDelta, ChatDelta{Kind: kind, ID: m.ID, Room: m.Room, Emoji: emoji,
+		UserID: userID, Count: len(m.Reactions[emoji])}})
+	return m, nil
+}
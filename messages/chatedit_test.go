@@ -0,0 +1,92 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+	"time"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestChatEdit(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	a, b := uuid.NewV4().String(), uuid.NewV4().String()
+	for _, id := range []string{a, b} {
+		if err := writeJSON(dbh, db.USERS, idBytes(id), User{ID: id}); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+		if _, err := e.JoinRoom(GeneralRoom, id); err != nil {
+			t.Fatalf("JoinRoom error: %v", err)
+		}
+	}
+	if err := e.PostChat(GeneralRoom, ChatMessage{AuthorID: a, Text: "first", Time: wallNow()}); err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	page, _ := ChatHistory(dbh, GeneralRoom, "", 10)
+	first := page.Data[0]
+	// Replies quote the replied message and mentions are checked
+	err := e.PostChat(GeneralRoom, ChatMessage{AuthorID: b, Text: "reply", Time: wallNow(), ReplyTo: first.ID, Mentions: []string{a, a, b}})
+	if err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	page, _ = ChatHistory(dbh, GeneralRoom, "", 10)
+	if r := page.Data[1]; r.ReplyTo != first.ID || r.Quote != "first" || len(r.Mentions) != 1 || r.Mentions[0] != a {
+		t.Errorf("Unexpected reply: %+v", r)
+	}
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: b, Text: "x", Mentions: []string{uuid.NewV4().String()}}); err == nil {
+		t.Errorf("PostChat expected an error for unknown mentioned user")
+	}
+	// Users who are not in the room are not mentioned
+	c := uuid.NewV4().String()
+	if err = writeJSON(dbh, db.USERS, idBytes(c), User{ID: c}); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: b, Text: "y", Time: wallNow(), Mentions: []string{c, a}}); err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	page, _ = ChatHistory(dbh, GeneralRoom, "", 10)
+	if r := page.Data[len(page.Data)-1]; len(r.Mentions) != 1 || r.Mentions[0] != a {
+		t.Errorf("Unexpected mentions: %v", r.Mentions)
+	}
+	// Only the author edits the message
+	if _, err = e.EditChat(b, first.ID, "edited"); err != ErrNotAuthor {
+		t.Errorf("EditChat expected ErrNotAuthor, got %v", err)
+	}
+	m, err := e.EditChat(a, first.ID, "edited")
+	if err != nil || m.Text != "edited" || m.Edited == 0 {
+		t.Errorf("Unexpected edited message: %+v, %v", m, err)
+	}
+	// Reactions are counted once per user
+	for _, u := range []string{a, b, b} {
+		if m, err = e.React(u, first.ID, "👍", true); err != nil {
+			t.Errorf("React error: %v", err)
+		}
+	}
+	if len(m.Reactions["👍"]) != 2 {
+		t.Errorf("Unexpected reactions: %v", m.Reactions)
+	}
+	if m, err = e.React(a, first.ID, "👍", false); err != nil || len(m.Reactions["👍"]) != 1 {
+		t.Errorf("Unexpected reactions: %v, %v", m.Reactions, err)
+	}
+	if _, err = e.React(a, first.ID, "like", true); err != ErrWrongEmoji {
+		t.Errorf("React expected ErrWrongEmoji, got %v", err)
+	}
+	// The edit window follows the wall clock while the game clock replays the past
+	defer func(c Clock) {
+		GameClock = c
+	}(GameClock)
+	GameClock = NewReplayClock(time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC))
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: a, Text: "old", Time: wallNow() - EditWindow - 1}); err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	page, _ = ChatHistory(dbh, GeneralRoom, "", 10)
+	if _, err = e.EditChat(a, page.Data[len(page.Data)-1].ID, "edited"); err != ErrEditWindow {
+		t.Errorf("EditChat expected ErrEditWindow, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+// chathistory.go pages through the chat history stored as a chain of chat buckets
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Limits of the chat page size
+const (
+	DefaultChatPage = 50
+	MaxChatPage     = 200
+)
+
+// ErrBucketNotFound is returned when the chat bucket doesn't exist or it isn't available to the user.
+var ErrBucketNotFound = errors.New("chat bucket is not found")
+
+// ChatPage is a flat page of chat messages in chronological order.
+// Before is the cursor of the previous(older) page. It is empty for the oldest page.
+type ChatPage struct {
+	Data   []ChatMessage `json:"data"`
+	Before string        `json:"before,omitempty"`
+}
+
+// chatCursor points to the position of the message in the chat bucket.
+// The cursor is represented as "<bucket id>:<message index>".
+type chatCursor struct {
+	Bucket uuid.UUID
+	Index  int
+}
+
+// String converts the cursor to its string representation.
+func (c chatCursor) String() string {
+	return c.Bucket.String() + ":" + strconv.Itoa(c.Index)
+}
+
+// parseChatCursor parses the string representation of the cursor.
+func parseChatCursor(s string) (c chatCursor, err error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		err = ErrWrongCursor
+		return
+	}
+	if c.Bucket, err = uuid.FromString(parts[0]); err != nil {
+		return
+	}
+	c.Index, err = strconv.Atoi(parts[1])
+	if err != nil || c.Index < 0 {
+		err = ErrWrongCursor
+	}
+	return
+}
+
+// readChatBucket reads the chat bucket with the given id.
+func readChatBucket(dbh db.DBHandler, id uuid.UUID) (cb ChatBucket, err error) {
+	var data []byte
+	if data, err = dbh.Read(db.CHAT, id.Bytes()); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &cb)
+	return
+}
+
+// ReadBucket reads the chat bucket with the given id for the user userID who is empty for anonymous users.
+// Buckets of private conversations are available only to their participants.
+// Tombstones of deleted messages are removed, hidden messages are kept for moderators only.
+func ReadBucket(dbh db.DBHandler, id uuid.UUID, userID string) (cb ChatBucket, err error) {
+	if cb, err = readChatBucket(dbh, id); db.IsNotFound(err) {
+		return cb, ErrBucketNotFound
+	} else if err != nil {
+		return
+	}
+	// All messages of the bucket belong to the same room
+	if len(cb.Data) > 0 && IsConversation(cb.Data[0].Room) {
+		conv, err := ReadConversation(dbh, cb.Data[0].Room)
+		if err != nil && !db.IsNotFound(err) {
+			return ChatBucket{}, err
+		}
+		if err != nil || !conv.HasUser(userID) {
+			return ChatBucket{}, ErrBucketNotFound
+		}
+	}
+	moderator := userID != "" && IsModerator(dbh, userID)
+	visible := make([]ChatMessage, 0, len(cb.Data))
+	for _, m := range cb.Data {
+		if !m.Deleted && (!m.Hidden || moderator) {
+			visible = append(visible, m)
+		}
+	}
+	cb.Data = visible
+	return
+}
+
+// ChatHistory returns at most limit messages of the room which were written before the cursor.
+// The cursor must point to the bucket of the room. Messages without the room belong to the general room.
+// If the cursor is empty the newest messages are returned starting from the last chat bucket of the room.
+// The bucket chain is walked by means of ChatBucket.Previous.
+func ChatHistory(dbh db.DBHandler, room, before string, limit int) (page ChatPage, err error) {
+	if limit <= 0 {
+		limit = DefaultChatPage
+	}
+	if limit > MaxChatPage {
+		limit = MaxChatPage
+	}
+	// Find the starting position
+	var cur chatCursor
+	var cb ChatBucket
+	if before == "" {
+		var last []byte
+		last, err = dbh.Read(db.CHAT, lastBucketKey(room))
+		if db.IsNotFound(err) {
+			// The room has no messages yet
+			page.Data = []ChatMessage{}
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+		if cur.Bucket, err = uuid.FromBytes(last); err != nil {
+			return
+		}
+		if cb, err = readChatBucket(dbh, cur.Bucket); err != nil {
+			return
+		}
+		cur.Index = len(cb.Data)
+	} else {
+		if cur, err = parseChatCursor(before); err != nil {
+			return
+		}
+		if cb, err = readChatBucket(dbh, cur.Bucket); err != nil {
+			return
+		}
+		// The cursor must not lead to the chain of another room or conversation
+		for _, m := range cb.Data {
+			if m.Room != room && (m.Room != "" || room != GeneralRoom) {
+				return page, ErrWrongCursor
+			}
+		}
+		if cur.Index > len(cb.Data) {
+			cur.Index = len(cb.Data)
+		}
+	}
+	// Walk the chain from the newest messages to the oldest ones
+	var newest []ChatMessage
+	for len(newest) < limit {
+		if cur.Index == 0 {
+			if cb.Previous == nil {
+				break
+			}
+			if cur.Bucket, err = uuid.FromString(*cb.Previous); err != nil {
+				return
+			}
+			if cb, err = readChatBucket(dbh, cur.Bucket); err != nil {
+				return
+			}
+			cur.Index = len(cb.Data)
+			continue
+		}
+		cur.Index--
+		// Hidden messages are kept for moderators only, deleted messages leave their tombstones
+		if m := cb.Data[cur.Index]; !m.Hidden && !m.Deleted {
+			newest = append(newest, m)
+		}
+	}
+	// Messages are returned in chronological order
+	page.Data = make([]ChatMessage, len(newest))
+	for i, m := range newest {
+		page.Data[len(newest)-1-i] = m
+	}
+	if cur.Index > 0 || cb.Previous != nil {
+		page.Before = cur.String()
+	}
+	return
+}
@@ -0,0 +1,91 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestChatHistory(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	if page, err := ChatHistory(dbh, GeneralRoom, "", 0); err != nil || len(page.Data) != 0 || page.Before != "" {
+		t.Errorf("Unexpected page of the empty room: %+v, %v", page, err)
+	}
+	// Three buckets of the general room: [0, 99], [100, 199] and [200, 249]
+	for i := 0; i < 2*ChatBucketSize+50; i++ {
+		m := ChatMessage{ID: uuid.NewV4().String(), Text: strconv.Itoa(i), Room: GeneralRoom, Hidden: i == 5}
+		if err := e.appendChat(GeneralRoom, m); err != nil {
+			t.Fatalf("appendChat error: %v", err)
+		}
+	}
+	// texts checks the first and the last messages of the page and its size
+	texts := func(page ChatPage, first, last string, n int) {
+		if len(page.Data) != n || page.Data[0].Text != first || page.Data[n-1].Text != last {
+			t.Errorf("Expected %d messages from %s to %s, got %d messages %+v", n, first, last, len(page.Data), page.Data)
+		}
+	}
+	// The newest page crosses the bucket boundary
+	page, err := ChatHistory(dbh, GeneralRoom, "", 120)
+	if err != nil {
+		t.Fatalf("ChatHistory error: %v", err)
+	}
+	texts(page, "130", "249", 120)
+	// The next page continues right before the cursor
+	middle := page.Before
+	if page, err = ChatHistory(dbh, GeneralRoom, middle, 120); err != nil {
+		t.Fatalf("ChatHistory error: %v", err)
+	}
+	texts(page, "10", "129", 120)
+	// The hidden message is skipped and the oldest page has no cursor
+	if page, err = ChatHistory(dbh, GeneralRoom, page.Before, 120); err != nil {
+		t.Fatalf("ChatHistory error: %v", err)
+	}
+	texts(page, "0", "9", 9)
+	if page.Before != "" {
+		t.Errorf("Unexpected cursor of the oldest page: %s", page.Before)
+	}
+	// The cursor at the start of the bucket continues from the previous bucket
+	bucket := strings.SplitN(middle, ":", 2)[0]
+	if page, err = ChatHistory(dbh, GeneralRoom, bucket+":0", 10); err != nil {
+		t.Fatalf("ChatHistory error: %v", err)
+	}
+	texts(page, "90", "99", 10)
+	// The index beyond the bucket is limited by its size
+	if page, err = ChatHistory(dbh, GeneralRoom, bucket+":1000", 10); err != nil {
+		t.Fatalf("ChatHistory error: %v", err)
+	}
+	texts(page, "190", "199", 10)
+	// Other rooms have their own chains
+	if err = e.appendChat(InstrumentRoom(DefaultSymbol), ChatMessage{Text: "eur"}); err != nil {
+		t.Fatalf("appendChat error: %v", err)
+	}
+	if page, err = ChatHistory(dbh, InstrumentRoom(DefaultSymbol), "", 0); err != nil {
+		t.Fatalf("ChatHistory error: %v", err)
+	}
+	texts(page, "eur", "eur", 1)
+	// Cursors of one room don't work in another one
+	if _, err = ChatHistory(dbh, InstrumentRoom(DefaultSymbol), middle, 10); err != ErrWrongCursor {
+		t.Errorf("ChatHistory expected ErrWrongCursor for the cursor of another room, got %v", err)
+	}
+	// Malformed cursors
+	for _, cursor := range []string{"abc", bucket + ":-1", bucket + ":x", bucket} {
+		if _, err = ChatHistory(dbh, GeneralRoom, cursor, 10); err != ErrWrongCursor {
+			t.Errorf("ChatHistory expected ErrWrongCursor for %q, got %v", cursor, err)
+		}
+	}
+	if _, err = ChatHistory(dbh, GeneralRoom, "wrong:1", 10); err == nil {
+		t.Errorf("ChatHistory expected an error for the wrong bucket id")
+	}
+	if _, err = ChatHistory(dbh, GeneralRoom, uuid.NewV4().String()+":1", 10); !db.IsNotFound(err) {
+		t.Errorf("ChatHistory expected not found error for the unknown bucket, got %v", err)
+	}
+}
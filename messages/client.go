@@ -0,0 +1,114 @@
+// client.go describes websocket clients of the server
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// Client is a websocket connection of the user.
+// It is safe to send messages to the client from multiple goroutines.
+// UserID is empty for anonymous users.
+type Client struct {
+	UserID string
+	conn   *websocket.Conn
+	mu     sync.Mutex
+	// quotes is a set of instrument symbols which quotes the client receives
+	quotes   map[string]bool
+	quotesmu sync.Mutex
+}
+
+// NewClient wraps the websocket connection of the user userID.
+func NewClient(conn *websocket.Conn, userID string) *Client {
+	return &Client{UserID: userID, conn: conn}
+}
+
+// Send writes the raw data to the client.
+func (c *Client) Send(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// SendMessages marshals the messages and sends them to the client.
+func (c *Client) SendMessages(m ...Message) error {
+	data, err := json.Marshal(WSData{m})
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+// SendError sends the error to the client as type 9 message.
+// The error has the same shape as errors of HTTP responses.
+func (c *Client) SendError(err error) error {
+	return c.SendMessages(Message{MsgError, ToAPIError(err)})
+}
+
+// ErrWrongCommand is returned for unknown websocket commands of clients.
+var ErrWrongCommand = errors.New("wrong command")
+
+// Subscription is a command of the client which changes the set of received quotes and candles.
+// Topics are instrument symbols for quotes like "EURUSD", CandleTopic for candles like "EURUSD:1m"
+// and LeaderboardTopic for leaderboards like "leaderboard:pnl:7d".
+type Subscription struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// HandleCommand executes the websocket command of the client.
+// The command has the shape of Message, its Data depends on the Type.
+func (c *Client) HandleCommand(data []byte) error {
+	cmd := struct {
+		Type byte            `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return err
+	}
+	switch cmd.Type {
+	case MsgSubscribe:
+		s := Subscription{}
+		if err := json.Unmarshal(cmd.Data, &s); err != nil {
+			return err
+		}
+		c.Subscribe(s.Subscribe, true)
+		c.Subscribe(s.Unsubscribe, false)
+		return nil
+	}
+	return ErrWrongCommand
+}
+
+// Subscribe starts or stops sending quotes of the instruments and other topics to the client.
+func (c *Client) Subscribe(symbols []string, on bool) {
+	c.quotesmu.Lock()
+	defer c.quotesmu.Unlock()
+	if c.quotes == nil {
+		c.quotes = map[string]bool{}
+	}
+	for _, s := range symbols {
+		if on {
+			c.quotes[s] = true
+		} else {
+			delete(c.quotes, s)
+		}
+	}
+}
+
+// Subscribed checks whether the client receives quotes of the instrument.
+func (c *Client) Subscribed(symbol string) bool {
+	c.quotesmu.Lock()
+	defer c.quotesmu.Unlock()
+	return c.quotes[symbol]
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() {
+	c.conn.Close()
+}
@@ -0,0 +1,57 @@
+// clock.go introduces the game clock which can follow replayed market data
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time of the game.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the clock of the operating system.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the clock of the operating system.
+var SystemClock Clock = systemClock{}
+
+// GameClock defines the creation times, deadlines and expirations of proposals.
+// It is SystemClock unless the server replays historical market data.
+var GameClock = SystemClock
+
+// ReplayClock shows the time of the last replayed quote. It is safe for concurrent use.
+type ReplayClock struct {
+	mu sync.RWMutex
+	t  time.Time
+}
+
+// NewReplayClock returns the clock which starts at the moment t.
+func NewReplayClock(t time.Time) *ReplayClock {
+	return &ReplayClock{t: t}
+}
+
+// Now returns the time of the last replayed quote.
+func (c *ReplayClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t
+}
+
+// Set moves the clock to the moment t. The clock never goes back.
+func (c *ReplayClock) Set(t time.Time) {
+	c.mu.Lock()
+	if t.After(c.t) {
+		c.t = t
+	}
+	c.mu.Unlock()
+}
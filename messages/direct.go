@@ -0,0 +1,219 @@
+// direct.go introduces private conversations between traders
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	// ErrSelfMessage is returned when the user sends the direct message to oneself.
+	ErrSelfMessage = errors.New("cannot send the message to yourself")
+	// ErrConversationNotFound is returned when the user doesn't participate in the conversation.
+	ErrConversationNotFound = errors.New("conversation is not found")
+)
+
+// conversationPrefix starts IDs of private conversations.
+const conversationPrefix = "dm:"
+
+// ConvByUser indexes conversations by the participant and the time of the last message.
+var ConvByUser *db.Index
+
+func init() {
+	ConvByUser = db.DeclareIndex("conversations.user", db.CONVERSATIONS, func(key, val []byte) [][]byte {
+		c := Conversation{}
+		if json.Unmarshal(val, &c) != nil {
+			return nil
+		}
+		keys := make([][]byte, len(c.Users))
+		for i, u := range c.Users {
+			keys[i] = join(idBytes(u), encInt64(c.LastTime))
+		}
+		return keys
+	})
+}
+
+// Conversation is a private conversation of two users which is stored in CONVERSATIONS database.
+// Its messages are stored in the chat bucket chain with the conversation ID.
+// Unread maps the user to the number of messages which the user hasn't read yet.
+type Conversation struct {
+	ID       string         `json:"id"`
+	Users    []string       `json:"users"`
+	LastTime int64          `json:"lasttime"`
+	LastText string         `json:"lasttext"`
+	Unread   map[string]int `json:"unread"`
+}
+
+// ConversationID returns the ID of the conversation between two users.
+// The ID doesn't depend on the order of users.
+func ConversationID(a, b string) string {
+	users := []string{a, b}
+	sort.Strings(users)
+	return conversationPrefix + strings.Join(users, ":")
+}
+
+// IsConversation checks whether the room ID is the ID of a private conversation.
+func IsConversation(room string) bool {
+	return strings.HasPrefix(room, conversationPrefix)
+}
+
+// HasUser checks whether the user participates in the conversation.
+func (c *Conversation) HasUser(userID string) bool {
+	for _, u := range c.Users {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadConversation reads the conversation with the given ID.
+func ReadConversation(dbh db.DBHandler, id string) (c Conversation, err error) {
+	var data []byte
+	if data, err = dbh.Read(db.CONVERSATIONS, []byte(id)); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &c)
+	return
+}
+
+// ListConversations returns the conversations of the user starting from the most recent one.
+// It returns at most limit conversations which were active before the UNIX time before.
+// Zero before means no limit.
+func ListConversations(dbh db.DBHandler, userID string, before int64, limit int) (convs []Conversation, err error) {
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	prefix := idBytes(userID)
+	hi := prefix
+	if before != 0 {
+		hi = join(prefix, encInt64(before-1))
+	}
+	convs = []Conversation{}
+	var rerr error
+	err = ConvByUser.Range(dbh, prefix, hi, nil, true, func(ikey, pkey []byte) bool {
+		var c Conversation
+		if c, rerr = ReadConversation(dbh, string(pkey)); rerr != nil {
+			return false
+		}
+		convs = append(convs, c)
+		return len(convs) < limit
+	})
+	if err == nil {
+		err = rerr
+	}
+	return
+}
+
+// ConversationHistory returns the page of the conversation of the user with the user with.
+// The page is defined like in ChatHistory.
+func ConversationHistory(dbh db.DBHandler, userID, with, before string, limit int) (page ChatPage, err error) {
+	id := ConversationID(userID, with)
+	var conv Conversation
+	if conv, err = ReadConversation(dbh, id); db.IsNotFound(err) || err == nil && !conv.HasUser(userID) {
+		return page, ErrConversationNotFound
+	} else if err != nil {
+		return
+	}
+	return ChatHistory(dbh, id, before, limit)
+}
+
+// convMessage is a Modifier which registers the new message in the conversation.
+type convMessage struct {
+	Msg    ChatMessage
+	To     string
+	Result Conversation
+}
+
+// Apply updates the last message and the unread counter of the marshalled conversation.
+func (m *convMessage) Apply(data []byte) ([]byte, error) {
+	if err := json.Unmarshal(data, &m.Result); err != nil {
+		return nil, err
+	}
+	if m.Result.Unread == nil {
+		m.Result.Unread = map[string]int{}
+	}
+	m.Result.Unread[m.To]++
+	m.Result.LastTime = m.Msg.Time
+	m.Result.LastText = m.Msg.Text
+	return json.Marshal(m.Result)
+}
+
+// convRead is a Modifier which resets the unread counter of the user.
+type convRead struct {
+	UserID string
+}
+
+// Apply resets the unread counter of the marshalled conversation.
+func (r *convRead) Apply(data []byte) ([]byte, error) {
+	c := Conversation{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Unread[r.UserID] == 0 {
+		return data, nil
+	}
+	c.Unread[r.UserID] = 0
+	return json.Marshal(c)
+}
+
+// SendDirect sends the private message m from m.AuthorID to the user to.
+// The message is stored in the conversation of two users and delivered to
+// active websocket clients of both users as type 5 message.
+func (e *TCPWSEngine) SendDirect(to string, m ChatMessage) (Conversation, error) {
+	if to == m.AuthorID {
+		return Conversation{}, ErrSelfMessage
+	}
+	toID, err := uuid.FromString(to)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if _, err = e.db.Read(db.USERS, toID.Bytes()); err != nil {
+		return Conversation{}, errors.Wrap(err, "cannot read the recipient")
+	}
+	if err = checkSanctions(e.db, m.AuthorID, "", wallNow(), ActBan, ActMute); err != nil {
+		return Conversation{}, err
+	}
+	id := ConversationID(m.AuthorID, to)
+	m.Room = id
+	// Other users are not mentioned in private conversations
+	m.Mentions = nil
+	if err = e.prepareChat(&m); err != nil {
+		return Conversation{}, err
+	}
+	e.chatmu.Lock()
+	defer e.chatmu.Unlock()
+	m.Text = FilterText(m.Text, e.filter)
+	// Create the conversation at the first message
+	_, err = ReadConversation(e.db, id)
+	if db.IsNotFound(err) {
+		data, _ := json.Marshal(Conversation{ID: id, Users: []string{m.AuthorID, to}, Unread: map[string]int{}})
+		err = e.db.Write(db.CONVERSATIONS, []byte(id), data)
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+	if err = e.appendChat(id, m); err != nil {
+		return Conversation{}, err
+	}
+	cm := &convMessage{Msg: m, To: to}
+	if err = e.db.Modify(db.CONVERSATIONS, []byte(id), cm); err != nil {
+		return Conversation{}, err
+	}
+	e.SendTo(cm.Result.Users, Message{MsgDirect, m})
+	return cm.Result, nil
+}
+
+// MarkRead resets the number of unread messages of the user in the conversation.
+func (e *TCPWSEngine) MarkRead(convID, userID string) error {
+	return e.db.Modify(db.CONVERSATIONS, []byte(convID), &convRead{userID})
+}
@@ -0,0 +1,100 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestDirect(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	a, b, c := uuid.NewV4().String(), uuid.NewV4().String(), uuid.NewV4().String()
+	for _, u := range []string{a, b, c} {
+		if err := writeJSON(dbh, db.USERS, idBytes(u), User{ID: u}); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+	}
+	if ConversationID(a, b) != ConversationID(b, a) || !IsConversation(ConversationID(a, b)) || IsConversation(GeneralRoom) {
+		t.Errorf("Unexpected conversation IDs")
+	}
+	if _, err := e.SendDirect(a, ChatMessage{AuthorID: a, Text: "me"}); err != ErrSelfMessage {
+		t.Errorf("SendDirect expected ErrSelfMessage, got %v", err)
+	}
+	if _, err := e.SendDirect(uuid.NewV4().String(), ChatMessage{AuthorID: a, Text: "nobody"}); !db.IsNotFound(err) {
+		t.Errorf("SendDirect expected not found error for the unknown recipient, got %v", err)
+	}
+	// Conversations are created by the first message and count unread messages
+	for i, m := range []struct {
+		from, to, text string
+		time           int64
+	}{{a, b, "hi b", 10}, {b, a, "hi a", 20}, {a, b, "how are you", 30}, {c, a, "hello", 40}} {
+		conv, err := e.SendDirect(m.to, ChatMessage{AuthorID: m.from, Text: m.text, Time: m.time})
+		if err != nil {
+			t.Fatalf("SendDirect error: %v", err)
+		}
+		if conv.LastText != m.text || conv.LastTime != m.time {
+			t.Errorf("Unexpected conversation after the message %d: %+v", i, conv)
+		}
+	}
+	conv, err := ReadConversation(dbh, ConversationID(a, b))
+	if err != nil || conv.Unread[a] != 1 || conv.Unread[b] != 2 || !conv.HasUser(a) || conv.HasUser(c) {
+		t.Errorf("Unexpected conversation: %+v, %v", conv, err)
+	}
+	// Conversations go from the most recent one
+	convs, err := ListConversations(dbh, a, 0, 0)
+	if err != nil || len(convs) != 2 || convs[0].ID != ConversationID(a, c) || convs[1].ID != ConversationID(a, b) {
+		t.Errorf("Unexpected conversations: %+v, %v", convs, err)
+	}
+	if convs, _ = ListConversations(dbh, a, 40, 0); len(convs) != 1 || convs[0].ID != ConversationID(a, b) {
+		t.Errorf("Unexpected conversations before 40: %+v", convs)
+	}
+	if convs, _ = ListConversations(dbh, a, 0, 1); len(convs) != 1 || convs[0].ID != ConversationID(a, c) {
+		t.Errorf("Unexpected limited conversations: %+v", convs)
+	}
+	if convs, _ = ListConversations(dbh, b, 0, 0); len(convs) != 1 {
+		t.Errorf("Unexpected conversations of b: %+v", convs)
+	}
+	// Reading resets the counter of the user only
+	if err = e.MarkRead(ConversationID(a, b), b); err != nil {
+		t.Fatalf("MarkRead error: %v", err)
+	}
+	if conv, _ = ReadConversation(dbh, ConversationID(a, b)); conv.Unread[a] != 1 || conv.Unread[b] != 0 {
+		t.Errorf("Unexpected unread counters: %+v", conv.Unread)
+	}
+	// Only participants read the history
+	page, err := ConversationHistory(dbh, b, a, "", 0)
+	if err != nil || len(page.Data) != 3 || page.Data[0].Text != "hi b" || page.Data[2].Room != ConversationID(a, b) {
+		t.Errorf("Unexpected history: %+v, %v", page, err)
+	}
+	if _, err = ConversationHistory(dbh, b, c, "", 0); err != ErrConversationNotFound {
+		t.Errorf("ConversationHistory expected ErrConversationNotFound, got %v", err)
+	}
+	// Buckets of conversations are hidden from other users
+	last, err := dbh.Read(db.CHAT, lastBucketKey(ConversationID(a, b)))
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	bucket := uuid.FromBytesOrNil(last)
+	if cb, err := ReadBucket(dbh, bucket, a); err != nil || len(cb.Data) != 3 {
+		t.Errorf("Unexpected bucket of the participant: %+v, %v", cb, err)
+	}
+	for _, user := range []string{c, ""} {
+		if _, err = ReadBucket(dbh, bucket, user); err != ErrBucketNotFound {
+			t.Errorf("ReadBucket expected ErrBucketNotFound for %q, got %v", user, err)
+		}
+	}
+	if _, err = ReadBucket(dbh, uuid.NewV4(), a); err != ErrBucketNotFound {
+		t.Errorf("ReadBucket expected ErrBucketNotFound for the unknown bucket, got %v", err)
+	}
+	// Cursors of conversations don't work in the room history
+	if _, err = ChatHistory(dbh, GeneralRoom, bucket.String()+":3", 10); err != ErrWrongCursor {
+		t.Errorf("ChatHistory expected ErrWrongCursor for the conversation cursor, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// execution.go moves proposals through their states by market quotes
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+
+	"union/db"
+)
+
+// PropActive indexes proposals which are not finished yet by the symbol and the creation time.
+var PropActive *db.Index
+
+func init() {
+	PropActive = db.DeclareIndex("proposals.active", db.PROPOSALS, func(key, val []byte) [][]byte {
+		p := Proposal{}
+		if json.Unmarshal(val, &p) != nil || !p.Active() {
+			return nil
+		}
+		return [][]byte{join([]byte(p.Symbol), []byte{0}, encInt64(p.Created))}
+	})
+}
+
+// Active checks whether the proposal collects votes, waits for the price or is an open position.
+func (p *Proposal) Active() bool {
+	return p.State == StateProposal || p.State == StatePending || p.State == StatePosition
+}
+
+// IsBuy checks whether the proposal buys the instrument.
+func (p *Proposal) IsBuy() bool {
+	return p.Type == BuyStop || p.Type == BuyLimit
+}
+
+// Since returns the time when the proposal has entered the state according to its history.
+// It returns the creation time if there is no such event.
+func (p *Proposal) Since(state byte) int64 {
+	for i := len(p.History) - 1; i >= 0; i-- {
+		if p.History[i].State == state {
+			return p.History[i].Time
+		}
+	}
+	return p.Created
+}
+
+// Next returns the event which the quote causes to the proposal.
+// Orders are filled and positions are closed at the market price:
+// buy orders are opened by the ask price and closed by the bid price, sell orders vice versa.
+// Expirations are checked first. ok is false if the proposal stays in its state.
+func (p *Proposal) Next(q Quote) (ev Event, ok bool) {
+	t := q.Time / 1000
+	open, close := q.Ask, q.Bid
+	if !p.IsBuy() {
+		open, close = q.Bid, q.Ask
+	}
+	switch p.State {
+	case StateProposal:
+		if t > p.Deadline {
+			return Event{t, open, StateExpiredProposal}, true
+		}
+	case StatePending:
+		if t > p.Since(StatePending)+p.PendingExp {
+			return Event{t, open, StateExpiredPending}, true
+		}
+		var fill bool
+		switch p.Type {
+		case BuyStop, SellLimit:
+			fill = open.Cmp(p.Price) >= 0
+		case BuyLimit, SellStop:
+			fill = open.Cmp(p.Price) <= 0
+		}
+		if fill {
+			return Event{t, open, StatePosition}, true
+		}
+	case StatePosition:
+		if t > p.Since(StatePosition)+p.PositionExp {
+			return Event{t, close, StateExpiredPosition}, true
+		}
+		// Stop loss is checked before take profit for the safety
+		sl, tp := close.Cmp(p.StopLoss), close.Cmp(p.TakeProfit)
+		if !p.IsBuy() {
+			sl, tp = -sl, -tp
+		}
+		if sl <= 0 {
+			return Event{t, close, StateStopLoss}, true
+		}
+		if tp >= 0 {
+			return Event{t, close, StateTakeProfit}, true
+		}
+	}
+	return
+}
+
+// propExecution is a Modifier which applies the event caused by the quote to the proposal.
+// Changed is set if the proposal has changed its state.
+type propExecution struct {
+	Quote   Quote
+	Result  Proposal
+	Changed bool
+}
+
+// Apply applies the next event of the marshalled proposal.
+func (x *propExecution) Apply(data []byte) ([]byte, error) {
+	if err := json.Unmarshal(data, &x.Result); err != nil {
+		return nil, err
+	}
+	ev, ok := x.Result.Next(x.Quote)
+	if !ok {
+		return data, nil
+	}
+	x.Result.State = ev.State
+	x.Result.History = append(x.Result.History, ev)
+	x.Changed = true
+	return json.Marshal(x.Result)
+}
+
+// execute applies the quote to the active proposals of its instrument
+// and broadcasts the changes of their states. Users who watch the proposals are notified.
+func (e *TCPWSEngine) execute(q Quote) error {
+	var ids [][]byte
+	prefix := join([]byte(q.Symbol), []byte{0})
+	err := PropActive.Range(e.db, prefix, prefix, nil, false, func(ikey, pkey []byte) bool {
+		ids = append(ids, append([]byte{}, pkey...))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		p := Proposal{}
+		if err = readJSON(e.db, db.PROPOSALS, id, &p); db.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		// Most quotes don't change proposals so they are checked before the write
+		if _, ok := p.Next(q); !ok {
+			continue
+		}
+		x := &propExecution{Quote: q}
+		if err = e.db.Modify(db.PROPOSALS, id, x); err != nil {
+			return err
+		}
+		if !x.Changed {
+			continue
+		}
+		e.Broadcast(Message{MsgUpdateProposal, ProposalUpdate{x.Result.ID, x.Result.Score, x.Result.State}})
+		if err = e.settle(x.Result); err != nil {
+			return err
+		}
+		if err = e.alertState(x.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// feed.go introduces the market price feed and the cache of the latest quotes
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrWrongQuote is returned for malformed quotes and quotes of unknown instruments.
+// The feed skips such quotes.
+var ErrWrongQuote = errors.New("wrong quote")
+
+// Quote is the bid and ask prices of the instrument at the UNIX time in milliseconds.
+// It is sent to subscribed clients as type 10 message.
+type Quote struct {
+	Symbol string `json:"symbol"`
+	Bid    Price  `json:"bid"`
+	Ask    Price  `json:"ask"`
+	Time   int64  `json:"time"`
+}
+
+// ParseQuote parses the quote line "SYMBOL BID ASK TIME" like "EURUSD 1.23450 1.23470 1493062222000".
+func ParseQuote(line string) (q Quote, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return q, errors.Wrap(ErrWrongQuote, line)
+	}
+	q.Symbol = fields[0]
+	if q.Bid, err = ParsePrice(fields[1]); err != nil {
+		return q, errors.Wrap(ErrWrongQuote, line)
+	}
+	if q.Ask, err = ParsePrice(fields[2]); err != nil {
+		return q, errors.Wrap(ErrWrongQuote, line)
+	}
+	if q.Time, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return q, errors.Wrap(ErrWrongQuote, line)
+	}
+	return q, nil
+}
+
+// FeedSource is a source of market quotes like the trigger connection, a replay file or a simulator.
+type FeedSource interface {
+	// Next blocks until the next quote is available. It returns io.EOF when the source is exhausted.
+	// Errors caused by ErrWrongQuote don't stop the feed.
+	Next() (Quote, error)
+	Close() error
+}
+
+// lineSource reads quote lines from the stream.
+type lineSource struct {
+	r       io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// NewLineSource returns the source which reads quote lines in ParseQuote format from r.
+// Empty lines and lines starting with # are skipped.
+// The trigger connection and plain text replay files use this format.
+func NewLineSource(r io.ReadCloser) FeedSource {
+	return &lineSource{r, bufio.NewScanner(r)}
+}
+
+// Next reads the next quote line.
+func (s *lineSource) Next() (Quote, error) {
+	for s.scanner.Scan() {
+		line := strings.TrimSpace(s.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return ParseQuote(line)
+	}
+	if err := s.scanner.Err(); err != nil {
+		return Quote{}, err
+	}
+	return Quote{}, io.EOF
+}
+
+// Close closes the underlying stream.
+func (s *lineSource) Close() error {
+	return s.r.Close()
+}
+
+// QuoteCache keeps the latest quote of each instrument. It is safe for concurrent use.
+type QuoteCache struct {
+	mu     sync.RWMutex
+	quotes map[string]Quote
+}
+
+// NewQuoteCache returns an empty quote cache.
+func NewQuoteCache() *QuoteCache {
+	return &QuoteCache{quotes: map[string]Quote{}}
+}
+
+// Update stores the quote unless the cache has a newer quote of the instrument.
+// It returns false if the quote is outdated.
+func (c *QuoteCache) Update(q Quote) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.quotes[q.Symbol]; ok && last.Time > q.Time {
+		return false
+	}
+	c.quotes[q.Symbol] = q
+	return true
+}
+
+// Get returns the latest quote of the instrument.
+func (c *QuoteCache) Get(symbol string) (Quote, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	q, ok := c.quotes[symbol]
+	return q, ok
+}
+
+// All returns the latest quotes of all instruments in the order of their symbols.
+func (c *QuoteCache) All() []Quote {
+	c.mu.RLock()
+	quotes := make([]Quote, 0, len(c.quotes))
+	for _, q := range c.quotes {
+		quotes = append(quotes, q)
+	}
+	c.mu.RUnlock()
+	sort.Slice(quotes, func(i, j int) bool { return quotes[i].Symbol < quotes[j].Symbol })
+	return quotes
+}
+
+// Quotes returns the cache of the latest quotes.
+func (e *TCPWSEngine) Quotes() *QuoteCache {
+	return e.quotes
+}
+
+// PublishQuote checks the quote against the instrument catalogue, stores it in the cache
+// and sends it to the clients subscribed to the instrument as type 10 message.
+// The candles updated by the quote are sent to their subscribers as type 12 messages.
+// Then the quote fills, closes and expires the active proposals of the instrument
+// and fires the price alerts which levels it has crossed.
+// Outdated quotes are ignored.
+func (e *TCPWSEngine) PublishQuote(q Quote) error {
+	inst, err := LookupInstrument(e.db, q.Symbol)
+	if err != nil {
+		return err
+	}
+	if inst == nil {
+		return errors.Wrap(ErrWrongQuote, "unknown instrument "+q.Symbol)
+	}
+	pr := inst.Precision()
+	if q.Bid, err = pr.Normalize(q.Bid); err != nil {
+		return errors.Wrap(ErrWrongQuote, err.Error())
+	}
+	if q.Ask, err = pr.Normalize(q.Ask); err != nil {
+		return errors.Wrap(ErrWrongQuote, err.Error())
+	}
+	if q.Bid.Sign() <= 0 || q.Ask.Cmp(q.Bid) < 0 {
+		return errors.Wrapf(ErrWrongQuote, "bid %s and ask %s", q.Bid, q.Ask)
+	}
+	if !e.quotes.Update(q) {
+		return nil
+	}
+	e.send(Message{MsgQuote, q}, func(c *Client) bool {
+		return c.Subscribed(q.Symbol)
+	})
+	candles, err := e.candles.add(q)
+	if err != nil {
+		return err
+	}
+	for _, candle := range candles {
+		topic := CandleTopic(candle.Symbol, candle.Timeframe)
+		e.send(Message{MsgCandle, candle}, func(c *Client) bool {
+			return c.Subscribed(topic)
+		})
+	}
+	if err = e.execute(q); err != nil {
+		return err
+	}
+	return e.checkPriceAlerts(q)
+}
+
+// RunFeed publishes the quotes of the source until it is exhausted or fails.
+// Wrong quotes are skipped. Other errors of publishing are passed to onError
+// and the feed goes on with the next quote. The source is closed at the end.
+func (e *TCPWSEngine) RunFeed(src FeedSource, onError func(error)) error {
+	defer src.Close()
+	for {
+		q, err := src.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil && errors.Cause(err) != ErrWrongQuote {
+			return err
+		} else if err != nil {
+			continue
+		}
+		if err = e.PublishQuote(q); err != nil && errors.Cause(err) != ErrWrongQuote && onError != nil {
+			onError(errors.Wrapf(err, "cannot publish the quote %+v", q))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"union/db"
+)
+
+func TestFeed(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	// The broken instrument fails the quote
+	if err := dbh.Write(db.INSTRUMENTS, []byte("BAD"), []byte("{")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	// Malformed, unknown, crossed and outdated quotes are skipped, failed quotes are reported
+	lines := `# symbol bid ask time
+EURUSD 1.23440 1.23460 1500
+BAD 1.1 1.2 1800
+EURUSD 1.23450 1.23470 2000
+EURUSD 1.2345
+GBPUSD 1.5 1.6 2000
+EURUSD 1.23480 1.23470 3000
+EURUSD 1.1 1.2 1000
+`
+	var failed []error
+	err := e.RunFeed(NewLineSource(ioutil.NopCloser(strings.NewReader(lines))), func(err error) {
+		failed = append(failed, err)
+	})
+	if err != nil {
+		t.Fatalf("RunFeed error: %v", err)
+	}
+	if len(failed) != 1 || !strings.Contains(failed[0].Error(), "BAD") {
+		t.Errorf("Unexpected failed quotes: %v", failed)
+	}
+	q, ok := e.Quotes().Get(DefaultSymbol)
+	if !ok || q.Bid.String() != "1.23450" || q.Ask.String() != "1.23470" || q.Time != 2000 {
+		t.Errorf("Unexpected quote: %+v, %v", q, ok)
+	}
+	if all := e.Quotes().All(); len(all) != 1 {
+		t.Errorf("Unexpected quotes: %+v", all)
+	}
+	// Pending orders are placed on the proper side of the market
+	p := Proposal{Type: BuyStop, Price: NewPrice(123500, 5)}
+	if err := p.CheckMarket(q); err != nil {
+		t.Errorf("CheckMarket error: %v", err)
+	}
+	p.Type = SellStop
+	if err := p.CheckMarket(q); err == nil {
+		t.Errorf("CheckMarket expected an error")
+	}
+}
+
+func TestClientCommands(t *testing.T) {
+	c := NewClient(nil, "")
+	if err := c.HandleCommand([]byte(`{"type": 11, "data": {"subscribe": ["EURUSD", "GBPUSD"]}}`)); err != nil {
+		t.Errorf("HandleCommand error: %v", err)
+	}
+	if err := c.HandleCommand([]byte(`{"type": 11, "data": {"unsubscribe": ["GBPUSD"]}}`)); err != nil {
+		t.Errorf("HandleCommand error: %v", err)
+	}
+	if !c.Subscribed("EURUSD") || c.Subscribed("GBPUSD") {
+		t.Errorf("Unexpected subscriptions")
+	}
+	if err := c.HandleCommand([]byte(`{"type": 3}`)); err != ErrWrongCommand {
+		t.Errorf("HandleCommand expected ErrWrongCommand, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+// followers.go lets users follow proposals by their positions
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	// ErrNotJoined is returned when the user hasn't joined the proposal.
+	ErrNotJoined = errors.New("user hasn't joined the proposal")
+	// ErrLeaveClosed is returned when the position cannot be left because the order is filled.
+	ErrLeaveClosed = errors.New("position cannot be left after the order is filled")
+)
+
+// ListFollowers returns the positions of all users who have joined the proposal.
+func ListFollowers(dbh db.DBHandler, propID string) (list []Position, err error) {
+	list = []Position{}
+	var jerr error
+	prefix := idBytes(propID)
+	err = dbh.Scan(db.POSITIONS, prefix, false, func(key, val []byte) bool {
+		if len(key) < len(prefix) || string(key[:len(prefix)]) != string(prefix) {
+			return false
+		}
+		pos := Position{}
+		if jerr = json.Unmarshal(val, &pos); jerr != nil {
+			return false
+		}
+		list = append(list, pos)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
+
+// LeaveProposal removes the position of the user from the proposal which isn't filled yet
+// and releases its margin.
+func (e *TCPWSEngine) LeaveProposal(userID, propID string) error {
+	pid, err := uuid.FromString(propID)
+	if err != nil {
+		return err
+	}
+	e.accountsmu.Lock()
+	defer e.accountsmu.Unlock()
+	key := positionKey(propID, userID)
+	pos := Position{}
+	if err = readJSON(e.db, db.POSITIONS, key, &pos); db.IsNotFound(err) {
+		return ErrNotJoined
+	} else if err != nil {
+		return err
+	}
+	// The order could be filled before its positions are settled
+	p, err := e.readProposal(pid)
+	if err != nil {
+		return err
+	}
+	if p.State != StateProposal && p.State != StatePending {
+		return ErrLeaveClosed
+	}
+	if err = e.db.Delete(db.POSITIONS, key); err != nil {
+		return err
+	}
+	err = e.modifyAccount(userID, func(acc *Account) error {
+		acc.Margin = acc.Margin.Sub(pos.Margin)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.db.Modify(db.PROPOSALS, pid.Bytes(), &jsonModifier{&p, func() error {
+		for i, id := range p.Involved {
+			if id == userID {
+				p.Involved = append(p.Involved[:i], p.Involved[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}})
+}
+
+// notifyFollowers notifies the users about their changed positions.
+func (e *TCPWSEngine) notifyFollowers(positions []Position) error {
+	for _, pos := range positions {
+		err := e.notify(Notification{UserID: pos.UserID, Kind: NotifyPosition, ProposalID: pos.ProposalID,
+			Symbol: pos.Symbol, Text: "Your position on " + pos.Symbol + " is " + stateName(pos.State) + " now"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
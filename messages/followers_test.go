@@ -0,0 +1,70 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestFollowers(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	p := Proposal{
+		ID: uuid.NewV4().String(), Symbol: DefaultSymbol, Type: SellLimit, State: StatePending,
+		Price: NewPrice(123500, 5), StopLoss: NewPrice(124000, 5), TakeProfit: NewPrice(123000, 5),
+		Created: 1000, PendingExp: MaxPendingExp, PositionExp: MaxPositionExp,
+		History: []Event{{1000, NewPrice(123500, 5), StatePending}},
+	}
+	if err := writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	stay, leave := uuid.NewV4().String(), uuid.NewV4().String()
+	for _, user := range []string{stay, leave} {
+		if _, err := e.JoinProposal(user, p.ID, NewPrice(50, 2)); err != nil {
+			t.Fatalf("JoinProposal error: %v", err)
+		}
+	}
+	if err := e.LeaveProposal(leave, p.ID); err != nil {
+		t.Fatalf("LeaveProposal error: %v", err)
+	}
+	if err := e.LeaveProposal(leave, p.ID); err != ErrNotJoined {
+		t.Errorf("LeaveProposal expected ErrNotJoined, got %v", err)
+	}
+	if st, _ := e.Statement(leave, true); st.Margin.Sign() != 0 || len(st.Positions) != 0 {
+		t.Errorf("Unexpected statement after leave: %+v", st)
+	}
+	// The position of the follower is opened with the proposal
+	if err := e.PublishQuote(Quote{DefaultSymbol, NewPrice(123510, 5), NewPrice(123530, 5), 1100000}); err != nil {
+		t.Fatalf("PublishQuote error: %v", err)
+	}
+	if err := e.LeaveProposal(stay, p.ID); err != ErrLeaveClosed {
+		t.Errorf("LeaveProposal expected ErrLeaveClosed, got %v", err)
+	}
+	list, err := ListFollowers(dbh, p.ID)
+	if err != nil {
+		t.Fatalf("ListFollowers error: %v", err)
+	}
+	if len(list) != 1 || list[0].UserID != stay || list[0].State != StatePosition || list[0].EntryPrice.String() != "1.23510" {
+		t.Errorf("Unexpected followers: %+v", list)
+	}
+	// The follower is notified about the opened position even without a connection
+	inbox, err := ListNotifications(dbh, stay, "", false, 0)
+	if err != nil || len(inbox.Data) != 1 || inbox.Data[0].Kind != NotifyPosition || inbox.Data[0].ProposalID != p.ID || inbox.Data[0].Delivered {
+		t.Errorf("Unexpected notifications: %+v, %v", inbox.Data, err)
+	}
+	if _, err = ValuePositions(list, e.Quotes()); err != nil || list[0].Profit.String() != "-10.00" {
+		t.Errorf("Unexpected unrealized profit: %s, %v", list[0].Profit, err)
+	}
+	if err = readJSON(dbh, db.PROPOSALS, idBytes(p.ID), &p); err != nil || len(p.Involved) != 1 || p.Involved[0] != stay {
+		t.Errorf("Unexpected involved users: %v, %v", p.Involved, err)
+	}
+}
@@ -0,0 +1,324 @@
+// forum.go introduces the forum with categories, threads and nested replies
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	// ErrThreadLocked is returned when the user replies to the locked thread.
+	ErrThreadLocked = errors.New("thread is locked")
+	// ErrNotAuthor is returned when the user edits the post of another user.
+	ErrNotAuthor = errors.New("only the author can edit the post")
+	// ErrWrongParent is returned when the reply refers to the post of another thread.
+	ErrWrongParent = errors.New("parent post belongs to another thread")
+	// categoryRegexp matches category IDs.
+	categoryRegexp = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+)
+
+// Indexes of the forum
+var (
+	// ThreadByCategory indexes threads by the category, the pinned flag and the last activity.
+	ThreadByCategory *db.Index
+	// PostByThread indexes posts by the thread and the creation time.
+	PostByThread *db.Index
+)
+
+func init() {
+	ThreadByCategory = db.DeclareIndex("threads.category", db.THREADS, func(key, val []byte) [][]byte {
+		t := Thread{}
+		if json.Unmarshal(val, &t) != nil {
+			return nil
+		}
+		var pinned byte
+		if t.Pinned {
+			pinned = 1
+		}
+		return [][]byte{join([]byte(t.CategoryID), []byte{0, pinned}, encInt64(t.LastPost))}
+	})
+	PostByThread = db.DeclareIndex("posts.thread", db.POSTS, func(key, val []byte) [][]byte {
+		p := Post{}
+		if json.Unmarshal(val, &p) != nil {
+			return nil
+		}
+		return [][]byte{join(idBytes(p.ThreadID), encInt64(p.Created))}
+	})
+}
+
+// Category is a forum category which is stored in CATEGORIES database.
+type Category struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Thread is a forum thread which is stored in THREADS database.
+// LastPost is the time of the last post in the thread.
+type Thread struct {
+	ID         string `json:"id"`
+	CategoryID string `json:"category"`
+	AuthorID   string `json:"authorid"`
+	Title      string `json:"title"`
+	Created    int64  `json:"created"`
+	LastPost   int64  `json:"lastpost"`
+	Posts      int    `json:"posts"`
+	Pinned     bool   `json:"pinned"`
+	Locked     bool   `json:"locked"`
+}
+
+// Revision is a previous version of the edited post.
+type Revision struct {
+	Text string `json:"text"`
+	Time int64  `json:"time"`
+}
+
+// Post is a forum post which is stored in POSTS database.
+// ParentID refers to the post which is replied. It is empty for the replies to the thread.
+type Post struct {
+	ID        string     `json:"id"`
+	ThreadID  string     `json:"thread"`
+	ParentID  string     `json:"parent,omitempty"`
+	AuthorID  string     `json:"authorid"`
+	Text      string     `json:"text"`
+	Created   int64      `json:"created"`
+	Edited    int64      `json:"edited,omitempty"`
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+// ThreadPage is a single page of forum threads.
+type ThreadPage struct {
+	Data []Thread `json:"data"`
+	Next string   `json:"next,omitempty"`
+}
+
+// PostPage is a single page of forum posts.
+type PostPage struct {
+	Data []Post `json:"data"`
+	Next string `json:"next,omitempty"`
+}
+
+// readJSON reads the record at address key and unmarshals it into v.
+func readJSON(dbh db.DBHandler, name string, key []byte, v interface{}) error {
+	data, err := dbh.Read(name, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON marshals v and writes it at address key.
+func writeJSON(dbh db.DBHandler, name string, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return dbh.Write(name, key, data)
+}
+
+// jsonModifier is a Modifier which changes the unmarshalled record by means of the function.
+type jsonModifier struct {
+	// Value is the pointer to the record object
+	Value  interface{}
+	Change func() error
+}
+
+// Apply unmarshals the record into Value, changes it and marshals it back.
+func (m *jsonModifier) Apply(data []byte) ([]byte, error) {
+	if err := json.Unmarshal(data, m.Value); err != nil {
+		return nil, err
+	}
+	if err := m.Change(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m.Value)
+}
+
+// AddCategory stores the forum category.
+func AddCategory(dbh db.DBHandler, c Category) error {
+	fe := FieldErrors{}
+	if !categoryRegexp.MatchString(c.ID) {
+		fe["id"] = "must consist of 1-32 lowercase letters, digits or dashes"
+	}
+	if c.Title == "" {
+		fe["title"] = "must not be empty"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return writeJSON(dbh, db.CATEGORIES, []byte(c.ID), c)
+}
+
+// ListCategories returns all forum categories in the order of their IDs.
+func ListCategories(dbh db.DBHandler) (cats []Category, err error) {
+	cats = []Category{}
+	var jerr error
+	err = dbh.Scan(db.CATEGORIES, nil, false, func(key, val []byte) bool {
+		c := Category{}
+		if jerr = json.Unmarshal(val, &c); jerr != nil {
+			return false
+		}
+		cats = append(cats, c)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
+
+// CreateThread creates the thread t with the first post text at the moment now.
+// The thread and the post are written within the same transaction.
+func CreateThread(dbh db.DBHandler, t Thread, text string, now int64) (Thread, error) {
+	fe := FieldErrors{}
+	if t.Title == "" {
+		fe["title"] = "must not be empty"
+	}
+	if text == "" {
+		fe["text"] = "must not be empty"
+	}
+	if len(fe) > 0 {
+		return t, fe
+	}
+	if err := readJSON(dbh, db.CATEGORIES, []byte(t.CategoryID), &Category{}); err != nil {
+		return t, errors.Wrap(err, "cannot read the category")
+	}
+	t.ID = uuid.NewV4().String()
+	t.Created, t.LastPost, t.Posts = now, now, 1
+	t.Pinned, t.Locked = false, false
+	p := Post{ID: uuid.NewV4().String(), ThreadID: t.ID, AuthorID: t.AuthorID, Text: text, Created: now}
+	tdata, err := json.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	pdata, err := json.Marshal(p)
+	if err != nil {
+		return t, err
+	}
+	return t, dbh.ModifyAll(db.Change{DB: db.THREADS, Key: idBytes(t.ID), Modifier: db.Put{Data: tdata}},
+		db.Change{DB: db.POSTS, Key: idBytes(p.ID), Modifier: db.Put{Data: pdata}})
+}
+
+// Reply adds the post p to its thread at the moment now.
+// The post can reply to another post of the same thread given by ParentID.
+func Reply(dbh db.DBHandler, p Post, now int64) (Post, error) {
+	if p.Text == "" {
+		return p, FieldErrors{"text": "must not be empty"}
+	}
+	if p.ParentID != "" {
+		parent := Post{}
+		if err := readJSON(dbh, db.POSTS, idBytes(p.ParentID), &parent); err != nil {
+			return p, errors.Wrap(err, "cannot read the parent post")
+		}
+		if parent.ThreadID != p.ThreadID {
+			return p, ErrWrongParent
+		}
+	}
+	p.ID = uuid.NewV4().String()
+	p.Created, p.Edited, p.Revisions = now, 0, nil
+	// Update the thread and check its lock within the same write
+	t := Thread{}
+	err := dbh.Modify(db.THREADS, idBytes(p.ThreadID), &jsonModifier{&t, func() error {
+		if t.Locked {
+			return ErrThreadLocked
+		}
+		t.Posts++
+		t.LastPost = now
+		return nil
+	}})
+	if err != nil {
+		return p, err
+	}
+	return p, writeJSON(dbh, db.POSTS, idBytes(p.ID), p)
+}
+
+// EditPost replaces the text of the post by the author at the moment now.
+// The previous text is kept in the revision history.
+func EditPost(dbh db.DBHandler, postID, authorID, text string, now int64) (Post, error) {
+	if text == "" {
+		return Post{}, FieldErrors{"text": "must not be empty"}
+	}
+	p := Post{}
+	if err := readJSON(dbh, db.POSTS, idBytes(postID), &p); err != nil {
+		return p, err
+	}
+	// Check the lock of the thread within the same write
+	t := Thread{}
+	lock := &jsonModifier{&t, func() error {
+		if t.Locked {
+			return ErrThreadLocked
+		}
+		return nil
+	}}
+	edit := &jsonModifier{&p, func() error {
+		if p.AuthorID != authorID {
+			return ErrNotAuthor
+		}
+		p.Revisions = append(p.Revisions, Revision{p.Text, p.Created})
+		if p.Edited != 0 {
+			p.Revisions[len(p.Revisions)-1].Time = p.Edited
+		}
+		p.Text = text
+		p.Edited = now
+		return nil
+	}}
+	err := dbh.ModifyAll(db.Change{DB: db.THREADS, Key: idBytes(p.ThreadID), Modifier: lock},
+		db.Change{DB: db.POSTS, Key: idBytes(postID), Modifier: edit})
+	return p, err
+}
+
+// SetThreadFlags pins/unpins and locks/unlocks the thread.
+func SetThreadFlags(dbh db.DBHandler, threadID string, pinned, locked bool) (Thread, error) {
+	t := Thread{}
+	err := dbh.Modify(db.THREADS, idBytes(threadID), &jsonModifier{&t, func() error {
+		t.Pinned, t.Locked = pinned, locked
+		return nil
+	}})
+	return t, err
+}
+
+// ListThreads returns the page of threads of the category.
+// Pinned threads go first, other threads are sorted by the last activity.
+func ListThreads(dbh db.DBHandler, category, cursor string, limit int) (page ThreadPage, err error) {
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+	prefix := join([]byte(category), []byte{0})
+	page.Data = []Thread{}
+	page.Next, err = pageRange(dbh, ThreadByCategory, prefix, prefix, cursor, true, limit, func(pkey []byte) (bool, error) {
+		t := Thread{}
+		if err := readJSON(dbh, db.THREADS, pkey, &t); err != nil {
+			return false, err
+		}
+		page.Data = append(page.Data, t)
+		return true, nil
+	})
+	return
+}
+
+// ListPosts returns the page of posts of the thread in chronological order.
+// Clients build the reply tree by means of ParentID.
+func ListPosts(dbh db.DBHandler, threadID, cursor string, limit int) (page PostPage, err error) {
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+	prefix := idBytes(threadID)
+	page.Data = []Post{}
+	page.Next, err = pageRange(dbh, PostByThread, prefix, prefix, cursor, false, limit, func(pkey []byte) (bool, error) {
+		p := Post{}
+		if err := readJSON(dbh, db.POSTS, pkey, &p); err != nil {
+			return false, err
+		}
+		page.Data = append(page.Data, p)
+		return true, nil
+	})
+	return
+}
@@ -0,0 +1,93 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"union/db"
+)
+
+// testDB opens the lmdb database in the temporary directory.
+// The returned function closes the database and removes the directory.
+func testDB(t *testing.T) (*db.LMDB, func()) {
+	dir, err := ioutil.TempDir("", "union")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir error: %v", err)
+	}
+	lmdb, err := db.MakeLMDBHandler(dir)
+	if err != nil {
+		t.Fatalf("MakeLMDBHandler error: %v", err)
+	}
+	return lmdb, func() {
+		lmdb.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestForum(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := AddCategory(dbh, Category{ID: "strategies", Title: "Strategies"}); err != nil {
+		t.Fatalf("AddCategory error: %v", err)
+	}
+	// Create threads and pin the oldest one
+	var threads []Thread
+	for i, title := range []string{"first", "second", "third"} {
+		th, err := CreateThread(dbh, Thread{CategoryID: "strategies", AuthorID: "a", Title: title}, "text", int64(10*i))
+		if err != nil {
+			t.Fatalf("CreateThread error: %v", err)
+		}
+		threads = append(threads, th)
+	}
+	if _, err := SetThreadFlags(dbh, threads[0].ID, true, false); err != nil {
+		t.Errorf("SetThreadFlags error: %v", err)
+	}
+	page, err := ListThreads(dbh, "strategies", "", 2)
+	if err != nil || len(page.Data) != 2 || page.Data[0].Title != "first" || page.Data[1].Title != "third" {
+		t.Errorf("Unexpected first page of threads: %+v, %v", page, err)
+	}
+	page, err = ListThreads(dbh, "strategies", page.Next, 2)
+	if err != nil || len(page.Data) != 1 || page.Data[0].Title != "second" || page.Next != "" {
+		t.Errorf("Unexpected second page of threads: %+v, %v", page, err)
+	}
+	// Nested replies
+	reply, err := Reply(dbh, Post{ThreadID: threads[1].ID, AuthorID: "b", Text: "reply"}, 40)
+	if err != nil {
+		t.Errorf("Reply error: %v", err)
+	}
+	if _, err = Reply(dbh, Post{ThreadID: threads[2].ID, ParentID: reply.ID, AuthorID: "b", Text: "x"}, 41); err != ErrWrongParent {
+		t.Errorf("Reply expected ErrWrongParent, got %v", err)
+	}
+	if _, err = Reply(dbh, Post{ThreadID: threads[1].ID, ParentID: reply.ID, AuthorID: "c", Text: "x"}, 42); err != nil {
+		t.Errorf("Reply error: %v", err)
+	}
+	// Edits keep revisions
+	if _, err = EditPost(dbh, reply.ID, "c", "edited", 50); err != ErrNotAuthor {
+		t.Errorf("EditPost expected ErrNotAuthor, got %v", err)
+	}
+	edited, err := EditPost(dbh, reply.ID, "b", "edited", 50)
+	if err != nil || edited.Text != "edited" || len(edited.Revisions) != 1 || edited.Revisions[0].Text != "reply" {
+		t.Errorf("Unexpected edited post: %+v, %v", edited, err)
+	}
+	// Locked threads don't accept replies
+	if _, err = SetThreadFlags(dbh, threads[1].ID, false, true); err != nil {
+		t.Errorf("SetThreadFlags error: %v", err)
+	}
+	if _, err = Reply(dbh, Post{ThreadID: threads[1].ID, AuthorID: "b", Text: "x"}, 60); err != ErrThreadLocked {
+		t.Errorf("Reply expected ErrThreadLocked, got %v", err)
+	}
+	if _, err = EditPost(dbh, reply.ID, "b", "again", 61); err != ErrThreadLocked {
+		t.Errorf("EditPost expected ErrThreadLocked, got %v", err)
+	}
+	if err = readJSON(dbh, db.POSTS, idBytes(reply.ID), &edited); err != nil || edited.Text != "edited" {
+		t.Errorf("Unexpected post in the locked thread: %+v, %v", edited, err)
+	}
+	posts, err := ListPosts(dbh, threads[1].ID, "", 10)
+	if err != nil || len(posts.Data) != 3 || posts.Data[2].ParentID != reply.ID {
+		t.Errorf("Unexpected posts: %+v, %v", posts, err)
+	}
+}
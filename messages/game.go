@@ -7,15 +7,49 @@
 package messages
 
 import (
+	"encoding/json"
 	"github.com/satori/go.uuid"
 	"math/rand"
 )
 
+// Proposal types
+const (
+	// BuyStop buys when the price rises up to the proposal price.
+	BuyStop byte = iota
+	// BuyLimit buys when the price falls down to the proposal price.
+	BuyLimit
+	// SellStop sells when the price falls down to the proposal price.
+	SellStop
+	// SellLimit sells when the price rises up to the proposal price.
+	SellLimit
+)
+
+// Proposal states
+const (
+	// StateProposal is a proposal which collects votes.
+	StateProposal byte = iota
+	// StatePending is a pending order waiting for the price.
+	StatePending
+	// StatePosition is an open position.
+	StatePosition
+	// StateExpiredProposal is a proposal which hasn't reached its goal score before the deadline.
+	StateExpiredProposal
+	// StateExpiredPending is a pending order which hasn't been triggered in time.
+	StateExpiredPending
+	// StateExpiredPosition is a position closed by the position expiration.
+	StateExpiredPosition
+	// StateStopLoss is a position closed by the stop loss.
+	StateStopLoss
+	// StateTakeProfit is a position closed by the take profit.
+	StateTakeProfit
+)
+
+// Event describes the change of the proposal state.
 type Event struct {
 	// Time when the event occured
 	Time int64 `json:"time"`
 	// Value when the event occured
-	Value float32 `json:"value"`
+	Value Price `json:"value"`
 	// State after event
 	State byte `json:"state"`
 }
@@ -23,7 +57,7 @@ type Event struct {
 // FillRandom fills event with random numbers
 func (e *Event) FillRandom() {
 	e.Time = rand.Int63()
-	e.Value = rand.Float32()
+	e.Value = NewPrice(rand.Int63n(200000)+1, DefaultPrecision.Digits)
 	e.State = byte(rand.Int() % 256)
 }
 
@@ -32,6 +66,49 @@ func (e *Event) FillRandom() {
 // wrong updates.
 type Engine interface {
 	AddProposal(p Proposal) (uuid.UUID, error)
-	VoteProposal(propID, userID uuid.UUID) (error)
-	UpgradeProposal(uuid.UUID, Event)
-}
\ No newline at end of file
+	VoteProposal(propID, userID uuid.UUID) error
+	UpgradeProposal(uuid.UUID, Event) error
+	AddClient(*Client)
+	RemoveClient(*Client)
+	JoinRoom(room, userID string) (Room, error)
+	LeaveRoom(room, userID string) error
+	PostChat(room string, m ChatMessage) error
+	SendDirect(to string, m ChatMessage) (Conversation, error)
+	MarkRead(convID, userID string) error
+	ModerateMessage(modID, msgID, action, reason string) error
+	Sanction(s Sanction) error
+	SetRole(adminID, userID, role string) error
+	EditChat(userID, msgID, text string) (ChatMessage, error)
+	React(userID, msgID, emoji string, add bool) (ChatMessage, error)
+	Quotes() *QuoteCache
+	PublishQuote(q Quote) error
+	Candles(symbol, tf string, from, to int64, limit int) ([]Candle, error)
+	JoinProposal(userID, propID string, lots Price) (Position, error)
+	Statement(userID string, all bool) (Statement, error)
+	LeaveProposal(userID, propID string) error
+	SetRiskRules(rules RiskRules)
+	RiskRules() RiskRules
+	Exposure() (ExposureReport, error)
+	RefreshLeaderboards() error
+	AddAlert(userID string, a AlertRule) (AlertRule, error)
+	DeliverNotifications(c *Client) error
+}
+
+// GameEngine is a global game engine of the server.
+var GameEngine Engine
+
+// propUpgrade is a Modifier which applies the event to the proposal.
+type propUpgrade struct {
+	Event  Event
+	Result Proposal
+}
+
+// Apply appends the event to the history of the marshalled proposal and changes its state.
+func (u *propUpgrade) Apply(data []byte) ([]byte, error) {
+	if err := json.Unmarshal(data, &u.Result); err != nil {
+		return nil, err
+	}
+	u.Result.State = u.Event.State
+	u.Result.History = append(u.Result.History, u.Event)
+	return json.Marshal(u.Result)
+}
@@ -0,0 +1,184 @@
+// instruments.go introduces the catalogue of traded instruments
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"time"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSymbol is the instrument of proposals created before the catalogue was introduced.
+const DefaultSymbol = "EURUSD"
+
+// ErrMarketClosed is returned when proposals of the instrument are created or joined out of its trading hours.
+var ErrMarketClosed = errors.New("market of the instrument is closed")
+
+// DefaultInstrument describes DefaultSymbol. It is stored at the first start.
+var DefaultInstrument = Instrument{
+	Symbol:       DefaultSymbol,
+	Name:         "Euro / US Dollar",
+	TickSize:     NewPrice(1, 5),
+	PipSize:      NewPrice(1, 4),
+	Spread:       NewPrice(2, 4),
+	ContractSize: 100000,
+}
+
+// Session is a trading session of the instrument within a week day.
+// Day is the week day, 0 is Sunday. Open and Close are minutes since midnight UTC.
+type Session struct {
+	Day   time.Weekday `json:"day"`
+	Open  int          `json:"open"`
+	Close int          `json:"close"`
+}
+
+// Instrument is a traded instrument which is stored in INSTRUMENTS database.
+// Prices of the instrument are multiples of TickSize, PipSize is 1 or a negative power of 10
+// which defines the pip arithmetic.
+// Spread is the typical spread, ContractSize is the number of units in one lot.
+// The instrument is traded all the time if Hours is empty.
+type Instrument struct {
+	Symbol       string    `json:"symbol"`
+	Name         string    `json:"name"`
+	TickSize     Price     `json:"ticksize"`
+	PipSize      Price     `json:"pipsize"`
+	Spread       Price     `json:"spread"`
+	ContractSize int64     `json:"contractsize"`
+	Hours        []Session `json:"hours,omitempty"`
+}
+
+// Precision returns the precision of the instrument prices.
+// Trailing zeros of the sizes don't count, so the pip size "0.00010" gives 4 pip digits.
+func (inst *Instrument) Precision() Precision {
+	return Precision{Digits: inst.TickSize.trim().Scale, PipDigits: inst.PipSize.trim().Scale}
+}
+
+// OnTick checks whether the price is a multiple of the tick size.
+func (inst *Instrument) OnTick(p Price) bool {
+	p, tick := align(p, inst.TickSize)
+	return tick.Units > 0 && p.Units%tick.Units == 0
+}
+
+// IsOpen checks whether the instrument is traded at the moment t.
+func (inst *Instrument) IsOpen(t time.Time) bool {
+	if len(inst.Hours) == 0 {
+		return true
+	}
+	t = t.UTC()
+	minute := t.Hour()*60 + t.Minute()
+	for _, s := range inst.Hours {
+		if s.Day == t.Weekday() && minute >= s.Open && minute < s.Close {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the instrument and returns FieldErrors if some fields are wrong.
+func (inst *Instrument) Validate() error {
+	fe := FieldErrors{}
+	if !symbolRegexp.MatchString(inst.Symbol) {
+		fe["symbol"] = "must consist of 1-12 capital letters or digits"
+	}
+	if inst.TickSize.Sign() <= 0 {
+		fe["ticksize"] = "must be positive"
+	}
+	if inst.PipSize.Sign() <= 0 {
+		fe["pipsize"] = "must be positive"
+	} else if inst.PipSize.trim().Units != 1 {
+		fe["pipsize"] = "must be 1 or a negative power of 10"
+	} else if inst.TickSize.Cmp(inst.PipSize) > 0 {
+		fe["pipsize"] = "must not be less than the tick size"
+	}
+	if inst.Spread.Sign() < 0 {
+		fe["spread"] = "must not be negative"
+	}
+	if inst.ContractSize <= 0 {
+		fe["contractsize"] = "must be positive"
+	}
+	for _, s := range inst.Hours {
+		if s.Day < time.Sunday || s.Day > time.Saturday || s.Open < 0 || s.Close > 24*60 || s.Open >= s.Close {
+			fe["hours"] = "sessions must be within a week day and open before they close"
+		}
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+// SaveInstrument creates or updates the instrument.
+func SaveInstrument(dbh db.DBHandler, inst Instrument) error {
+	if err := inst.Validate(); err != nil {
+		return err
+	}
+	return writeJSON(dbh, db.INSTRUMENTS, []byte(inst.Symbol), inst)
+}
+
+// ReadInstrument reads the instrument with the given symbol.
+func ReadInstrument(dbh db.DBHandler, symbol string) (inst Instrument, err error) {
+	err = readJSON(dbh, db.INSTRUMENTS, []byte(symbol), &inst)
+	return
+}
+
+// LookupInstrument returns the instrument with the given symbol or nil if it doesn't exist.
+func LookupInstrument(dbh db.DBHandler, symbol string) (*Instrument, error) {
+	inst, err := ReadInstrument(dbh, symbol)
+	if db.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &inst, nil
+}
+
+// ListInstruments returns all instruments in the order of their symbols.
+func ListInstruments(dbh db.DBHandler) (list []Instrument, err error) {
+	list = []Instrument{}
+	var jerr error
+	err = dbh.Scan(db.INSTRUMENTS, nil, false, func(key, val []byte) bool {
+		inst := Instrument{}
+		if jerr = json.Unmarshal(val, &inst); jerr != nil {
+			return false
+		}
+		list = append(list, inst)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
+
+// DeleteInstrument removes the instrument from the catalogue.
+// Existing proposals keep the symbol, new proposals cannot use it.
+func DeleteInstrument(dbh db.DBHandler, symbol string) error {
+	return dbh.Delete(db.INSTRUMENTS, []byte(symbol))
+}
+
+// InitInstruments stores DefaultInstrument if it doesn't exist and assigns DefaultSymbol
+// to the proposals created before the catalogue was introduced.
+// It returns the number of migrated proposals.
+func InitInstruments(dbh db.DBHandler) (int, error) {
+	if _, err := ReadInstrument(dbh, DefaultSymbol); db.IsNotFound(err) {
+		if err = SaveInstrument(dbh, DefaultInstrument); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	legacy := func(val []byte) bool {
+		v := struct {
+			Symbol string `json:"symbol"`
+		}{}
+		return json.Unmarshal(val, &v) == nil && v.Symbol == ""
+	}
+	return migrateProposals(dbh, legacy, func(p *Proposal) {
+		p.Symbol = DefaultSymbol
+	})
+}
@@ -0,0 +1,94 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+	"time"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+func TestInstruments(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := dbh.Write(db.PROPOSALS, []byte("a"), []byte(`{"id":"a","price":"1.2"}`)); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if n, err := InitInstruments(dbh); err != nil || n != 1 {
+		t.Errorf("InitInstruments = %d, %v", n, err)
+	}
+	p := Proposal{}
+	if err := readJSON(dbh, db.PROPOSALS, []byte("a"), &p); err != nil || p.Symbol != DefaultSymbol {
+		t.Errorf("Unexpected migrated proposal: %+v, %v", p, err)
+	}
+	// Futures with quarter ticks traded on Monday from 13:30 till 20:00
+	es := Instrument{Symbol: "ES", TickSize: NewPrice(25, 2), PipSize: NewPrice(1, 0), ContractSize: 50,
+		Hours: []Session{{time.Monday, 810, 1200}}}
+	if err := SaveInstrument(dbh, es); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	if err := SaveInstrument(dbh, Instrument{Symbol: "bad"}); err == nil {
+		t.Errorf("SaveInstrument expected an error")
+	}
+	// Pip sizes are powers of 10, trailing zeros of the sizes don't count
+	quarter := es
+	quarter.PipSize = NewPrice(25, 2)
+	if fe, _ := quarter.Validate().(FieldErrors); len(fe) != 1 || fe["pipsize"] == "" {
+		t.Errorf("Unexpected field errors: %v", fe)
+	}
+	fx := Instrument{Symbol: "GBPUSD", TickSize: NewPrice(100, 7), PipSize: NewPrice(10, 5), ContractSize: 1}
+	if err := fx.Validate(); err != nil {
+		t.Errorf("Validate error: %v", err)
+	}
+	pr := fx.Precision()
+	if pr.Digits != 5 || pr.PipDigits != 4 || pr.Pips(NewPrice(12345, 4), NewPrice(12355, 4)).String() != "10" {
+		t.Errorf("Unexpected precision: %+v", pr)
+	}
+	list, err := ListInstruments(dbh)
+	if err != nil || len(list) != 2 || list[0].Symbol != "ES" || list[1].Symbol != DefaultSymbol {
+		t.Errorf("Unexpected instruments: %+v, %v", list, err)
+	}
+	if !es.OnTick(NewPrice(425075, 2)) || es.OnTick(NewPrice(425010, 2)) {
+		t.Errorf("Unexpected tick check")
+	}
+	monday := time.Date(2017, 5, 1, 14, 0, 0, 0, time.UTC)
+	if !es.IsOpen(monday) || es.IsOpen(monday.Add(7*time.Hour)) {
+		t.Errorf("Unexpected trading hours check")
+	}
+	// Proposals are validated against the instrument
+	p = Proposal{Symbol: "ES", Type: BuyLimit, Price: NewPrice(4250, 0), StopLoss: NewPrice(424010, 2),
+		TakeProfit: NewPrice(4270, 0), GoalScore: 1, Deadline: 100, PendingExp: MinPendingExp, PositionExp: MinPositionExp}
+	fe, _ := p.Validate(0, &es).(FieldErrors)
+	if len(fe) != 1 || fe["stoploss"] == "" {
+		t.Errorf("Unexpected field errors: %v", fe)
+	}
+	if fe, _ = p.Validate(0, nil).(FieldErrors); fe["symbol"] == "" {
+		t.Errorf("Unexpected field errors: %v", fe)
+	}
+	// Proposals are created and joined within the trading hours
+	defer func(c Clock) {
+		GameClock = c
+	}(GameClock)
+	GameClock = NewReplayClock(monday.Add(7 * time.Hour))
+	e := MakeTCPWSEngine(dbh, nil)
+	p.AuthorID = uuid.NewV4().String()
+	if _, err = e.AddProposal(p); errors.Cause(err) != ErrMarketClosed {
+		t.Errorf("AddProposal expected ErrMarketClosed, got %v", err)
+	}
+	p.ID, p.State = uuid.NewV4().String(), StateProposal
+	if err = writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if _, err = e.JoinProposal(uuid.NewV4().String(), p.ID, NewPrice(1, 0)); errors.Cause(err) != ErrMarketClosed {
+		t.Errorf("JoinProposal expected ErrMarketClosed, got %v", err)
+	}
+	GameClock = NewReplayClock(monday)
+	if _, err = e.JoinProposal(uuid.NewV4().String(), p.ID, NewPrice(1, 0)); err != nil {
+		t.Errorf("JoinProposal error: %v", err)
+	}
+}
@@ -0,0 +1,459 @@
+// leaderboards.go ranks traders over rolling windows and trading seasons
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// Leaderboard metrics
+const (
+	MetricReputation = "reputation"
+	MetricPnL        = "pnl"
+	MetricWinRate    = "winrate"
+	MetricProposals  = "proposals"
+)
+
+// Metrics lists all leaderboard metrics.
+var Metrics = []string{MetricReputation, MetricPnL, MetricWinRate, MetricProposals}
+
+// Window is a rolling period of leaderboards which ends now. Its duration is given in seconds.
+// Zero duration covers all the time.
+type Window struct {
+	Name     string
+	Duration int64
+}
+
+// Windows lists all rolling periods of leaderboards.
+var Windows = []Window{
+	{"1d", 24 * 60 * 60},
+	{"7d", 7 * 24 * 60 * 60},
+	{"30d", 30 * 24 * 60 * 60},
+	{"all", 0},
+}
+
+// Leaderboard limits
+const (
+	// LeaderboardSize is the number of stored places of the leaderboard.
+	LeaderboardSize = 100
+	// MinWinRateTrades is the number of closed positions required to get the win rate place.
+	MinWinRateTrades = 5
+)
+
+// SeasonPrefix starts the leaderboard period of the season: "season:ID".
+const SeasonPrefix = "season:"
+
+var (
+	// ErrWrongMetric is returned for unknown leaderboard metrics.
+	ErrWrongMetric = errors.New("metric must be reputation, pnl, winrate or proposals")
+	// ErrWrongPeriod is returned for unknown leaderboard periods.
+	ErrWrongPeriod = errors.New("period must be 1d, 7d, 30d, all or season:ID of the known season")
+	// ErrSeasonExists is returned when the season with the same ID is added.
+	ErrSeasonExists = errors.New("season already exists")
+)
+
+var seasonRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Season is a fixed period of leaderboards stored in SEASONS database.
+// Start and End are UNIX times, End is not included. Leaderboards of the final season
+// are not recomputed anymore.
+type Season struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Final bool   `json:"final"`
+}
+
+// Period returns the leaderboard period of the season.
+func (s *Season) Period() string {
+	return SeasonPrefix + s.ID
+}
+
+// Validate checks the season and returns FieldErrors if some fields are wrong.
+func (s *Season) Validate() error {
+	fe := FieldErrors{}
+	if !seasonRegexp.MatchString(s.ID) {
+		fe["id"] = "must consist of 1-32 small letters, digits, dashes or underscores"
+	}
+	if s.Name = strings.TrimSpace(s.Name); s.Name == "" || len(s.Name) > 64 {
+		fe["name"] = "must have 1-64 characters"
+	}
+	if s.Start <= 0 {
+		fe["start"] = "must be positive"
+	}
+	if s.End <= s.Start {
+		fe["end"] = "must be after the start"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+// AddSeason validates and stores the new season.
+func AddSeason(dbh db.DBHandler, s Season) (Season, error) {
+	s.Final = false
+	if err := s.Validate(); err != nil {
+		return s, err
+	}
+	if _, err := dbh.Read(db.SEASONS, []byte(s.ID)); err == nil {
+		return s, ErrSeasonExists
+	} else if !db.IsNotFound(err) {
+		return s, err
+	}
+	return s, writeJSON(dbh, db.SEASONS, []byte(s.ID), s)
+}
+
+// ListSeasons returns all seasons from the latest one.
+func ListSeasons(dbh db.DBHandler) (list []Season, err error) {
+	list = []Season{}
+	var jerr error
+	err = dbh.Scan(db.SEASONS, nil, false, func(key, val []byte) bool {
+		s := Season{}
+		if jerr = json.Unmarshal(val, &s); jerr != nil {
+			return false
+		}
+		list = append(list, s)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start > list[j].Start })
+	return
+}
+
+// LeaderEntry is the place of the trader in the leaderboard. Value depends on the metric:
+// the reputation, the realized profit, the percent of profitable positions or the number of
+// successful proposals. Count is the number of closed positions or successful proposals behind the value.
+type LeaderEntry struct {
+	Rank   int    `json:"rank"`
+	UserID string `json:"userid"`
+	Name   string `json:"name"`
+	Value  Price  `json:"value"`
+	Count  int    `json:"count"`
+}
+
+// Leaderboard is the precomputed ranking of traders by the metric over the period
+// stored in LEADERBOARDS database. From and To bound the period, zero To means now.
+// It is sent to the clients subscribed to LeaderboardTopic as type 14 message when ranks change.
+type Leaderboard struct {
+	Metric  string        `json:"metric"`
+	Period  string        `json:"period"`
+	From    int64         `json:"from"`
+	To      int64         `json:"to,omitempty"`
+	Updated int64         `json:"updated"`
+	Entries []LeaderEntry `json:"entries"`
+}
+
+// LeaderboardTopic returns the subscription topic of the leaderboard.
+func LeaderboardTopic(metric, period string) string {
+	return "leaderboard:" + metric + ":" + period
+}
+
+// leaderboardKey returns the key of the leaderboard in LEADERBOARDS database: period + 0 + metric.
+func leaderboardKey(metric, period string) []byte {
+	return join([]byte(period), []byte{0}, []byte(metric))
+}
+
+// ranksChanged checks whether traders have moved in the leaderboard.
+func ranksChanged(old, cur []LeaderEntry) bool {
+	if len(old) != len(cur) {
+		return true
+	}
+	for i := range cur {
+		if old[i].UserID != cur[i].UserID || old[i].Rank != cur[i].Rank {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadLeaderboard returns the stored leaderboard. The leaderboard which isn't computed yet has no entries.
+func ReadLeaderboard(dbh db.DBHandler, metric, period string) (lb Leaderboard, err error) {
+	found := false
+	for _, m := range Metrics {
+		found = found || m == metric
+	}
+	if !found {
+		return lb, ErrWrongMetric
+	}
+	found = false
+	for _, w := range Windows {
+		found = found || w.Name == period
+	}
+	if !found && strings.HasPrefix(period, SeasonPrefix) {
+		if _, err = dbh.Read(db.SEASONS, []byte(strings.TrimPrefix(period, SeasonPrefix))); db.IsNotFound(err) {
+			return lb, ErrWrongPeriod
+		} else if err != nil {
+			return
+		}
+		found = true
+	}
+	if !found {
+		return lb, ErrWrongPeriod
+	}
+	err = readJSON(dbh, db.LEADERBOARDS, leaderboardKey(metric, period), &lb)
+	if db.IsNotFound(err) {
+		return Leaderboard{Metric: metric, Period: period, Entries: []LeaderEntry{}}, nil
+	}
+	return
+}
+
+// traderStats is the performance of the trader over the period.
+type traderStats struct {
+	pnl       Price
+	wins      int
+	trades    int
+	proposals int
+}
+
+// boardPeriod collects the performance of traders over the period.
+type boardPeriod struct {
+	name    string
+	from    int64
+	to      int64
+	season  *Season
+	traders map[string]*traderStats
+}
+
+// contains checks whether the moment t is within the period.
+func (bp *boardPeriod) contains(t int64) bool {
+	return t >= bp.from && (bp.to == 0 || t < bp.to)
+}
+
+// trader returns the stats of the trader creating them if needed.
+func (bp *boardPeriod) trader(userID string) *traderStats {
+	ts := bp.traders[userID]
+	if ts == nil {
+		ts = &traderStats{pnl: NewPrice(0, MoneyDigits)}
+		bp.traders[userID] = ts
+	}
+	return ts
+}
+
+// Successful checks whether the position of the proposal is closed with the profit.
+// pr is the precision of the proposal instrument.
+func (p *Proposal) Successful(pr Precision) bool {
+	if len(p.History) == 0 || p.State != StateStopLoss && p.State != StateTakeProfit && p.State != StateExpiredPosition {
+		return false
+	}
+	var entry *Price
+	for i := range p.History {
+		if p.History[i].State == StatePosition {
+			entry = &p.History[i].Value
+		}
+	}
+	return entry != nil && p.ProfitPips(pr, *entry, p.History[len(p.History)-1].Value).Sign() > 0
+}
+
+// collectStats adds closed positions and successful proposals to the periods they belong to.
+func collectStats(dbh db.DBHandler, periods []*boardPeriod) error {
+	var jerr error
+	err := dbh.Scan(db.POSITIONS, nil, false, func(key, val []byte) bool {
+		pos := Position{}
+		if jerr = json.Unmarshal(val, &pos); jerr != nil {
+			return false
+		}
+		if pos.Opened == 0 || !pos.Finished() {
+			return true
+		}
+		for _, bp := range periods {
+			if bp.contains(pos.Closed) {
+				ts := bp.trader(pos.UserID)
+				ts.trades++
+				ts.pnl = ts.pnl.Add(pos.Profit)
+				if pos.Profit.Sign() > 0 {
+					ts.wins++
+				}
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	if err != nil {
+		return err
+	}
+	precisions := map[string]Precision{}
+	err = dbh.Scan(db.PROPOSALS, nil, false, func(key, val []byte) bool {
+		p := Proposal{}
+		if jerr = json.Unmarshal(val, &p); jerr != nil {
+			return false
+		}
+		pr, ok := precisions[p.Symbol]
+		if !ok {
+			if pr, jerr = precisionOf(dbh, p.Symbol); jerr != nil {
+				return false
+			}
+			precisions[p.Symbol] = pr
+		}
+		if !p.Successful(pr) {
+			return true
+		}
+		closed := p.History[len(p.History)-1].Time
+		for _, bp := range periods {
+			if bp.contains(closed) {
+				bp.trader(p.AuthorID).proposals++
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return err
+}
+
+// readUsers returns all users by their IDs.
+func readUsers(dbh db.DBHandler) (users map[string]User, err error) {
+	users = map[string]User{}
+	var jerr error
+	err = dbh.Scan(db.USERS, nil, false, func(key, val []byte) bool {
+		u := User{}
+		if jerr = json.Unmarshal(val, &u); jerr != nil {
+			return false
+		}
+		users[u.ID] = u
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
+
+// rank sorts the entries by their values and counts from the biggest ones and numbers them.
+// Only LeaderboardSize entries are kept.
+func rank(entries []LeaderEntry) []LeaderEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if c := entries[i].Value.Cmp(entries[j].Value); c != 0 {
+			return c > 0
+		}
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	if len(entries) > LeaderboardSize {
+		entries = entries[:LeaderboardSize]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// boards makes the leaderboards of all metrics for the period. The reputation is not recorded
+// over time so the reputation leaderboard ranks the current reputation of traders
+// who have closed positions or successful proposals in the period. Every user gets
+// the place in the reputation leaderboard of all the time.
+func (bp *boardPeriod) boards(users map[string]User, updated int64) []Leaderboard {
+	entries := map[string][]LeaderEntry{}
+	for _, m := range Metrics {
+		entries[m] = []LeaderEntry{}
+	}
+	for id, ts := range bp.traders {
+		name := users[id].Name
+		entries[MetricReputation] = append(entries[MetricReputation], LeaderEntry{UserID: id, Name: name,
+			Value: NewPrice(int64(math.Round(users[id].Rate*100)), 2), Count: ts.trades})
+		if ts.trades > 0 {
+			entries[MetricPnL] = append(entries[MetricPnL], LeaderEntry{UserID: id, Name: name,
+				Value: ts.pnl, Count: ts.trades})
+		}
+		if ts.trades >= MinWinRateTrades {
+			entries[MetricWinRate] = append(entries[MetricWinRate], LeaderEntry{UserID: id, Name: name,
+				Value: NewPrice(int64(ts.wins)*10000/int64(ts.trades), 2), Count: ts.trades})
+		}
+		if ts.proposals > 0 {
+			entries[MetricProposals] = append(entries[MetricProposals], LeaderEntry{UserID: id, Name: name,
+				Value: NewPrice(int64(ts.proposals), 0), Count: ts.proposals})
+		}
+	}
+	if bp.from == 0 && bp.to == 0 {
+		for id, u := range users {
+			if bp.traders[id] == nil {
+				entries[MetricReputation] = append(entries[MetricReputation], LeaderEntry{UserID: id, Name: u.Name,
+					Value: NewPrice(int64(math.Round(u.Rate*100)), 2)})
+			}
+		}
+	}
+	list := make([]Leaderboard, 0, len(Metrics))
+	for _, m := range Metrics {
+		list = append(list, Leaderboard{Metric: m, Period: bp.name, From: bp.from, To: bp.to,
+			Updated: updated, Entries: rank(entries[m])})
+	}
+	return list
+}
+
+// RefreshLeaderboards recomputes the leaderboards of all rolling windows and seasons which
+// are not final yet. The leaderboards are stored and sent to their subscribers if ranks change.
+// Seasons which have ended become final.
+func (e *TCPWSEngine) RefreshLeaderboards() error {
+	e.leaderboardsmu.Lock()
+	defer e.leaderboardsmu.Unlock()
+	t := wallNow()
+	periods := []*boardPeriod{}
+	for _, w := range Windows {
+		bp := &boardPeriod{name: w.Name, traders: map[string]*traderStats{}}
+		if w.Duration != 0 {
+			bp.from = t - w.Duration
+		}
+		periods = append(periods, bp)
+	}
+	seasons, err := ListSeasons(e.db)
+	if err != nil {
+		return err
+	}
+	for i := range seasons {
+		s := &seasons[i]
+		if !s.Final && s.Start <= t {
+			periods = append(periods, &boardPeriod{name: s.Period(), from: s.Start, to: s.End, season: s,
+				traders: map[string]*traderStats{}})
+		}
+	}
+	if err = collectStats(e.db, periods); err != nil {
+		return err
+	}
+	users, err := readUsers(e.db)
+	if err != nil {
+		return err
+	}
+	for _, bp := range periods {
+		for _, lb := range bp.boards(users, t) {
+			key := leaderboardKey(lb.Metric, lb.Period)
+			old := Leaderboard{}
+			if err = readJSON(e.db, db.LEADERBOARDS, key, &old); err != nil && !db.IsNotFound(err) {
+				return err
+			}
+			if err = writeJSON(e.db, db.LEADERBOARDS, key, lb); err != nil {
+				return err
+			}
+			if ranksChanged(old.Entries, lb.Entries) {
+				topic := LeaderboardTopic(lb.Metric, lb.Period)
+				e.send(Message{MsgLeaderboard, lb}, func(c *Client) bool {
+					return c.Subscribed(topic)
+				})
+			}
+		}
+		if bp.season != nil && bp.season.End <= t {
+			bp.season.Final = true
+			if err = writeJSON(e.db, db.SEASONS, []byte(bp.season.ID), bp.season); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
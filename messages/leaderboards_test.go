@@ -0,0 +1,116 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestLeaderboards(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	now := GameClock.Now().Unix()
+	alice, bob := uuid.NewV4(), uuid.NewV4()
+	for _, u := range []User{{ID: alice.String(), Name: "alice", Rate: 1.5}, {ID: bob.String(), Name: "bob", Rate: 2}} {
+		id, _ := uuid.FromString(u.ID)
+		if err := writeJSON(dbh, db.USERS, id.Bytes(), u); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+	}
+	// Alice wins 5 of 6 trades today, Bob has the only big profit last week
+	trade := func(user string, profit int64, closed int64) {
+		pos := Position{ProposalID: uuid.NewV4().String(), UserID: user, Symbol: DefaultSymbol,
+			State: StateTakeProfit, Profit: NewPrice(profit, 2), Opened: closed - 100, Closed: closed}
+		if err := writeJSON(dbh, db.POSITIONS, positionKey(pos.ProposalID, user), pos); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+	}
+	for i := int64(0); i < 5; i++ {
+		trade(alice.String(), 1000, now-i*60)
+	}
+	trade(alice.String(), -500, now-3600)
+	trade(bob.String(), 100000, now-3*24*3600)
+	p := Proposal{ID: uuid.NewV4().String(), AuthorID: bob.String(), Type: SellStop, State: StateTakeProfit,
+		History: []Event{{now - 200, NewPrice(123500, 5), StatePosition}, {now - 100, NewPrice(123000, 5), StateTakeProfit}}}
+	if err := writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	if _, err := AddSeason(dbh, Season{ID: "past", Name: "Past", Start: now - 7*24*3600, End: now - 24*3600}); err != nil {
+		t.Fatalf("AddSeason error: %v", err)
+	}
+	if _, err := AddSeason(dbh, Season{ID: "past", Name: "Again", Start: 1, End: 2}); err != ErrSeasonExists {
+		t.Errorf("AddSeason expected ErrSeasonExists, got %v", err)
+	}
+	if _, err := AddSeason(dbh, Season{ID: "Wrong ID", Start: 2, End: 1}); err == nil {
+		t.Errorf("AddSeason expected an error for the wrong season")
+	}
+	if err := e.RefreshLeaderboards(); err != nil {
+		t.Fatalf("RefreshLeaderboards error: %v", err)
+	}
+	check := func(metric, period string, expected ...string) []LeaderEntry {
+		lb, err := ReadLeaderboard(dbh, metric, period)
+		if err != nil {
+			t.Fatalf("ReadLeaderboard error: %v", err)
+		}
+		names := []string{}
+		for _, entry := range lb.Entries {
+			names = append(names, entry.Name+"="+entry.Value.String())
+		}
+		if len(names) != len(expected) {
+			t.Errorf("Unexpected %s %s leaderboard: %v", metric, period, names)
+			return lb.Entries
+		}
+		for i := range names {
+			if names[i] != expected[i] {
+				t.Errorf("Unexpected %s %s leaderboard: %v", metric, period, names)
+				break
+			}
+		}
+		return lb.Entries
+	}
+	check(MetricPnL, "1d", "alice=45.00")
+	check(MetricPnL, "7d", "bob=1000.00", "alice=45.00")
+	check(MetricWinRate, "7d", "alice=83.33")
+	check(MetricProposals, "all", "bob=1")
+	check(MetricReputation, "1d", "bob=2.00", "alice=1.50")
+	check(MetricReputation, "season:past", "bob=2.00")
+	check(MetricReputation, "all", "bob=2.00", "alice=1.50")
+	if entries := check(MetricPnL, "season:past", "bob=1000.00"); len(entries) == 1 && entries[0].Rank != 1 {
+		t.Errorf("Unexpected rank: %+v", entries[0])
+	}
+	seasons, err := ListSeasons(dbh)
+	if err != nil || len(seasons) != 1 || !seasons[0].Final {
+		t.Errorf("Unexpected seasons: %+v, %v", seasons, err)
+	}
+	if _, err = ReadLeaderboard(dbh, "volume", "7d"); err != ErrWrongMetric {
+		t.Errorf("ReadLeaderboard expected ErrWrongMetric, got %v", err)
+	}
+	if _, err = ReadLeaderboard(dbh, MetricPnL, "season:next"); err != ErrWrongPeriod {
+		t.Errorf("ReadLeaderboard expected ErrWrongPeriod, got %v", err)
+	}
+}
+
+func TestSuccessful(t *testing.T) {
+	pr := Precision{Digits: 2, PipDigits: 0}
+	entry, exit := Event{10, NewPrice(425000, 2), StatePosition}, Event{20, NewPrice(425050, 2), StateTakeProfit}
+	for i, c := range []struct {
+		p        Proposal
+		expected bool
+	}{
+		{Proposal{Type: BuyStop, State: StateTakeProfit, History: []Event{entry, exit}}, true},
+		{Proposal{Type: SellStop, State: StateTakeProfit, History: []Event{entry, exit}}, false},
+		{Proposal{Type: BuyStop, State: StateTakeProfit}, false},
+		{Proposal{Type: BuyStop, State: StateTakeProfit, History: []Event{exit}}, false},
+		{Proposal{Type: BuyStop, State: StatePosition, History: []Event{entry}}, false},
+	} {
+		if c.p.Successful(pr) != c.expected {
+			t.Errorf("Successful of the proposal %d is not %v", i, c.expected)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// moderation.go introduces chat moderation: message removal, mutes, bans and word filters
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// User roles
+const (
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Moderation actions
+const (
+	ActDelete = "delete"
+	ActHide   = "hide"
+	ActUnhide = "unhide"
+	ActMute   = "mute"
+	ActBan    = "ban"
+	ActRole   = "role"
+)
+
+var (
+	// ErrNotModerator is returned when the user without moderator rights moderates the chat.
+	ErrNotModerator = errors.New("user is not a moderator")
+	// ErrMuted is returned when the muted user posts the message.
+	ErrMuted = errors.New("user is muted")
+	// ErrBanned is returned when the banned user posts the message or joins the room.
+	ErrBanned = errors.New("user is banned")
+	// ErrMessageNotFound is returned when the moderated message doesn't exist.
+	ErrMessageNotFound = errors.New("message is not found")
+	// ErrWrongAction is returned for unknown moderation actions.
+	ErrWrongAction = errors.New("wrong moderation action")
+)
+
+// ChatByMessage indexes chat buckets by the IDs of their messages.
+var ChatByMessage *db.Index
+
+func init() {
+	ChatByMessage = db.DeclareIndex("chat.message", db.CHAT, func(key, val []byte) [][]byte {
+		cb := ChatBucket{}
+		// Key pointers to the last buckets are not indexed
+		if json.Unmarshal(val, &cb) != nil {
+			return nil
+		}
+		keys := [][]byte{}
+		for _, m := range cb.Data {
+			if m.ID != "" {
+				keys = append(keys, idBytes(m.ID))
+			}
+		}
+		return keys
+	})
+}
+
+// Sanction is a mute or a ban of the user which is stored in SANCTIONS database.
+// Empty Room means that the sanction is applied to all rooms and private messages.
+// The sanction expires at the UNIX time Until.
+type Sanction struct {
+	UserID      string `json:"userid"`
+	Kind        string `json:"kind"`
+	Room        string `json:"room,omitempty"`
+	Until       int64  `json:"until"`
+	ModeratorID string `json:"moderatorid"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// key returns the key of the sanction in SANCTIONS database.
+func (s *Sanction) key() []byte {
+	return sanctionKey(s.UserID, s.Kind, s.Room)
+}
+
+// sanctionKey returns the key of the sanction: user + kind + 0 + room.
+func sanctionKey(userID, kind, room string) []byte {
+	return join(idBytes(userID), []byte(kind), []byte{0}, []byte(room))
+}
+
+// ModAction is a record of the moderation action which is stored in MODLOG database.
+// Target is the ID of the moderated message or user.
+type ModAction struct {
+	ID          string `json:"id"`
+	Time        int64  `json:"time"`
+	ModeratorID string `json:"moderatorid"`
+	Action      string `json:"action"`
+	Target      string `json:"target"`
+	Room        string `json:"room,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Until       int64  `json:"until,omitempty"`
+}
+
+// ModLogPage is a single page of the moderation log.
+type ModLogPage struct {
+	Data []ModAction `json:"data"`
+	Next string      `json:"next,omitempty"`
+}
+
+// ChatModeration is sent to the clients as type 6 message when the chat message is deleted or hidden.
+type ChatModeration struct {
+	Action string `json:"action"`
+	ID     string `json:"id"`
+	Room   string `json:"room"`
+}
+
+// readUser reads public information of the user.
+func readUser(dbh db.DBHandler, userID string) (u User, err error) {
+	err = readJSON(dbh, db.USERS, idBytes(userID), &u)
+	return
+}
+
+// IsModerator checks whether the user has moderator or administrator role.
+func IsModerator(dbh db.DBHandler, userID string) bool {
+	u, err := readUser(dbh, userID)
+	return err == nil && (u.Role == RoleModerator || u.Role == RoleAdmin)
+}
+
+// checkSanctions returns ErrBanned or ErrMuted if the user has the active sanction
+// of the given kinds in the room or in all rooms at the moment now.
+func checkSanctions(dbh db.DBHandler, userID, room string, now int64, kinds ...string) error {
+	for _, kind := range kinds {
+		for _, r := range []string{"", room} {
+			s := Sanction{}
+			err := readJSON(dbh, db.SANCTIONS, sanctionKey(userID, kind, r), &s)
+			if db.IsNotFound(err) || (err == nil && s.Until <= now) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if kind == ActBan {
+				return ErrBanned
+			}
+			return ErrMuted
+		}
+	}
+	return nil
+}
+
+// FilterText masks the filtered words of the text by asterisks, one asterisk per rune.
+// Words are matched case-insensitively.
+func FilterText(text string, words []string) string {
+	for _, w := range words {
+		if w == "" {
+			continue
+		}
+		re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(w))
+		text = re.ReplaceAllStringFunc(text, func(match string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+	return text
+}
+
+// SetChatFilter sets the list of words which are masked in posted messages.
+func (e *TCPWSEngine) SetChatFilter(words []string) {
+	e.chatmu.Lock()
+	e.filter = words
+	e.chatmu.Unlock()
+}
+
+// logAction records the moderation action.
+// Records are keyed by the time in nanoseconds to keep the order of actions made within a second.
+func (e *TCPWSEngine) logAction(a ModAction) error {
+	id := uuid.NewV4()
+	t := time.Now()
+	a.ID = id.String()
+	a.Time = t.Unix()
+	return writeJSON(e.db, db.MODLOG, join(encInt64(t.UnixNano()), id.Bytes()), a)
+}
+
+// ModerateMessage deletes, hides or unhides the chat message msgID by the moderator modID.
+// The change is sent to the members of the room as type 6 message.
+func (e *TCPWSEngine) ModerateMessage(modID, msgID, action, reason string) error {
+	if action != ActDelete && action != ActHide && action != ActUnhide {
+		return ErrWrongAction
+	}
+	if !IsModerator(e.db, modID) {
+		return ErrNotModerator
+	}
+	m, err := e.modifyMessage(msgID, func(cb *ChatBucket, i int) error {
+		switch action {
+		case ActDelete:
+			// The tombstone without the ID is not indexed and it keeps cursors of the chat history valid
+			cb.Data[i] = ChatMessage{Room: cb.Data[i].Room, Time: cb.Data[i].Time, Deleted: true}
+		case ActHide:
+			cb.Data[i].Hidden = true
+		case ActUnhide:
+			cb.Data[i].Hidden = false
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = e.logAction(ModAction{ModeratorID: modID, Action: action, Target: msgID, Room: m.Room, Reason: reason})
+	if err != nil {
+		return err
+	}
+	e.notifyRoom(m.Room, Message{MsgModerate, ChatModeration{action, msgID, m.Room}})
+	return nil
+}
+
+// Sanction mutes or bans the user by the moderator s.ModeratorID.
+// The sanction with the expiration time in the past lifts the previous one.
+func (e *TCPWSEngine) Sanction(s Sanction) error {
+	if s.Kind != ActMute && s.Kind != ActBan {
+		return ErrWrongAction
+	}
+	if !IsModerator(e.db, s.ModeratorID) {
+		return ErrNotModerator
+	}
+	if _, err := uuid.FromString(s.UserID); err != nil {
+		return err
+	}
+	var err error
+	if s.Until <= wallNow() {
+		if err = e.db.Delete(db.SANCTIONS, s.key()); db.IsNotFound(err) {
+			err = nil
+		}
+	} else {
+		err = writeJSON(e.db, db.SANCTIONS, s.key(), s)
+	}
+	if err != nil {
+		return err
+	}
+	// The banned user leaves the room
+	if s.Kind == ActBan && s.Room != "" && s.Until > wallNow() {
+		if err = e.LeaveRoom(s.Room, s.UserID); err != nil && !db.IsNotFound(err) {
+			return err
+		}
+	}
+	return e.logAction(ModAction{ModeratorID: s.ModeratorID, Action: s.Kind, Target: s.UserID,
+		Room: s.Room, Reason: s.Reason, Until: s.Until})
+}
+
+// SetRole changes the role of the user. The caller should check the rights of the administrator adminID.
+func (e *TCPWSEngine) SetRole(adminID, userID, role string) error {
+	if role != "" && role != RoleModerator && role != RoleAdmin {
+		return FieldErrors{"role": "must be empty, moderator or admin"}
+	}
+	u := User{}
+	err := e.db.Modify(db.USERS, idBytes(userID), &jsonModifier{&u, func() error {
+		u.Role = role
+		return nil
+	}})
+	if err != nil {
+		return err
+	}
+	return e.logAction(ModAction{ModeratorID: adminID, Action: ActRole, Target: userID, Reason: role})
+}
+
+// ListModLog returns the page of the moderation log starting from the most recent action.
+func ListModLog(dbh db.DBHandler, cursor string, limit int) (page ModLogPage, err error) {
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+	var after []byte
+	if cursor != "" {
+		if after, err = hex.DecodeString(cursor); err != nil {
+			err = ErrWrongCursor
+			return
+		}
+	}
+	page.Data = []ModAction{}
+	var last []byte
+	var jerr error
+	err = dbh.Scan(db.MODLOG, after, true, func(key, val []byte) bool {
+		if after != nil && string(key) == string(after) {
+			return true
+		}
+		if len(page.Data) == limit {
+			page.Next = hex.EncodeToString(last)
+			return false
+		}
+		a := ModAction{}
+		if jerr = json.Unmarshal(val, &a); jerr != nil {
+			return false
+		}
+		last = key
+		page.Data = append(page.Data, a)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
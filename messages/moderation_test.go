@@ -0,0 +1,160 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestFilterText(t *testing.T) {
+	for _, c := range []struct {
+		text, word, expected string
+	}{
+		{"Buy the DIP, dip again", "dip", "Buy the ***, *** again"},
+		{"Buy the dip", "", "Buy the dip"},
+		// Lower case of these runes has another length in bytes
+		{"Ⱥbad", "bad", "Ⱥ***"},
+		{"\u212Abadword", "badword", "\u212A*******"},
+		{"Dïp dÏP", "dïp", "*** ***"},
+	} {
+		if s := FilterText(c.text, []string{c.word}); s != c.expected {
+			t.Errorf("FilterText(%q) = %q, expected %q", c.text, s, c.expected)
+		}
+	}
+}
+
+func TestModeration(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	mod, user := uuid.NewV4().String(), uuid.NewV4().String()
+	for _, u := range []User{{ID: mod, Name: "mod", Role: RoleModerator}, {ID: user, Name: "user"}} {
+		if err := writeJSON(dbh, db.USERS, idBytes(u.ID), u); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+	}
+	if _, err := e.JoinRoom(GeneralRoom, user); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	if err := e.PostChat(GeneralRoom, ChatMessage{AuthorID: user, Text: "spam", Time: 1}); err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	page, err := ChatHistory(dbh, GeneralRoom, "", 10)
+	if err != nil || len(page.Data) != 1 || page.Data[0].ID == "" {
+		t.Fatalf("Unexpected chat page: %+v, %v", page, err)
+	}
+	msgID := page.Data[0].ID
+	// Only moderators hide messages
+	if err = e.ModerateMessage(user, msgID, ActHide, ""); err != ErrNotModerator {
+		t.Errorf("ModerateMessage expected ErrNotModerator, got %v", err)
+	}
+	if err = e.ModerateMessage(mod, msgID, ActHide, "spam"); err != nil {
+		t.Errorf("ModerateMessage error: %v", err)
+	}
+	if page, err = ChatHistory(dbh, GeneralRoom, "", 10); err != nil || len(page.Data) != 0 {
+		t.Errorf("Hidden message is returned: %+v, %v", page, err)
+	}
+	// The hidden message cannot be quoted
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: user, Text: "again", ReplyTo: msgID}); err != ErrWrongReply {
+		t.Errorf("PostChat expected ErrWrongReply, got %v", err)
+	}
+	last, err := dbh.Read(db.CHAT, lastBucketKey(GeneralRoom))
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	for reader, n := range map[string]int{mod: 1, user: 0, "": 0} {
+		if cb, err := ReadBucket(dbh, uuid.FromBytesOrNil(last), reader); err != nil || len(cb.Data) != n {
+			t.Errorf("Unexpected bucket for %q: %+v, %v", reader, cb, err)
+		}
+	}
+	// Muted users don't post till the mute expires
+	err = e.Sanction(Sanction{UserID: user, Kind: ActMute, Until: wallNow() + 100, ModeratorID: mod})
+	if err != nil {
+		t.Errorf("Sanction error: %v", err)
+	}
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: user, Text: "x", Time: 2}); err != ErrMuted {
+		t.Errorf("PostChat expected ErrMuted, got %v", err)
+	}
+	if _, err = e.EditChat(user, msgID, "x"); err != ErrMuted {
+		t.Errorf("EditChat expected ErrMuted, got %v", err)
+	}
+	if err = e.Sanction(Sanction{UserID: user, Kind: ActMute, ModeratorID: mod}); err != nil {
+		t.Errorf("Sanction error: %v", err)
+	}
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: user, Text: "x", Time: 2}); err != nil {
+		t.Errorf("PostChat error: %v", err)
+	}
+	// Banned users leave the room and cannot join it
+	err = e.Sanction(Sanction{UserID: user, Kind: ActBan, Room: GeneralRoom, Until: wallNow() + 100, ModeratorID: mod})
+	if err != nil {
+		t.Errorf("Sanction error: %v", err)
+	}
+	if _, err = e.JoinRoom(GeneralRoom, user); err != ErrBanned {
+		t.Errorf("JoinRoom expected ErrBanned, got %v", err)
+	}
+	log, err := ListModLog(dbh, "", 2)
+	if err != nil || len(log.Data) != 2 || log.Data[0].Action != ActBan || log.Next == "" {
+		t.Errorf("Unexpected moderation log: %+v, %v", log, err)
+	}
+	if log, err = ListModLog(dbh, log.Next, 2); err != nil || len(log.Data) != 2 || log.Data[1].Action != ActHide {
+		t.Errorf("Unexpected moderation log: %+v, %v", log, err)
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	mod, user := uuid.NewV4().String(), uuid.NewV4().String()
+	for _, u := range []User{{ID: mod, Name: "mod", Role: RoleModerator}, {ID: user, Name: "user"}} {
+		if err := writeJSON(dbh, db.USERS, idBytes(u.ID), u); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+	}
+	if _, err := e.JoinRoom(GeneralRoom, user); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	for _, text := range []string{"a", "b", "c"} {
+		if err := e.PostChat(GeneralRoom, ChatMessage{AuthorID: user, Text: text, Time: wallNow()}); err != nil {
+			t.Fatalf("PostChat error: %v", err)
+		}
+	}
+	all, err := ChatHistory(dbh, GeneralRoom, "", 10)
+	if err != nil || len(all.Data) != 3 {
+		t.Fatalf("Unexpected chat page: %+v, %v", all, err)
+	}
+	// The cursor is taken before the deletion
+	page, err := ChatHistory(dbh, GeneralRoom, "", 1)
+	if err != nil || len(page.Data) != 1 || page.Data[0].Text != "c" {
+		t.Fatalf("Unexpected chat page: %+v, %v", page, err)
+	}
+	deleted := all.Data[1].ID
+	if err = e.ModerateMessage(mod, deleted, ActDelete, "spam"); err != nil {
+		t.Fatalf("ModerateMessage error: %v", err)
+	}
+	// The cursor neither skips nor repeats messages
+	if page, err = ChatHistory(dbh, GeneralRoom, page.Before, 10); err != nil || len(page.Data) != 1 || page.Data[0].Text != "a" {
+		t.Errorf("Unexpected chat page after the deletion: %+v, %v", page, err)
+	}
+	if _, err = ReadMessage(dbh, deleted); err != ErrMessageNotFound {
+		t.Errorf("ReadMessage expected ErrMessageNotFound, got %v", err)
+	}
+	if _, err = e.EditChat(user, deleted, "edited"); err != ErrMessageNotFound {
+		t.Errorf("EditChat expected ErrMessageNotFound, got %v", err)
+	}
+	if err = e.ModerateMessage(mod, deleted, ActDelete, ""); err != ErrMessageNotFound {
+		t.Errorf("ModerateMessage expected ErrMessageNotFound, got %v", err)
+	}
+	last, err := dbh.Read(db.CHAT, lastBucketKey(GeneralRoom))
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if cb, err := ReadBucket(dbh, uuid.FromBytesOrNil(last), mod); err != nil || len(cb.Data) != 2 || cb.Data[1].Text != "c" {
+		t.Errorf("Unexpected bucket after the deletion: %+v, %v", cb, err)
+	}
+}
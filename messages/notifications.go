@@ -0,0 +1,256 @@
+// notifications.go keeps the inbox of notifications of users and delivers them over websockets
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"time"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Limits of the notification list
+const (
+	DefaultNotifications = 50
+	MaxNotifications     = 200
+)
+
+// ErrNotificationNotFound is returned when the notification of the user doesn't exist.
+var ErrNotificationNotFound = errors.New("notification is not found")
+
+// Notification is the message of the alert rule stored in NOTIFICATIONS database.
+// Kind is the kind of the alert rule. Other fields refer to the object which has fired the alert.
+// Seq orders notifications of the user by the time they are stored. Read is set by the user,
+// Delivered is set when the notification is sent to the connected user.
+// It is sent to the user as type 15 message.
+type Notification struct {
+	ID         string `json:"id"`
+	UserID     string `json:"userid"`
+	Kind       string `json:"kind"`
+	RuleID     string `json:"ruleid,omitempty"`
+	Text       string `json:"text"`
+	Symbol     string `json:"symbol,omitempty"`
+	Price      *Price `json:"price,omitempty"`
+	ProposalID string `json:"proposalid,omitempty"`
+	MessageID  string `json:"messageid,omitempty"`
+	Room       string `json:"room,omitempty"`
+	Created    int64  `json:"created"`
+	Seq        int64  `json:"seq"`
+	Read       bool   `json:"read"`
+	Delivered  bool   `json:"delivered"`
+}
+
+// Indexes of notifications. Their keys are user ID + seq.
+var (
+	// NotifyByUser indexes all notifications of users.
+	NotifyByUser *db.Index
+	// NotifyUnread indexes notifications which are not read.
+	NotifyUnread *db.Index
+	// NotifyPending indexes notifications which are not delivered.
+	NotifyPending *db.Index
+)
+
+func init() {
+	NotifyByUser = db.DeclareIndex("notifications.user", db.NOTIFICATIONS, notificationIndex(func(n *Notification) bool {
+		return true
+	}))
+	NotifyUnread = db.DeclareIndex("notifications.unread", db.NOTIFICATIONS, notificationIndex(func(n *Notification) bool {
+		return !n.Read
+	}))
+	NotifyPending = db.DeclareIndex("notifications.pending", db.NOTIFICATIONS, notificationIndex(func(n *Notification) bool {
+		return !n.Delivered
+	}))
+}
+
+// notificationIndex makes the KeyFunc which indexes the notifications selected by the filter.
+func notificationIndex(filter func(n *Notification) bool) db.KeyFunc {
+	return func(key, val []byte) [][]byte {
+		n := Notification{}
+		if json.Unmarshal(val, &n) != nil || !filter(&n) {
+			return nil
+		}
+		return [][]byte{join(idBytes(n.UserID), encInt64(n.Seq))}
+	}
+}
+
+// notify stores the notification in the inbox and sends it to the user if the user is connected.
+// The notification becomes delivered only if some client of the user has received it,
+// otherwise it waits for DeliverNotifications.
+func (e *TCPWSEngine) notify(n Notification) error {
+	n.ID = uuid.NewV4().String()
+	n.Created = wallNow()
+	// Nanoseconds of the system clock keep the order of notifications stored within a second
+	e.notifymu.Lock()
+	n.Seq = time.Now().UnixNano()
+	if n.Seq <= e.notifyseq {
+		n.Seq = e.notifyseq + 1
+	}
+	e.notifyseq = n.Seq
+	e.notifymu.Unlock()
+	n.Read = false
+	// The client which connects meanwhile gets the notification from DeliverNotifications
+	e.deliverymu.Lock()
+	defer e.deliverymu.Unlock()
+	delivered := n
+	delivered.Delivered = true
+	n.Delivered = e.send(Message{MsgNotification, delivered}, func(c *Client) bool {
+		return c.UserID == n.UserID
+	}) > 0
+	return writeJSON(e.db, db.NOTIFICATIONS, idBytes(n.ID), n)
+}
+
+// userKeys returns the primary keys of the notifications of the user found in the index.
+func userKeys(dbh db.DBHandler, idx *db.Index, userID string) (keys [][]byte, err error) {
+	prefix := idBytes(userID)
+	err = idx.Range(dbh, prefix, prefix, nil, false, func(ikey, pkey []byte) bool {
+		keys = append(keys, append([]byte{}, pkey...))
+		return true
+	})
+	return
+}
+
+// CountUnread returns the number of unread notifications of the user.
+func CountUnread(dbh db.DBHandler, userID string) (int, error) {
+	n := 0
+	prefix := idBytes(userID)
+	err := NotifyUnread.Range(dbh, prefix, prefix, nil, false, func(ikey, pkey []byte) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// Inbox is a single page of notifications of the user with the number of unread ones.
+// Next is the cursor of the next page. It is empty for the last page.
+type Inbox struct {
+	Data   []Notification `json:"data"`
+	Next   string         `json:"next,omitempty"`
+	Unread int            `json:"unread"`
+}
+
+// ListNotifications returns the page of notifications of the user from the most recent one.
+// Only unread notifications are listed if unread is set.
+// The limit is DefaultNotifications if it is not positive and it can't exceed MaxNotifications.
+func ListNotifications(dbh db.DBHandler, userID, cursor string, unread bool, limit int) (page Inbox, err error) {
+	if limit <= 0 {
+		limit = DefaultNotifications
+	}
+	if limit > MaxNotifications {
+		limit = MaxNotifications
+	}
+	idx := NotifyByUser
+	if unread {
+		idx = NotifyUnread
+	}
+	prefix := idBytes(userID)
+	page.Data = []Notification{}
+	page.Next, err = pageRange(dbh, idx, prefix, prefix, cursor, true, limit, func(pkey []byte) (bool, error) {
+		n := Notification{}
+		if err := readJSON(dbh, db.NOTIFICATIONS, pkey, &n); err != nil {
+			return false, err
+		}
+		page.Data = append(page.Data, n)
+		return true, nil
+	})
+	if err != nil {
+		return
+	}
+	page.Unread, err = CountUnread(dbh, userID)
+	return
+}
+
+// markRead marks the notifications with the keys as read. It returns the number of changed notifications.
+func markRead(dbh db.DBHandler, keys [][]byte) (int, error) {
+	changed := 0
+	for _, key := range keys {
+		n := Notification{}
+		err := dbh.Modify(db.NOTIFICATIONS, key, &jsonModifier{&n, func() error {
+			if !n.Read {
+				n.Read = true
+				changed++
+			}
+			return nil
+		}})
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// MarkRead marks the notifications of the user as read. It fails if some notification
+// doesn't belong to the user. It returns the number of notifications which were unread.
+func MarkRead(dbh db.DBHandler, userID string, ids []string) (int, error) {
+	keys := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		n := Notification{}
+		key := idBytes(id)
+		if err := readJSON(dbh, db.NOTIFICATIONS, key, &n); db.IsNotFound(err) || err == nil && n.UserID != userID {
+			return 0, errors.Wrap(ErrNotificationNotFound, id)
+		} else if err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	return markRead(dbh, keys)
+}
+
+// MarkAllRead marks all notifications of the user as read. It returns the number of notifications which were unread.
+func MarkAllRead(dbh db.DBHandler, userID string) (int, error) {
+	keys, err := userKeys(dbh, NotifyUnread, userID)
+	if err != nil {
+		return 0, err
+	}
+	return markRead(dbh, keys)
+}
+
+// DeliverNotifications sends the notifications which have been stored while the user
+// was offline to the new client in the order they are stored. They are sent in batches
+// of MaxNotifications type 15 messages and become delivered after the batch is sent.
+// Anonymous clients get nothing. The client must be registered by AddClient before.
+func (e *TCPWSEngine) DeliverNotifications(c *Client) error {
+	if c.UserID == "" {
+		return nil
+	}
+	e.deliverymu.Lock()
+	defer e.deliverymu.Unlock()
+	keys, err := userKeys(e.db, NotifyPending, c.UserID)
+	if err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > MaxNotifications {
+			batch = batch[:MaxNotifications]
+		}
+		keys = keys[len(batch):]
+		msgs := make([]Message, 0, len(batch))
+		for _, key := range batch {
+			n := Notification{}
+			if err = readJSON(e.db, db.NOTIFICATIONS, key, &n); err != nil {
+				return err
+			}
+			n.Delivered = true
+			msgs = append(msgs, Message{MsgNotification, n})
+		}
+		if err = c.SendMessages(msgs...); err != nil {
+			return err
+		}
+		for _, key := range batch {
+			n := Notification{}
+			err = e.db.Modify(db.NOTIFICATIONS, key, &jsonModifier{&n, func() error {
+				n.Delivered = true
+				return nil
+			}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
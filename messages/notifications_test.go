@@ -0,0 +1,119 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+func TestNotifications(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	user := uuid.NewV4().String()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		if err := e.notify(Notification{UserID: user, Kind: AlertMention, Text: string('a' + rune(i))}); err != nil {
+			t.Fatalf("notify error: %v", err)
+		}
+	}
+	// Pages go from the most recent notification
+	texts := ""
+	cursor := ""
+	for {
+		page, err := ListNotifications(dbh, user, cursor, false, 2)
+		if err != nil {
+			t.Fatalf("ListNotifications error: %v", err)
+		}
+		for _, n := range page.Data {
+			texts += n.Text
+			ids = append(ids, n.ID)
+		}
+		if page.Unread != 5 {
+			t.Errorf("Unexpected unread number: %d", page.Unread)
+		}
+		if cursor = page.Next; cursor == "" {
+			break
+		}
+	}
+	if texts != "edcba" {
+		t.Errorf("Unexpected order of notifications: %s", texts)
+	}
+	if n, err := MarkRead(dbh, user, ids[:2]); err != nil || n != 2 {
+		t.Errorf("MarkRead returned %d, %v", n, err)
+	}
+	if n, err := MarkRead(dbh, uuid.NewV4().String(), ids[2:3]); errors.Cause(err) != ErrNotificationNotFound || n != 0 {
+		t.Errorf("MarkRead expected ErrNotificationNotFound, got %d, %v", n, err)
+	}
+	page, err := ListNotifications(dbh, user, "", true, 0)
+	if err != nil || len(page.Data) != 3 || page.Data[0].Text != "c" || page.Unread != 3 {
+		t.Errorf("Unexpected unread notifications: %+v, %v", page, err)
+	}
+	if n, err := MarkAllRead(dbh, user); err != nil || n != 3 {
+		t.Errorf("MarkAllRead returned %d, %v", n, err)
+	}
+	if n, _ := CountUnread(dbh, user); n != 0 {
+		t.Errorf("Unexpected unread number after MarkAllRead: %d", n)
+	}
+	// Notifications stored while the user was offline are delivered on connect
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := NewClient(ws, user)
+		e.AddClient(c)
+		if err = e.DeliverNotifications(c); err != nil {
+			t.Errorf("DeliverNotifications error: %v", err)
+		}
+		clients <- c
+	}))
+	defer server.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage error: %v", err)
+	}
+	delivered := struct {
+		Data []struct {
+			Type byte         `json:"type"`
+			Data Notification `json:"data"`
+		}
+	}{}
+	if err = json.Unmarshal(data, &delivered); err != nil || len(delivered.Data) != 5 ||
+		delivered.Data[0].Type != MsgNotification || delivered.Data[0].Data.Text != "a" {
+		t.Errorf("Unexpected delivered notifications: %s, %v", data, err)
+	}
+	// The connected user gets new notifications right away
+	if err = e.notify(Notification{UserID: user, Kind: AlertMention, Text: "f"}); err != nil {
+		t.Fatalf("notify error: %v", err)
+	}
+	if _, data, err = ws.ReadMessage(); err != nil || !strings.Contains(string(data), `"text":"f"`) {
+		t.Errorf("Unexpected notification: %s, %v", data, err)
+	}
+	if keys, err := userKeys(dbh, NotifyPending, user); err != nil || len(keys) != 0 {
+		t.Errorf("Unexpected pending notifications: %d, %v", len(keys), err)
+	}
+	// The notification which the client fails to receive stays pending
+	(<-clients).Close()
+	if err = e.notify(Notification{UserID: user, Kind: AlertMention, Text: "g"}); err != nil {
+		t.Fatalf("notify error: %v", err)
+	}
+	if keys, err := userKeys(dbh, NotifyPending, user); err != nil || len(keys) != 1 {
+		t.Errorf("Unexpected pending notifications: %d, %v", len(keys), err)
+	}
+}
@@ -0,0 +1,48 @@
+// paging.go helps to split index ranges into pages
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/hex"
+
+	"union/db"
+)
+
+// pageRange visits the records found in the index range [lo, hi] starting right after the cursor.
+// visit reports whether the record with the primary key pkey was added to the page.
+// The scan stops when limit records are added. The cursor of the next page is returned
+// if there are more entries in the range.
+func pageRange(dbh db.DBHandler, idx *db.Index, lo, hi []byte, cursor string, reverse bool, limit int,
+	visit func(pkey []byte) (bool, error)) (next string, err error) {
+	var after []byte
+	if cursor != "" {
+		if after, err = hex.DecodeString(cursor); err != nil {
+			err = ErrWrongCursor
+			return
+		}
+	}
+	var last []byte
+	var verr error
+	added := 0
+	err = idx.Range(dbh, lo, hi, after, reverse, func(ikey, pkey []byte) bool {
+		if added == limit {
+			next = hex.EncodeToString(last)
+			return false
+		}
+		last = join(ikey, pkey)
+		var ok bool
+		if ok, verr = visit(pkey); verr != nil {
+			return false
+		}
+		if ok {
+			added++
+		}
+		return true
+	})
+	if err == nil {
+		err = verr
+	}
+	return
+}
@@ -0,0 +1,397 @@
+// price.go introduces fixed-point decimal prices
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// MaxPriceScale is the maximal number of decimal digits of prices.
+const MaxPriceScale = 8
+
+// maxScale is the maximal scale of prices for which the powers of 10 fit into int64.
+const maxScale = 18
+
+// Errors of prices
+var (
+	ErrWrongPrice    = errors.New("wrong price")
+	ErrPriceOverflow = errors.New("price overflow")
+)
+
+// pow10 contains the powers of 10 which fit into int64.
+var pow10 [maxScale + 1]int64
+
+func init() {
+	pow10[0] = 1
+	for i := 1; i < len(pow10); i++ {
+		pow10[i] = pow10[i-1] * 10
+	}
+}
+
+// Price is a fixed-point decimal number Units * 10^-Scale.
+// It is encoded in json as a string like "1.23450" which keeps the scale.
+// Json numbers are accepted as well and rounded to MaxPriceScale digits.
+type Price struct {
+	Units int64
+	Scale uint8
+}
+
+// NewPrice returns the price units * 10^-scale.
+func NewPrice(units int64, scale uint8) Price {
+	return Price{units, scale}
+}
+
+// ParsePrice parses the decimal number like "-1.2345" or "12345e-4".
+// It fails if the number has more than MaxPriceScale decimal digits.
+func ParsePrice(s string) (Price, error) {
+	p, err := parseDecimal(s)
+	if err != nil {
+		return p, err
+	}
+	if p.Scale > MaxPriceScale {
+		return p, errors.Wrapf(ErrWrongPrice, "%s has more than %d decimal digits", s, MaxPriceScale)
+	}
+	return p, nil
+}
+
+// parseDecimal parses the decimal number with any scale which fits into int64.
+func parseDecimal(s string) (Price, error) {
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		var err error
+		if exp, err = strconv.Atoi(s[i+1:]); err != nil {
+			return Price{}, errors.Wrap(ErrWrongPrice, s)
+		}
+		mant = s[:i]
+	}
+	scale := 0
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		scale = len(mant) - i - 1
+		mant = mant[:i] + mant[i+1:]
+	}
+	if mant == "" || mant == "-" || mant == "+" || strings.ContainsAny(mant[1:], "+-") {
+		return Price{}, errors.Wrap(ErrWrongPrice, s)
+	}
+	units, err := strconv.ParseInt(mant, 10, 64)
+	if err != nil {
+		return Price{}, errors.Wrap(ErrWrongPrice, s)
+	}
+	scale -= exp
+	if scale < 0 {
+		if -scale >= len(pow10) {
+			return Price{}, errors.Wrap(ErrWrongPrice, s)
+		}
+		if units, err = mulUnits(units, pow10[-scale]); err != nil {
+			return Price{}, errors.Wrap(err, s)
+		}
+		scale = 0
+	}
+	if scale >= len(pow10) {
+		return Price{}, errors.Wrap(ErrWrongPrice, s)
+	}
+	return Price{units, uint8(scale)}, nil
+}
+
+// String returns the decimal representation of the price with Scale digits after the point.
+func (p Price) String() string {
+	s := strconv.FormatInt(p.Units, 10)
+	if p.Scale == 0 {
+		return s
+	}
+	sign := ""
+	if p.Units < 0 {
+		sign, s = "-", s[1:]
+	}
+	if n := int(p.Scale) + 1 - len(s); n > 0 {
+		s = strings.Repeat("0", n) + s
+	}
+	i := len(s) - int(p.Scale)
+	return sign + s[:i] + "." + s[i:]
+}
+
+// Float64 returns the approximate value of the price.
+func (p Price) Float64() float64 {
+	return float64(p.Units) / float64(pow10[p.Scale])
+}
+
+// Sign returns -1, 0 or 1 for negative, zero and positive prices.
+func (p Price) Sign() int {
+	switch {
+	case p.Units < 0:
+		return -1
+	case p.Units > 0:
+		return 1
+	}
+	return 0
+}
+
+// mulUnits returns a * b. It fails if the product overflows int64.
+func mulUnits(a, b int64) (int64, error) {
+	abs := func(x int64) uint64 {
+		if x < 0 {
+			return uint64(-x)
+		}
+		return uint64(x)
+	}
+	hi, lo := bits.Mul64(abs(a), abs(b))
+	neg := (a < 0) != (b < 0)
+	if hi != 0 || lo > math.MaxInt64 && !(neg && lo == 1<<63) {
+		return 0, ErrPriceOverflow
+	}
+	if neg {
+		return -int64(lo), nil
+	}
+	return int64(lo), nil
+}
+
+// roundUnits divides units by 10^n rounding half away from zero.
+func roundUnits(units int64, n int) int64 {
+	if n >= len(pow10) {
+		return 0
+	}
+	d := pow10[n]
+	q, rem := units/d, units%d
+	if rem >= d-rem {
+		q++
+	} else if -rem >= d+rem {
+		q--
+	}
+	return q
+}
+
+// Rescale returns the same price with the greater or equal scale. The scale is limited by 18 digits.
+// It fails if the price doesn't fit into int64 with the scale.
+func (p Price) Rescale(scale uint8) (Price, error) {
+	if scale > maxScale {
+		scale = maxScale
+	}
+	if scale <= p.Scale {
+		return p, nil
+	}
+	units, err := mulUnits(p.Units, pow10[scale-p.Scale])
+	if err != nil {
+		return p, errors.Wrapf(err, "%s cannot have %d decimal digits", p, scale)
+	}
+	return Price{units, scale}, nil
+}
+
+// rescale is Rescale of prices which are known to fit into int64 with the scale
+// such as prices and amounts of the same instrument.
+func (p Price) rescale(scale uint8) Price {
+	if scale > maxScale {
+		scale = maxScale
+	}
+	if scale <= p.Scale {
+		return p
+	}
+	return Price{p.Units * pow10[scale-p.Scale], scale}
+}
+
+// trim returns the same price without trailing zeros of the fraction.
+func (p Price) trim() Price {
+	for p.Scale > 0 && p.Units%10 == 0 {
+		p.Units /= 10
+		p.Scale--
+	}
+	return p
+}
+
+// Round rounds the price half away from zero to scale digits.
+func (p Price) Round(scale uint8) Price {
+	if scale >= p.Scale {
+		return p.rescale(scale)
+	}
+	return Price{roundUnits(p.Units, int(p.Scale-scale)), scale}
+}
+
+// align returns both prices with the same scale.
+func align(p, q Price) (Price, Price) {
+	if p.Scale < q.Scale {
+		return p.rescale(q.Scale), q
+	}
+	return p, q.rescale(p.Scale)
+}
+
+// Add returns p + q.
+func (p Price) Add(q Price) Price {
+	p, q = align(p, q)
+	return Price{p.Units + q.Units, p.Scale}
+}
+
+// Sub returns p - q.
+func (p Price) Sub(q Price) Price {
+	p, q = align(p, q)
+	return Price{p.Units - q.Units, p.Scale}
+}
+
+// Mul returns p * q. The scale of the product is the sum of the scales,
+// the product is rounded to 18 digits if the sum is greater. It fails if the product overflows int64.
+func (p Price) Mul(q Price) (Price, error) {
+	units, err := mulUnits(p.Units, q.Units)
+	if err != nil {
+		return Price{}, errors.Wrapf(err, "%s * %s", p, q)
+	}
+	scale := int(p.Scale) + int(q.Scale)
+	if scale > maxScale {
+		return Price{roundUnits(units, scale-maxScale), maxScale}, nil
+	}
+	return Price{units, uint8(scale)}, nil
+}
+
+// Cmp returns -1, 0 or 1 if p is less than, equal to or greater than q.
+func (p Price) Cmp(q Price) int {
+	return p.Sub(q).Sign()
+}
+
+// Shift multiplies the price by 10^n. The price is rounded to 18 digits if it has more of them.
+// It fails if the price overflows int64.
+func (p Price) Shift(n int) (Price, error) {
+	if n < 0 {
+		scale := int(p.Scale) - n
+		if scale > maxScale {
+			return Price{roundUnits(p.Units, scale-maxScale), maxScale}, nil
+		}
+		return Price{p.Units, uint8(scale)}, nil
+	}
+	if int(p.Scale) >= n {
+		return Price{p.Units, p.Scale - uint8(n)}, nil
+	}
+	if p.Units == 0 {
+		return Price{}, nil
+	}
+	if n-int(p.Scale) >= len(pow10) {
+		return p, errors.Wrapf(ErrPriceOverflow, "%s * 1e%d", p, n)
+	}
+	units, err := mulUnits(p.Units, pow10[n-int(p.Scale)])
+	if err != nil {
+		return p, errors.Wrapf(err, "%s * 1e%d", p, n)
+	}
+	return Price{units, 0}, nil
+}
+
+// MarshalJSON encodes the price as a json string.
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + p.String() + `"`), nil
+}
+
+// UnmarshalJSON decodes the price from a json string or a json number.
+// Numbers are rounded to MaxPriceScale digits because they are usually produced from floats.
+func (p *Price) UnmarshalJSON(data []byte) (err error) {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err = json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*p, err = ParsePrice(s)
+		return
+	}
+	if *p, err = parseDecimal(s); err == nil && p.Scale > MaxPriceScale {
+		*p = p.Round(MaxPriceScale)
+	}
+	return
+}
+
+// Precision describes the prices of the instrument.
+// Digits is the number of decimal digits of quotes, PipDigits is the position of the pip digit.
+type Precision struct {
+	Digits    uint8 `json:"digits"`
+	PipDigits uint8 `json:"pipdigits"`
+}
+
+// DefaultPrecision is the precision of 5-digit FX quotes like 1.23456.
+var DefaultPrecision = Precision{Digits: 5, PipDigits: 4}
+
+// Normalize brings the price to the precision. It fails if the price has more digits than allowed
+// or it doesn't fit into int64 with the digits.
+func (pr Precision) Normalize(p Price) (Price, error) {
+	if p.Scale <= pr.Digits {
+		return p.Rescale(pr.Digits)
+	}
+	r := p.Round(pr.Digits)
+	if r.Cmp(p) != 0 {
+		return p, errors.Wrapf(ErrWrongPrice, "%s has more than %d decimal digits", p, pr.Digits)
+	}
+	return r, nil
+}
+
+// Pip returns the size of one pip.
+func (pr Precision) Pip() Price {
+	return NewPrice(1, pr.PipDigits)
+}
+
+// Pips returns the distance from one price to another in pips. Fractional pips are kept.
+// The prices are normalized to Digits which are not less than PipDigits, so the shift is exact.
+func (pr Precision) Pips(from, to Price) Price {
+	d := to.Sub(from).rescale(pr.PipDigits)
+	return Price{d.Units, d.Scale - pr.PipDigits}
+}
+
+// AddPips returns the price moved by the number of pips.
+func (pr Precision) AddPips(p Price, pips int64) Price {
+	return p.Add(NewPrice(pips, pr.PipDigits))
+}
+
+// Spread returns the spread between bid and ask prices.
+func Spread(bid, ask Price) Price {
+	return ask.Sub(bid)
+}
+
+// MigratePrices converts float prices of stored proposals into decimal prices of DefaultPrecision.
+// Proposals which already have decimal prices are skipped. It returns the number of converted proposals.
+func MigratePrices(dbh db.DBHandler) (int, error) {
+	legacy := func(val []byte) bool {
+		v := struct {
+			Price json.RawMessage `json:"price"`
+		}{}
+		return json.Unmarshal(val, &v) == nil && !strings.HasPrefix(string(v.Price), `"`)
+	}
+	round := func(p Price) Price {
+		return p.Round(DefaultPrecision.Digits)
+	}
+	return migrateProposals(dbh, legacy, func(p *Proposal) {
+		p.Price, p.StopLoss, p.TakeProfit = round(p.Price), round(p.StopLoss), round(p.TakeProfit)
+		for i := range p.History {
+			p.History[i].Value = round(p.History[i].Value)
+		}
+	})
+}
+
+// migrateProposals changes the stored proposals which are detected as legacy ones.
+// It returns the number of changed proposals.
+func migrateProposals(dbh db.DBHandler, legacy func(val []byte) bool, change func(p *Proposal)) (n int, err error) {
+	var keys [][]byte
+	err = dbh.Scan(db.PROPOSALS, nil, false, func(key, val []byte) bool {
+		if legacy(val) {
+			keys = append(keys, append([]byte{}, key...))
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		p := Proposal{}
+		err = dbh.Modify(db.PROPOSALS, key, &jsonModifier{&p, func() error {
+			change(&p)
+			return nil
+		}})
+		if err != nil {
+			return n, errors.Wrapf(err, "cannot migrate the proposal %x", key)
+		}
+		n++
+	}
+	return
+}
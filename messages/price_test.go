@@ -0,0 +1,119 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"testing"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+func TestPrice(t *testing.T) {
+	for s, expected := range map[string]string{"1.2345": "1.2345", "-0.05": "-0.05", "12345e-4": "1.2345", "1.5E2": "150", "7": "7"} {
+		if p, err := ParsePrice(s); err != nil || p.String() != expected {
+			t.Errorf("ParsePrice(%q) = %v, %v", s, p, err)
+		}
+	}
+	for _, s := range []string{"", "-", "1.2.3", "1-2", "0.123456789", "abc"} {
+		if _, err := ParsePrice(s); err == nil {
+			t.Errorf("ParsePrice(%q) expected an error", s)
+		}
+	}
+	for _, s := range []string{"10e18", "92233720368547758e3", "-92233720368547758e3"} {
+		if p, err := ParsePrice(s); errors.Cause(err) != ErrPriceOverflow {
+			t.Errorf("ParsePrice(%q) = %v, %v, expected ErrPriceOverflow", s, p, err)
+		}
+	}
+	if p, err := ParsePrice("-9223372036854775e3"); err != nil || p.String() != "-9223372036854775000" {
+		t.Errorf("Unexpected price: %v, %v", p, err)
+	}
+	a, b := NewPrice(12345, 4), NewPrice(123471, 5)
+	if s := b.Sub(a).String(); s != "0.00021" {
+		t.Errorf("Unexpected difference: %s", s)
+	}
+	if a.Cmp(b) != -1 || b.Cmp(a) != 1 || a.Cmp(NewPrice(123450, 5)) != 0 {
+		t.Errorf("Unexpected comparison of %v and %v", a, b)
+	}
+	if s := NewPrice(123455, 5).Round(4).String(); s != "1.2346" {
+		t.Errorf("Unexpected rounding: %s", s)
+	}
+	if s := NewPrice(-123455, 5).Round(4).String(); s != "-1.2346" {
+		t.Errorf("Unexpected rounding: %s", s)
+	}
+	// Pip arithmetic
+	pr := DefaultPrecision
+	if s := pr.Pips(a, b).String(); s != "2.1" {
+		t.Errorf("Unexpected pips: %s", s)
+	}
+	if s := pr.AddPips(a, -20).String(); s != "1.2325" {
+		t.Errorf("Unexpected price: %s", s)
+	}
+	if _, err := pr.Normalize(NewPrice(1234567, 6)); err == nil {
+		t.Errorf("Normalize expected an error")
+	}
+	if p, err := pr.Normalize(a); err != nil || p.String() != "1.23450" {
+		t.Errorf("Unexpected normalized price: %v, %v", p, err)
+	}
+	// Overflow and scale limits
+	if m, err := NewPrice(15, 1).Mul(NewPrice(-3, 2)); err != nil || m.String() != "-0.045" {
+		t.Errorf("Unexpected product: %v, %v", m, err)
+	}
+	if m, err := NewPrice(15, 10).Mul(NewPrice(15, 10)); err != nil || m.Scale != 18 || m.Units != 2 {
+		t.Errorf("Unexpected rounded product: %+v, %v", m, err)
+	}
+	if _, err := NewPrice(1e10, 8).Mul(NewPrice(1e10, 3)); errors.Cause(err) != ErrPriceOverflow {
+		t.Errorf("Mul expected ErrPriceOverflow, got %v", err)
+	}
+	if s, err := NewPrice(5, 17).Shift(-2); err != nil || s.Scale != 18 || s.Units != 1 || s.Float64() != 1e-18 {
+		t.Errorf("Unexpected shifted price: %+v, %v", s, err)
+	}
+	if s, err := a.Shift(6); err != nil || s.String() != "1234500" {
+		t.Errorf("Unexpected shifted price: %v, %v", s, err)
+	}
+	if _, err := a.Shift(30); errors.Cause(err) != ErrPriceOverflow {
+		t.Errorf("Shift expected ErrPriceOverflow, got %v", err)
+	}
+	if r, err := a.Rescale(30); err != nil || r.Scale != 18 {
+		t.Errorf("Unexpected rescaled price: %+v, %v", r, err)
+	}
+	if _, err := NewPrice(1e15, 0).Rescale(8); errors.Cause(err) != ErrPriceOverflow {
+		t.Errorf("Rescale expected ErrPriceOverflow, got %v", err)
+	}
+	if _, err := pr.Normalize(NewPrice(1e15, 0)); errors.Cause(err) != ErrPriceOverflow {
+		t.Errorf("Normalize expected ErrPriceOverflow, got %v", err)
+	}
+	// Json strings and numbers
+	var e Event
+	if err := json.Unmarshal([]byte(`{"value": 1.2345}`), &e); err != nil || e.Value.String() != "1.2345" {
+		t.Errorf("Unexpected event: %+v, %v", e, err)
+	}
+	data, _ := json.Marshal(Event{Value: NewPrice(12340, 4)})
+	if string(data) != `{"time":0,"value":"1.2340","state":0}` {
+		t.Errorf("Unexpected json: %s", data)
+	}
+}
+
+func TestMigratePrices(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	legacy := `{"id":"a","price":1.23456789,"stoploss":1.2,"takeprofit":1.3,"history":[{"time":1,"value":1.23456,"state":0}]}`
+	if err := dbh.Write(db.PROPOSALS, []byte("a"), []byte(legacy)); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	for i, expected := range []int{1, 0} {
+		if n, err := MigratePrices(dbh); err != nil || n != expected {
+			t.Errorf("MigratePrices #%d = %d, %v", i, n, err)
+		}
+	}
+	p := Proposal{}
+	if err := readJSON(dbh, db.PROPOSALS, []byte("a"), &p); err != nil {
+		t.Fatalf("readJSON error: %v", err)
+	}
+	if p.Price.String() != "1.23457" || p.StopLoss.String() != "1.20000" || p.History[0].Value.String() != "1.23456" {
+		t.Errorf("Unexpected migrated proposal: %+v", p)
+	}
+}
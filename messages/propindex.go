@@ -0,0 +1,245 @@
+// propindex.go maintains search indexes of proposals and lists proposals by means of them
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Indexes of proposals
+var (
+	// PropByCreated indexes proposals by the creation time.
+	PropByCreated *db.Index
+	// PropByDeadline indexes proposals by the deadline.
+	PropByDeadline *db.Index
+	// PropByAuthor indexes proposals by the author and the creation time.
+	PropByAuthor *db.Index
+	// PropByState indexes proposals by the state and the creation time.
+	PropByState *db.Index
+	// PropByScore indexes dynamic parts of proposals by the score.
+	PropByScore *db.Index
+)
+
+func init() {
+	PropByCreated = db.DeclareIndex("proposals.created", db.PROPOSALS, proposalKey(func(p *Proposal) []byte {
+		return encInt64(p.Created)
+	}))
+	PropByDeadline = db.DeclareIndex("proposals.deadline", db.PROPOSALS, proposalKey(func(p *Proposal) []byte {
+		return encInt64(p.Deadline)
+	}))
+	PropByAuthor = db.DeclareIndex("proposals.author", db.PROPOSALS, proposalKey(func(p *Proposal) []byte {
+		return join(idBytes(p.AuthorID), encInt64(p.Created))
+	}))
+	PropByState = db.DeclareIndex("proposals.state", db.PROPOSALS, proposalKey(func(p *Proposal) []byte {
+		return join([]byte{p.State}, encInt64(p.Created))
+	}))
+	PropByScore = db.DeclareIndex("dynamic.score", db.DYNAMIC, func(key, val []byte) [][]byte {
+		dp := DynProp{}
+		if json.Unmarshal(val, &dp) != nil {
+			return nil
+		}
+		return [][]byte{encFloat64(dp.Score)}
+	})
+}
+
+// proposalKey makes the KeyFunc for PROPOSALS database which extracts the index key by means of f.
+func proposalKey(f func(p *Proposal) []byte) db.KeyFunc {
+	return func(key, val []byte) [][]byte {
+		p := Proposal{}
+		if json.Unmarshal(val, &p) != nil {
+			return nil
+		}
+		return [][]byte{f(&p)}
+	}
+}
+
+// Sorting orders of the proposal list
+const (
+	SortCreated  = "created"
+	SortDeadline = "deadline"
+	SortScore    = "score"
+)
+
+// Limits of the proposal page size
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ErrWrongCursor is returned when the pagination cursor can't be decoded.
+var ErrWrongCursor = errors.New("wrong cursor")
+
+// ProposalFilter describes the proposals which should be listed.
+// Zero values of the fields mean no filtering.
+type ProposalFilter struct {
+	State        *byte
+	Type         *byte
+	Author       string
+	Symbol       string
+	CreatedFrom  int64
+	CreatedTo    int64
+	DeadlineFrom int64
+	DeadlineTo   int64
+	MinScore     float64
+	// Sort is one of SortCreated, SortDeadline or SortScore
+	Sort   string
+	Desc   bool
+	Cursor string
+	Limit  int
+}
+
+// ProposalPage is a single page of the proposal list.
+// Next is the cursor of the next page. It is empty for the last page.
+type ProposalPage struct {
+	Data []Proposal `json:"data"`
+	Next string     `json:"next,omitempty"`
+}
+
+// encInt64 encodes v so that the byte order matches the numeric order.
+func encInt64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v)^(1<<63))
+	return b
+}
+
+// encFloat64 encodes v so that the byte order matches the numeric order.
+func encFloat64(v float64) []byte {
+	u := math.Float64bits(v)
+	if u&(1<<63) != 0 {
+		u = ^u
+	} else {
+		u |= 1 << 63
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, u)
+	return b
+}
+
+// idBytes converts the string uuid into the byte sequence.
+// Wrong uuids are converted into the nil uuid.
+func idBytes(id string) []byte {
+	return uuid.FromStringOrNil(id).Bytes()
+}
+
+// join concatenates byte slices.
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// Match checks whether the proposal p with the current score satisfies the filter.
+func (f *ProposalFilter) Match(p *Proposal, score float64) bool {
+	switch {
+	case f.State != nil && p.State != *f.State:
+	case f.Type != nil && p.Type != *f.Type:
+	case f.Author != "" && p.AuthorID != f.Author:
+	case f.Symbol != "" && p.Symbol != f.Symbol:
+	case f.CreatedFrom != 0 && p.Created < f.CreatedFrom:
+	case f.CreatedTo != 0 && p.Created > f.CreatedTo:
+	case f.DeadlineFrom != 0 && p.Deadline < f.DeadlineFrom:
+	case f.DeadlineTo != 0 && p.Deadline > f.DeadlineTo:
+	case score < f.MinScore:
+	default:
+		return true
+	}
+	return false
+}
+
+// scanRange returns the index which should be scanned and the range of the extracted keys.
+// Nil bound means no limit.
+func (f *ProposalFilter) scanRange() (idx *db.Index, lo, hi []byte) {
+	switch {
+	case f.Sort == SortScore:
+		idx = PropByScore
+		if f.MinScore != 0 {
+			lo = encFloat64(f.MinScore)
+		}
+		return
+	case f.Sort == SortDeadline:
+		idx = PropByDeadline
+		lo, hi = int64Bounds(nil, f.DeadlineFrom, f.DeadlineTo)
+		return
+	case f.Author != "":
+		idx = PropByAuthor
+		lo, hi = int64Bounds(idBytes(f.Author), f.CreatedFrom, f.CreatedTo)
+	case f.State != nil:
+		idx = PropByState
+		lo, hi = int64Bounds([]byte{*f.State}, f.CreatedFrom, f.CreatedTo)
+	default:
+		idx = PropByCreated
+		lo, hi = int64Bounds(nil, f.CreatedFrom, f.CreatedTo)
+	}
+	return
+}
+
+// int64Bounds encodes range bounds after the prefix. Zero bound means no limit.
+func int64Bounds(prefix []byte, from, to int64) (lo, hi []byte) {
+	lo, hi = prefix, prefix
+	if from != 0 {
+		lo = join(prefix, encInt64(from))
+	}
+	if to != 0 {
+		hi = join(prefix, encInt64(to))
+	}
+	return
+}
+
+// ListProposals returns the page of proposals which satisfy the filter f.
+// The proposals are found by means of the proposal indexes.
+func ListProposals(dbh db.DBHandler, f ProposalFilter) (page ProposalPage, err error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+	idx, lo, hi := f.scanRange()
+	page.Data = []Proposal{}
+	page.Next, err = pageRange(dbh, idx, lo, hi, f.Cursor, f.Desc, f.Limit, func(pkey []byte) (bool, error) {
+		p, score, err := readIndexed(dbh, pkey)
+		if err != nil || !f.Match(&p, score) {
+			return false, err
+		}
+		page.Data = append(page.Data, p)
+		return true, nil
+	})
+	return
+}
+
+// readIndexed reads the proposal and its current score.
+// The score is taken from the dynamic part of the proposal if it exists.
+func readIndexed(dbh db.DBHandler, id []byte) (p Proposal, score float64, err error) {
+	var data []byte
+	data, err = dbh.Read(db.PROPOSALS, id)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		return
+	}
+	score = float64(p.Score)
+	dp := DynProp{}
+	data, err = dbh.Read(db.DYNAMIC, id)
+	if db.IsNotFound(err) {
+		// The proposal has no dynamic part
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &dp); err != nil {
+		return
+	}
+	score = dp.Score
+	p.Score = float32(score)
+	return
+}
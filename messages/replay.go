@@ -0,0 +1,359 @@
+// replay.go introduces the replay of recorded ticks through the price feed
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tickMagic starts the files of the binary tick format.
+const tickMagic = "UTK1"
+
+// ErrWrongTicks is returned when the tick file has an unknown format.
+var ErrWrongTicks = errors.New("wrong tick file")
+
+// csvSource reads ticks "SYMBOL,BID,ASK,TIME" from the CSV stream.
+type csvSource struct {
+	r    io.ReadCloser
+	csv  *csv.Reader
+	line int
+}
+
+// NewCSVSource returns the source which reads ticks from CSV lines "SYMBOL,BID,ASK,TIME"
+// where TIME is UNIX time in milliseconds. The header line "symbol,bid,ask,time" is skipped.
+func NewCSVSource(r io.ReadCloser) FeedSource {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	c.TrimLeadingSpace = true
+	return &csvSource{r: r, csv: c}
+}
+
+// Next reads the next CSV line.
+func (s *csvSource) Next() (Quote, error) {
+	rec, err := s.csv.Read()
+	if err != nil {
+		return Quote{}, err
+	}
+	s.line++
+	if s.line == 1 && len(rec) > 0 && strings.EqualFold(rec[0], "symbol") {
+		return s.Next()
+	}
+	if len(rec) != 4 {
+		return Quote{}, errors.Wrapf(ErrWrongQuote, "line %d has %d fields", s.line, len(rec))
+	}
+	return ParseQuote(strings.Join(rec, " "))
+}
+
+// Close closes the underlying stream.
+func (s *csvSource) Close() error {
+	return s.r.Close()
+}
+
+// binarySource reads ticks of the binary format written by TickWriter.
+type binarySource struct {
+	r      io.ReadCloser
+	br     *bufio.Reader
+	symbol string
+	time   int64
+}
+
+// NewBinarySource returns the source which reads ticks of the binary format written by TickWriter.
+func NewBinarySource(r io.ReadCloser) (FeedSource, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(tickMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != tickMagic {
+		return nil, ErrWrongTicks
+	}
+	return &binarySource{r: r, br: br}, nil
+}
+
+// Next reads the next binary record.
+func (s *binarySource) Next() (q Quote, err error) {
+	n, err := binary.ReadUvarint(s.br)
+	if err != nil {
+		return
+	}
+	if n > 0 {
+		symbol := make([]byte, n)
+		if _, err = io.ReadFull(s.br, symbol); err != nil {
+			return q, s.unexpected(err)
+		}
+		s.symbol = string(symbol)
+	}
+	scale, err := s.br.ReadByte()
+	if err != nil {
+		return q, s.unexpected(err)
+	}
+	var v [3]int64
+	for i := range v {
+		if v[i], err = binary.ReadVarint(s.br); err != nil {
+			return q, s.unexpected(err)
+		}
+	}
+	s.time += v[2]
+	q.Symbol = s.symbol
+	q.Bid = NewPrice(v[0], scale)
+	q.Ask = NewPrice(v[0]+v[1], scale)
+	q.Time = s.time
+	return q, nil
+}
+
+// unexpected reports the end of the stream within the record.
+func (s *binarySource) unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Close closes the underlying stream.
+func (s *binarySource) Close() error {
+	return s.r.Close()
+}
+
+// TickWriter writes ticks in the compact binary format. Every record consists of
+// the uvarint length of the symbol and the symbol itself (zero length repeats the previous symbol),
+// the price scale byte, the varint bid units, the varint spread units and the varint time
+// since the previous record in milliseconds.
+type TickWriter struct {
+	w      *bufio.Writer
+	symbol string
+	time   int64
+	buf    [binary.MaxVarintLen64]byte
+}
+
+// NewTickWriter writes the header of the binary format and returns the writer.
+func NewTickWriter(w io.Writer) (*TickWriter, error) {
+	tw := &TickWriter{w: bufio.NewWriter(w)}
+	if _, err := tw.w.WriteString(tickMagic); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+// Write appends the tick. Bid and ask prices are stored with the same scale.
+func (tw *TickWriter) Write(q Quote) error {
+	bid, ask := align(q.Bid, q.Ask)
+	if q.Symbol == tw.symbol {
+		tw.w.WriteByte(0)
+	} else {
+		tw.w.Write(tw.buf[:binary.PutUvarint(tw.buf[:], uint64(len(q.Symbol)))])
+		tw.w.WriteString(q.Symbol)
+		tw.symbol = q.Symbol
+	}
+	tw.w.WriteByte(bid.Scale)
+	for _, v := range []int64{bid.Units, ask.Units - bid.Units, q.Time - tw.time} {
+		if _, err := tw.w.Write(tw.buf[:binary.PutVarint(tw.buf[:], v)]); err != nil {
+			return err
+		}
+	}
+	tw.time = q.Time
+	return nil
+}
+
+// Flush writes the buffered ticks to the underlying writer.
+func (tw *TickWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+// OpenTicks opens the tick file. Files with .csv extension are read as CSV,
+// other files must have the binary format.
+func OpenTicks(path string) (FeedSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return NewCSVSource(f), nil
+	}
+	src, err := NewBinarySource(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, path)
+	}
+	return src, nil
+}
+
+// ReplayStatus describes the progress of the replay.
+// Time is the time of the last replayed tick in UNIX milliseconds.
+type ReplayStatus struct {
+	Time     int64   `json:"time"`
+	Ticks    int64   `json:"ticks"`
+	Speed    float64 `json:"speed"`
+	Stepwise bool    `json:"stepwise"`
+}
+
+// Replay is a FeedSource which replays the ticks of another source at the given speed.
+// Speed 1 keeps the original pace of ticks, greater speed accelerates it
+// and zero speed replays ticks as fast as possible. In the stepwise mode
+// ticks are released by Step. Clock follows the time of replayed ticks.
+type Replay struct {
+	Clock *ReplayClock
+
+	src     FeedSource
+	first   *Quote
+	err     error
+	mu      sync.Mutex
+	status  ReplayStatus
+	permits int
+	sent    time.Time
+	wake    chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// CurrentReplay is the replay of the server. It is nil unless the server replays ticks.
+var CurrentReplay *Replay
+
+// NewReplay returns the replay of the source at the speed.
+// The first tick is read at once, so Clock starts at its time. Wrong quotes before it are skipped.
+func NewReplay(src FeedSource, speed float64) *Replay {
+	r := &Replay{
+		Clock:  NewReplayClock(time.Time{}),
+		src:    src,
+		status: ReplayStatus{Speed: speed},
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	for {
+		q, err := src.Next()
+		if err == nil {
+			r.first = &q
+			r.Clock.Set(time.Unix(0, q.Time*int64(time.Millisecond)))
+			break
+		}
+		if errors.Cause(err) != ErrWrongQuote {
+			r.err = err
+			break
+		}
+	}
+	return r
+}
+
+// Next waits until the next tick is due and returns it. It returns io.EOF after the replay is closed.
+func (r *Replay) Next() (Quote, error) {
+	select {
+	case <-r.closed:
+		return Quote{}, io.EOF
+	default:
+	}
+	var q Quote
+	var err error
+	r.mu.Lock()
+	first, ferr := r.first, r.err
+	r.first, r.err = nil, nil
+	r.mu.Unlock()
+	switch {
+	case first != nil:
+		q = *first
+	case ferr != nil:
+		return q, ferr
+	default:
+		if q, err = r.src.Next(); err != nil {
+			return q, err
+		}
+	}
+	if err = r.wait(q.Time); err != nil {
+		return Quote{}, err
+	}
+	r.mu.Lock()
+	r.status.Time = q.Time
+	r.status.Ticks++
+	r.sent = time.Now()
+	r.mu.Unlock()
+	r.Clock.Set(time.Unix(0, q.Time*int64(time.Millisecond)))
+	return q, nil
+}
+
+// wait blocks until the tick at the time t may be replayed. Changes of the mode are applied at once.
+func (r *Replay) wait(t int64) error {
+	for {
+		var timer <-chan time.Time
+		r.mu.Lock()
+		st := r.status
+		switch {
+		case st.Stepwise && r.permits > 0:
+			r.permits--
+			r.mu.Unlock()
+			return nil
+		case st.Stepwise:
+		case st.Speed <= 0 || st.Ticks == 0:
+			r.mu.Unlock()
+			return nil
+		default:
+			d := time.Duration(float64(t-st.Time)*float64(time.Millisecond)/st.Speed) - time.Since(r.sent)
+			if d <= 0 {
+				r.mu.Unlock()
+				return nil
+			}
+			timer = time.After(d)
+		}
+		r.mu.Unlock()
+		select {
+		case <-timer:
+			return nil
+		case <-r.wake:
+		case <-r.closed:
+			return io.EOF
+		}
+	}
+}
+
+// notify wakes up the waiting Next.
+func (r *Replay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetSpeed leaves the stepwise mode and changes the speed of the replay.
+func (r *Replay) SetSpeed(speed float64) {
+	r.mu.Lock()
+	r.status.Speed, r.status.Stepwise = speed, false
+	r.mu.Unlock()
+	r.notify()
+}
+
+// Pause switches the replay to the stepwise mode.
+func (r *Replay) Pause() {
+	r.mu.Lock()
+	r.status.Stepwise, r.permits = true, 0
+	r.mu.Unlock()
+	r.notify()
+}
+
+// Step releases n ticks in the stepwise mode.
+func (r *Replay) Step(n int) {
+	r.mu.Lock()
+	if r.status.Stepwise && n > 0 {
+		r.permits += n
+	}
+	r.mu.Unlock()
+	r.notify()
+}
+
+// Status returns the progress of the replay.
+func (r *Replay) Status() ReplayStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Close stops the replay and closes the source.
+func (r *Replay) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return r.src.Close()
+}
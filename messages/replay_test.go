@@ -0,0 +1,110 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTickFormats(t *testing.T) {
+	csv := `symbol,bid,ask,time
+EURUSD,1.23450,1.23470,1000
+EURUSD,1.2345
+GBPUSD, 1.5000, 1.5003, 2000
+`
+	src := NewCSVSource(ioutil.NopCloser(strings.NewReader(csv)))
+	var quotes []Quote
+	for {
+		q, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if errors.Cause(err) == ErrWrongQuote {
+			continue
+		} else if err != nil {
+			t.Fatalf("Next error: %v", err)
+		}
+		quotes = append(quotes, q)
+	}
+	if len(quotes) != 2 || quotes[1].Symbol != "GBPUSD" || quotes[1].Ask.String() != "1.5003" {
+		t.Fatalf("Unexpected CSV quotes: %+v", quotes)
+	}
+	// Binary ticks are read as they were written
+	quotes = append(quotes, Quote{"GBPUSD", NewPrice(15001, 4), NewPrice(150035, 5), 1500})
+	buf := &bytes.Buffer{}
+	tw, err := NewTickWriter(buf)
+	if err != nil {
+		t.Fatalf("NewTickWriter error: %v", err)
+	}
+	for _, q := range quotes {
+		if err = tw.Write(q); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+	if err = tw.Flush(); err != nil {
+		t.Fatalf("Flush error: %v", err)
+	}
+	bin, err := NewBinarySource(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("NewBinarySource error: %v", err)
+	}
+	for _, want := range quotes {
+		q, err := bin.Next()
+		if err != nil || q.Symbol != want.Symbol || q.Bid.Cmp(want.Bid) != 0 || q.Ask.Cmp(want.Ask) != 0 || q.Time != want.Time {
+			t.Errorf("Unexpected binary quote: %+v, %v, expected %+v", q, err, want)
+		}
+	}
+	if _, err = bin.Next(); err != io.EOF {
+		t.Errorf("Next expected io.EOF, got %v", err)
+	}
+	if _, err = NewBinarySource(ioutil.NopCloser(strings.NewReader(csv))); err != ErrWrongTicks {
+		t.Errorf("NewBinarySource expected ErrWrongTicks, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	csv := "EURUSD,1.23450,1.23470,1000\nEURUSD,1.23460,1.23480,2000\nEURUSD,1.23470,1.23490,3000\n"
+	r := NewReplay(NewCSVSource(ioutil.NopCloser(strings.NewReader("EURUSD,1.2,x,500\n"+csv))), 0)
+	// The clock starts at the first tick
+	if r.Clock.Now().Unix() != 1 {
+		t.Errorf("Unexpected start of the clock: %v", r.Clock.Now())
+	}
+	r.Pause()
+	done := make(chan Quote)
+	go func() {
+		q, _ := r.Next()
+		done <- q
+	}()
+	select {
+	case q := <-done:
+		t.Fatalf("Paused replay returned %+v", q)
+	case <-time.After(20 * time.Millisecond):
+	}
+	r.Step(1)
+	if q := <-done; q.Time != 1000 || r.Clock.Now().Unix() != 1 {
+		t.Errorf("Unexpected step: %+v at %v", q, r.Clock.Now())
+	}
+	// Accelerated replay waits for the scaled difference of tick times
+	r.SetSpeed(20)
+	start := time.Now()
+	if q, err := r.Next(); err != nil || q.Time != 2000 {
+		t.Errorf("Unexpected quote: %+v, %v", q, err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("Replay hasn't waited: %v", d)
+	}
+	if st := r.Status(); st.Ticks != 2 || st.Time != 2000 || st.Speed != 20 || st.Stepwise {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+	r.Close()
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next after Close expected io.EOF, got %v", err)
+	}
+}
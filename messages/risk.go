@@ -0,0 +1,225 @@
+// risk.go limits the exposure of users in proposals
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMaxPositions is returned when the user has too many open and pending positions.
+	ErrMaxPositions = errors.New("too many open positions")
+	// ErrMaxLots is returned when the lot size of the position is too big.
+	ErrMaxLots = errors.New("lot size exceeds the limit")
+	// ErrMaxExposure is returned when the total lot size of the user's positions of the instrument is too big.
+	ErrMaxExposure = errors.New("exposure to the instrument exceeds the limit")
+	// ErrDailyLoss is returned when the user has lost more than allowed today.
+	ErrDailyLoss = errors.New("daily loss limit is reached")
+)
+
+// RiskRules limits the exposure of every user. Zero values mean no limit.
+// MaxPositions limits the number of open and pending positions, MaxLots limits the lot size
+// of the position and MaxExposure limits the total lot size of positions of the instrument.
+// DailyLoss limits the loss realized since the midnight UTC. MinStopPips is the minimal
+// distance from the proposal price to the stop loss in pips.
+type RiskRules struct {
+	MaxPositions int   `json:"maxpositions"`
+	MaxLots      Price `json:"maxlots"`
+	MaxExposure  Price `json:"maxexposure"`
+	DailyLoss    Price `json:"dailyloss"`
+	MinStopPips  Price `json:"minstoppips"`
+}
+
+// SetRiskRules sets the risk rules of the engine.
+func (e *TCPWSEngine) SetRiskRules(rules RiskRules) {
+	e.accountsmu.Lock()
+	e.risk = rules
+	e.accountsmu.Unlock()
+}
+
+// RiskRules returns the risk rules of the engine.
+func (e *TCPWSEngine) RiskRules() RiskRules {
+	e.accountsmu.Lock()
+	defer e.accountsmu.Unlock()
+	return e.risk
+}
+
+// CheckStop checks the distance from the proposal price to its stop loss.
+// It returns FieldErrors if the stop loss is too close.
+func (r *RiskRules) CheckStop(p *Proposal, pr Precision) error {
+	if r.MinStopPips.Sign() == 0 {
+		return nil
+	}
+	// The loss at the stop loss is the distance
+	dist := Price{}.Sub(p.ProfitPips(pr, p.Price, p.StopLoss))
+	if dist.Cmp(r.MinStopPips) < 0 {
+		return FieldErrors{"stoploss": "must be at least " + r.MinStopPips.String() + " pips away from the price"}
+	}
+	return nil
+}
+
+// dayStart returns the UNIX time of the last midnight UTC before t.
+func dayStart(t int64) int64 {
+	return time.Unix(t, 0).UTC().Truncate(24 * time.Hour).Unix()
+}
+
+// checkLoss checks the daily loss of the user by the positions closed today.
+func (r *RiskRules) checkLoss(positions []Position, now int64) error {
+	if r.DailyLoss.Sign() == 0 {
+		return nil
+	}
+	from := dayStart(now)
+	loss := NewPrice(0, MoneyDigits)
+	for _, pos := range positions {
+		if pos.Finished() && pos.Closed >= from {
+			loss = loss.Sub(pos.Profit)
+		}
+	}
+	if loss.Cmp(r.DailyLoss) >= 0 {
+		return errors.Wrapf(ErrDailyLoss, "%s is lost today", loss)
+	}
+	return nil
+}
+
+// CheckJoin checks the new position against the rules. positions are all positions of the user.
+func (r *RiskRules) CheckJoin(pos Position, positions []Position, now int64) error {
+	if r.MaxLots.Sign() != 0 && pos.Lots.Cmp(r.MaxLots) > 0 {
+		return errors.Wrapf(ErrMaxLots, "%s lots are allowed", r.MaxLots)
+	}
+	active := 0
+	exposure := pos.Lots
+	for _, other := range positions {
+		if other.Finished() {
+			continue
+		}
+		active++
+		if other.Symbol == pos.Symbol {
+			exposure = exposure.Add(other.Lots)
+		}
+	}
+	if r.MaxPositions != 0 && active >= r.MaxPositions {
+		return errors.Wrapf(ErrMaxPositions, "%d positions are allowed", r.MaxPositions)
+	}
+	if r.MaxExposure.Sign() != 0 && exposure.Cmp(r.MaxExposure) > 0 {
+		return errors.Wrapf(ErrMaxExposure, "%s lots of %s are allowed", r.MaxExposure, pos.Symbol)
+	}
+	return r.checkLoss(positions, now)
+}
+
+// checkProposal checks the new proposal of the author against the rules
+// and the trading hours of the instrument.
+func (e *TCPWSEngine) checkProposal(p *Proposal) error {
+	rules := e.RiskRules()
+	pr := DefaultPrecision
+	inst, err := LookupInstrument(e.db, p.Symbol)
+	if err != nil {
+		return err
+	}
+	if inst != nil {
+		if !inst.IsOpen(GameClock.Now()) {
+			return errors.Wrap(ErrMarketClosed, p.Symbol)
+		}
+		pr = inst.Precision()
+	}
+	if err = rules.CheckStop(p, pr); err != nil {
+		return err
+	}
+	if rules.DailyLoss.Sign() == 0 {
+		return nil
+	}
+	positions, err := ListPositions(e.db, p.AuthorID, true)
+	if err != nil {
+		return err
+	}
+	return rules.checkLoss(positions, wallNow())
+}
+
+// Exposure is the total of not finished positions of the user or of all users in the instrument.
+// Lots is the total lot size, NetLots is the lot size of buy positions minus the lot size of sell positions.
+type Exposure struct {
+	UserID    string `json:"userid,omitempty"`
+	Symbol    string `json:"symbol"`
+	Positions int    `json:"positions"`
+	Lots      Price  `json:"lots"`
+	NetLots   Price  `json:"netlots"`
+	Margin    Price  `json:"margin"`
+}
+
+// add adds the position to the exposure.
+func (x *Exposure) add(pos *Position) {
+	x.Positions++
+	x.Lots = x.Lots.Add(pos.Lots)
+	x.Margin = x.Margin.Add(pos.Margin)
+	if pos.IsBuy() {
+		x.NetLots = x.NetLots.Add(pos.Lots)
+	} else {
+		x.NetLots = x.NetLots.Sub(pos.Lots)
+	}
+}
+
+// ExposureReport shows the current exposure of users and instruments.
+type ExposureReport struct {
+	Rules       RiskRules  `json:"rules"`
+	Users       []Exposure `json:"users"`
+	Instruments []Exposure `json:"instruments"`
+}
+
+// ReportExposure sums up all positions which are not finished by users and instruments.
+// Users are sorted by their total lot size from the biggest one, instruments are sorted by symbols.
+func ReportExposure(dbh db.DBHandler, rules RiskRules) (rep ExposureReport, err error) {
+	users := map[string]*Exposure{}
+	insts := map[string]*Exposure{}
+	var jerr error
+	err = dbh.Scan(db.POSITIONS, nil, false, func(key, val []byte) bool {
+		pos := Position{}
+		if jerr = json.Unmarshal(val, &pos); jerr != nil {
+			return false
+		}
+		if pos.Finished() {
+			return true
+		}
+		ukey := pos.UserID + " " + pos.Symbol
+		if users[ukey] == nil {
+			users[ukey] = &Exposure{UserID: pos.UserID, Symbol: pos.Symbol}
+		}
+		if insts[pos.Symbol] == nil {
+			insts[pos.Symbol] = &Exposure{Symbol: pos.Symbol}
+		}
+		users[ukey].add(&pos)
+		insts[pos.Symbol].add(&pos)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	rep.Rules = rules
+	rep.Users = make([]Exposure, 0, len(users))
+	for _, x := range users {
+		rep.Users = append(rep.Users, *x)
+	}
+	sort.Slice(rep.Users, func(i, j int) bool {
+		if c := rep.Users[i].Lots.Cmp(rep.Users[j].Lots); c != 0 {
+			return c > 0
+		}
+		return rep.Users[i].UserID+rep.Users[i].Symbol < rep.Users[j].UserID+rep.Users[j].Symbol
+	})
+	rep.Instruments = make([]Exposure, 0, len(insts))
+	for _, x := range insts {
+		rep.Instruments = append(rep.Instruments, *x)
+	}
+	sort.Slice(rep.Instruments, func(i, j int) bool { return rep.Instruments[i].Symbol < rep.Instruments[j].Symbol })
+	return
+}
+
+// Exposure returns the current exposure of users and instruments with the risk rules.
+func (e *TCPWSEngine) Exposure() (ExposureReport, error) {
+	return ReportExposure(e.db, e.RiskRules())
+}
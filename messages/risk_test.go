@@ -0,0 +1,88 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+func TestRiskRules(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	e.SetRiskRules(RiskRules{MaxPositions: 2, MaxLots: NewPrice(100, 2), MaxExposure: NewPrice(150, 2),
+		DailyLoss: NewPrice(5000, 2), MinStopPips: NewPrice(10, 0)})
+	now := GameClock.Now().Unix()
+	propose := func(price, sl int64) Proposal {
+		p := Proposal{
+			ID: uuid.NewV4().String(), Symbol: DefaultSymbol, Type: BuyStop, State: StatePending,
+			Price: NewPrice(price, 5), StopLoss: NewPrice(sl, 5), TakeProfit: NewPrice(price+500, 5),
+			Created: now, PendingExp: MaxPendingExp, PositionExp: MaxPositionExp,
+			History: []Event{{now, NewPrice(price, 5), StatePending}},
+		}
+		if err := writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+		return p
+	}
+	// The stop loss is checked in pips of the instrument
+	p := propose(123500, 123450)
+	if _, err := e.AddProposal(p); err == nil {
+		t.Errorf("AddProposal expected an error for the close stop loss")
+	} else if fe, ok := err.(FieldErrors); !ok || fe["stoploss"] == "" {
+		t.Errorf("AddProposal expected FieldErrors, got %v", err)
+	}
+	user := uuid.NewV4().String()
+	p1, p2, p3 := propose(123500, 123000), propose(123600, 123000), propose(124500, 123000)
+	if _, err := e.JoinProposal(user, p1.ID, NewPrice(101, 2)); errors.Cause(err) != ErrMaxLots {
+		t.Errorf("JoinProposal expected ErrMaxLots, got %v", err)
+	}
+	if _, err := e.JoinProposal(user, p1.ID, NewPrice(100, 2)); err != nil {
+		t.Fatalf("JoinProposal error: %v", err)
+	}
+	if _, err := e.JoinProposal(user, p2.ID, NewPrice(60, 2)); errors.Cause(err) != ErrMaxExposure {
+		t.Errorf("JoinProposal expected ErrMaxExposure, got %v", err)
+	}
+	if _, err := e.JoinProposal(user, p2.ID, NewPrice(50, 2)); err != nil {
+		t.Fatalf("JoinProposal error: %v", err)
+	}
+	if _, err := e.JoinProposal(user, p3.ID, NewPrice(1, 2)); errors.Cause(err) != ErrMaxPositions {
+		t.Errorf("JoinProposal expected ErrMaxPositions, got %v", err)
+	}
+	rep, err := e.Exposure()
+	if err != nil {
+		t.Fatalf("Exposure error: %v", err)
+	}
+	if len(rep.Users) != 1 || rep.Users[0].Positions != 2 || rep.Users[0].Lots.String() != "1.50" ||
+		len(rep.Instruments) != 1 || rep.Instruments[0].NetLots.String() != "1.50" {
+		t.Errorf("Unexpected exposure: %+v", rep)
+	}
+	// Stop losses of both positions exceed the daily loss
+	quote := func(bid int64, sec int64) {
+		if err := e.PublishQuote(Quote{DefaultSymbol, NewPrice(bid, 5), NewPrice(bid, 5), sec * 1000}); err != nil {
+			t.Fatalf("PublishQuote error: %v", err)
+		}
+	}
+	quote(123700, now)
+	quote(122900, now+1)
+	if rep, _ = e.Exposure(); len(rep.Users) != 0 {
+		t.Errorf("Unexpected exposure after stop loss: %+v", rep)
+	}
+	if _, err = e.JoinProposal(user, p3.ID, NewPrice(1, 2)); errors.Cause(err) != ErrDailyLoss {
+		t.Errorf("JoinProposal expected ErrDailyLoss, got %v", err)
+	}
+	p = propose(123500, 123000)
+	p.AuthorID = user
+	if _, err = e.AddProposal(p); errors.Cause(err) != ErrDailyLoss {
+		t.Errorf("AddProposal expected ErrDailyLoss, got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+// rooms.go introduces chat rooms with their own bucket chains and members
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Kinds of chat rooms
+const (
+	RoomGeneral    = "general"
+	RoomInstrument = "instrument"
+	RoomProposal   = "proposal"
+)
+
+// GeneralRoom is the ID of the general chat room.
+// Its bucket chain starts from db.LastCB.
+const GeneralRoom = RoomGeneral
+
+// ChatBucketSize is the maximal number of messages in a single chat bucket.
+const ChatBucketSize = 100
+
+var (
+	// ErrWrongRoom is returned for the malformed room IDs.
+	ErrWrongRoom = errors.New("wrong room id")
+	// ErrNotMember is returned when the user acts in the room without joining it.
+	ErrNotMember = errors.New("user is not a member of the room")
+	// symbolRegexp matches instrument symbols.
+	symbolRegexp = regexp.MustCompile(`^[A-Z0-9]{1,12}$`)
+)
+
+// Room is a chat room which is stored in ROOMS database.
+// ID of the room looks like "general", "instrument:EURUSD" or "proposal:<proposal id>".
+type Room struct {
+	ID      string   `json:"id"`
+	Kind    string   `json:"kind"`
+	Members []string `json:"members"`
+}
+
+// InstrumentRoom returns the ID of the room of the instrument symbol.
+func InstrumentRoom(symbol string) string {
+	return RoomInstrument + ":" + symbol
+}
+
+// ProposalRoom returns the ID of the discussion room of the proposal.
+func ProposalRoom(propID string) string {
+	return RoomProposal + ":" + propID
+}
+
+// ParseRoomID checks the room ID and splits it into the kind and the subject.
+func ParseRoomID(id string) (kind, subject string, err error) {
+	if id == GeneralRoom {
+		return RoomGeneral, "", nil
+	}
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) != 2 {
+		return "", "", ErrWrongRoom
+	}
+	kind, subject = parts[0], parts[1]
+	switch kind {
+	case RoomInstrument:
+		if !symbolRegexp.MatchString(subject) {
+			err = ErrWrongRoom
+		}
+	case RoomProposal:
+		if _, perr := uuid.FromString(subject); perr != nil {
+			err = ErrWrongRoom
+		}
+	default:
+		err = ErrWrongRoom
+	}
+	return
+}
+
+// IsMember checks whether the user has joined the room.
+func (r *Room) IsMember(userID string) bool {
+	for _, m := range r.Members {
+		if m == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// lastBucketKey returns the key pointer to the last chat bucket ID of the room.
+func lastBucketKey(room string) []byte {
+	if room == GeneralRoom {
+		return db.LastCB
+	}
+	return join(db.LastCB, []byte(room))
+}
+
+// ReadRoom reads the room with the given ID.
+func ReadRoom(dbh db.DBHandler, id string) (r Room, err error) {
+	var data []byte
+	if data, err = dbh.Read(db.ROOMS, []byte(id)); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &r)
+	return
+}
+
+// ListRooms returns all chat rooms in the order of their IDs.
+func ListRooms(dbh db.DBHandler) (rooms []Room, err error) {
+	rooms = []Room{}
+	var jerr error
+	err = dbh.Scan(db.ROOMS, nil, false, func(key, val []byte) bool {
+		r := Room{}
+		if jerr = json.Unmarshal(val, &r); jerr != nil {
+			return false
+		}
+		rooms = append(rooms, r)
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	return
+}
+
+// roomMembership is a Modifier which adds or removes the member of the room.
+type roomMembership struct {
+	UserID string
+	Join   bool
+	Result Room
+}
+
+// Apply changes the member list of the marshalled room.
+func (m *roomMembership) Apply(data []byte) ([]byte, error) {
+	if err := json.Unmarshal(data, &m.Result); err != nil {
+		return nil, err
+	}
+	members := []string{}
+	for _, id := range m.Result.Members {
+		if id != m.UserID {
+			members = append(members, id)
+		}
+	}
+	if m.Join {
+		members = append(members, m.UserID)
+	}
+	m.Result.Members = members
+	return json.Marshal(m.Result)
+}
+
+// JoinRoom adds the user to the room. The room is created if it doesn't exist.
+// Discussion and instrument rooms can be created only for existing proposals and instruments.
+func (e *TCPWSEngine) JoinRoom(room, userID string) (Room, error) {
+	kind, subject, err := ParseRoomID(room)
+	if err != nil {
+		return Room{}, err
+	}
+	if err = checkSanctions(e.db, userID, room, wallNow(), ActBan); err != nil {
+		return Room{}, err
+	}
+	e.roomsmu.Lock()
+	defer e.roomsmu.Unlock()
+	_, err = ReadRoom(e.db, room)
+	if err != nil && !db.IsNotFound(err) {
+		return Room{}, err
+	} else if err != nil {
+		// Create the room
+		switch kind {
+		case RoomProposal:
+			if _, err = e.readProposal(uuid.FromStringOrNil(subject)); err != nil {
+				return Room{}, errors.Wrap(err, "cannot read the proposal")
+			}
+		case RoomInstrument:
+			if _, err = ReadInstrument(e.db, subject); err != nil {
+				return Room{}, errors.Wrap(err, "cannot read the instrument")
+			}
+		}
+		data, _ := json.Marshal(Room{ID: room, Kind: kind, Members: []string{}})
+		if err = e.db.Write(db.ROOMS, []byte(room), data); err != nil {
+			return Room{}, err
+		}
+	}
+	m := &roomMembership{UserID: userID, Join: true}
+	err = e.db.Modify(db.ROOMS, []byte(room), m)
+	return m.Result, err
+}
+
+// LeaveRoom removes the user from the room.
+func (e *TCPWSEngine) LeaveRoom(room, userID string) error {
+	e.roomsmu.Lock()
+	defer e.roomsmu.Unlock()
+	return e.db.Modify(db.ROOMS, []byte(room), &roomMembership{UserID: userID})
+}
+
+// bucketAppend is a Modifier which appends the message to the chat bucket.
+// Full is set if the bucket can't accept more messages.
+type bucketAppend struct {
+	Msg  ChatMessage
+	Full bool
+}
+
+// Apply appends the message to the marshalled chat bucket.
+func (a *bucketAppend) Apply(data []byte) ([]byte, error) {
+	cb := ChatBucket{}
+	if err := json.Unmarshal(data, &cb); err != nil {
+		return nil, err
+	}
+	if len(cb.Data) >= ChatBucketSize {
+		a.Full = true
+		return data, nil
+	}
+	cb.Data = append(cb.Data, a.Msg)
+	return json.Marshal(cb)
+}
+
+// PostChat appends the message m to the last chat bucket of the room.
+// A new bucket is started when the last one is full.
+// The message is sent to the members of the room as type 3 message, mentioned users are notified.
+// Only members of the room are mentioned, so the message is not disclosed to other users.
+func (e *TCPWSEngine) PostChat(room string, m ChatMessage) error {
+	r, err := ReadRoom(e.db, room)
+	if err != nil {
+		return errors.Wrap(err, "cannot read the room")
+	}
+	if !r.IsMember(m.AuthorID) {
+		return ErrNotMember
+	}
+	if err = checkSanctions(e.db, m.AuthorID, room, wallNow(), ActBan, ActMute); err != nil {
+		return err
+	}
+	m.Room = room
+	if err = e.prepareChat(&m); err != nil {
+		return err
+	}
+	var mentions []string
+	for _, u := range m.Mentions {
+		if r.IsMember(u) {
+			mentions = append(mentions, u)
+		}
+	}
+	m.Mentions = mentions
+	e.chatmu.Lock()
+	defer e.chatmu.Unlock()
+	m.Text = FilterText(m.Text, e.filter)
+	if err = e.appendChat(room, m); err != nil {
+		return err
+	}
+	e.SendTo(r.Members, Message{MsgAddChat, m})
+	return e.alertMentions(m)
+}
+
+// appendChat stores the message m in the bucket chain of the room.
+func (e *TCPWSEngine) appendChat(room string, m ChatMessage) error {
+	key := lastBucketKey(room)
+	var prev *string
+	last, err := e.db.Read(db.CHAT, key)
+	if err != nil && !db.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		a := &bucketAppend{Msg: m}
+		if err = e.db.Modify(db.CHAT, last, a); err != nil {
+			return err
+		}
+		if !a.Full {
+			return nil
+		}
+		id := uuid.FromBytesOrNil(last).String()
+		prev = &id
+	}
+	// Start a new bucket
+	id := uuid.NewV4()
+	var data []byte
+	data, err = json.Marshal(ChatBucket{prev, []ChatMessage{m}})
+	if err != nil {
+		return err
+	}
+	if err = e.db.Write(db.CHAT, id.Bytes(), data); err != nil {
+		return err
+	}
+	return e.db.Write(db.CHAT, key, id.Bytes())
+}
@@ -0,0 +1,127 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"bytes"
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestParseRoomID(t *testing.T) {
+	for _, c := range []struct {
+		id   string
+		kind string
+		ok   bool
+	}{
+		{GeneralRoom, RoomGeneral, true},
+		{InstrumentRoom(DefaultSymbol), RoomInstrument, true},
+		{ProposalRoom(uuid.NewV4().String()), RoomProposal, true},
+		{"instrument:eurusd", "", false},
+		{"proposal:1", "", false},
+		{"dm:a:b", "", false},
+		{"lobby", "", false},
+	} {
+		kind, _, err := ParseRoomID(c.id)
+		if (err == nil) != c.ok || c.ok && kind != c.kind {
+			t.Errorf("ParseRoomID(%q) = %s, %v", c.id, kind, err)
+		}
+	}
+}
+
+func TestRooms(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	a, b := uuid.NewV4().String(), uuid.NewV4().String()
+	room := InstrumentRoom(DefaultSymbol)
+	// Rooms are created only for known instruments and proposals
+	if _, err := e.JoinRoom(InstrumentRoom("XXX"), a); !db.IsNotFound(err) {
+		t.Errorf("JoinRoom expected not found error for the unknown instrument, got %v", err)
+	}
+	if _, err := e.JoinRoom(ProposalRoom(uuid.NewV4().String()), a); !db.IsNotFound(err) {
+		t.Errorf("JoinRoom expected not found error for the unknown proposal, got %v", err)
+	}
+	if _, err := e.JoinRoom("lobby", a); err != ErrWrongRoom {
+		t.Errorf("JoinRoom expected ErrWrongRoom, got %v", err)
+	}
+	// The first member creates the room, repeated joins don't duplicate members
+	r, err := e.JoinRoom(room, a)
+	if err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	if r.Kind != RoomInstrument || len(r.Members) != 1 {
+		t.Errorf("Unexpected room: %+v", r)
+	}
+	if _, err = e.JoinRoom(room, a); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	if r, err = e.JoinRoom(room, b); err != nil || len(r.Members) != 2 {
+		t.Errorf("Unexpected room: %+v, %v", r, err)
+	}
+	// Only members can post
+	if err = e.PostChat(room, ChatMessage{AuthorID: a, Text: "first"}); err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	if err = e.LeaveRoom(room, b); err != nil {
+		t.Fatalf("LeaveRoom error: %v", err)
+	}
+	if err = e.PostChat(room, ChatMessage{AuthorID: b, Text: "second"}); err != ErrNotMember {
+		t.Errorf("PostChat expected ErrNotMember, got %v", err)
+	}
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: a, Text: "general"}); !db.IsNotFound(err) {
+		t.Errorf("PostChat expected not found error for the room without members, got %v", err)
+	}
+	if r, err = ReadRoom(dbh, room); err != nil || len(r.Members) != 1 || !r.IsMember(a) || r.IsMember(b) {
+		t.Errorf("Unexpected room after leaving: %+v, %v", r, err)
+	}
+	if rooms, _ := ListRooms(dbh); len(rooms) != 1 || rooms[0].ID != room {
+		t.Errorf("Unexpected rooms: %+v", rooms)
+	}
+	// Every room has its own bucket chain
+	if _, err = e.JoinRoom(GeneralRoom, b); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	for i := 0; i < ChatBucketSize; i++ {
+		if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: b, Text: "general"}); err != nil {
+			t.Fatalf("PostChat error: %v", err)
+		}
+	}
+	if bytes.Equal(lastBucketKey(GeneralRoom), lastBucketKey(room)) || !bytes.Equal(lastBucketKey(GeneralRoom), db.LastCB) {
+		t.Errorf("Unexpected keys of the last buckets")
+	}
+	last, err := dbh.Read(db.CHAT, lastBucketKey(room))
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	cb, _ := readChatBucket(dbh, uuid.FromBytesOrNil(last))
+	if len(cb.Data) != 1 || cb.Data[0].Text != "first" || cb.Previous != nil {
+		t.Errorf("Unexpected bucket of the instrument room: %+v", cb)
+	}
+	if last, err = dbh.Read(db.CHAT, lastBucketKey(GeneralRoom)); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	cb, _ = readChatBucket(dbh, uuid.FromBytesOrNil(last))
+	if len(cb.Data) != ChatBucketSize || cb.Previous != nil {
+		t.Errorf("Unexpected bucket of the general room: %d messages, previous %v", len(cb.Data), cb.Previous)
+	}
+	// The full bucket is chained to the new one
+	if err = e.PostChat(GeneralRoom, ChatMessage{AuthorID: b, Text: "next"}); err != nil {
+		t.Fatalf("PostChat error: %v", err)
+	}
+	prev := uuid.FromBytesOrNil(last).String()
+	if last, err = dbh.Read(db.CHAT, lastBucketKey(GeneralRoom)); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	cb, _ = readChatBucket(dbh, uuid.FromBytesOrNil(last))
+	if len(cb.Data) != 1 || cb.Previous == nil || *cb.Previous != prev {
+		t.Errorf("Unexpected chained bucket: %+v", cb)
+	}
+}
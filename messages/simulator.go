@@ -0,0 +1,139 @@
+// simulator.go introduces the market simulator which drives prices in the development mode
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// secondsPerYear is the time unit of the simulated drift and volatility.
+const secondsPerYear = 365 * 24 * 60 * 60
+
+// SimInstrument describes the simulated prices of the instrument.
+// Bid prices follow the geometric Brownian motion which starts from Start and keeps its scale.
+// Drift and Volatility are annual, e.g. Volatility 0.1 moves EURUSD by about 10% a year.
+// Ask prices are Spread above bid prices.
+type SimInstrument struct {
+	Symbol     string
+	Start      Price
+	Spread     Price
+	Drift      float64
+	Volatility float64
+}
+
+// ParseSimInstrument parses the simulated instrument "SYMBOL:START[:SPREAD[:VOLATILITY]]"
+// like "EURUSD:1.10000:0.00020:0.2". Missing spread and volatility are zero.
+func ParseSimInstrument(s string) (si SimInstrument, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return si, errors.Errorf("simulated instrument %q must look like SYMBOL:START[:SPREAD[:VOLATILITY]]", s)
+	}
+	si.Symbol = parts[0]
+	if si.Start, err = ParsePrice(parts[1]); err != nil {
+		return
+	}
+	if len(parts) > 2 {
+		if si.Spread, err = ParsePrice(parts[2]); err != nil {
+			return
+		}
+	}
+	if len(parts) > 3 {
+		if si.Volatility, err = strconv.ParseFloat(parts[3], 64); err != nil {
+			return si, errors.Wrapf(err, "wrong volatility of %s", si.Symbol)
+		}
+	}
+	return
+}
+
+// simState is the current price of the simulated instrument.
+type simState struct {
+	SimInstrument
+	value float64
+}
+
+// Simulator is a FeedSource which generates quotes of the instruments one by one.
+// Every Interval of the simulated time each instrument gets a new quote.
+// The simulated time starts at the given moment. If Realtime is set Next waits for Interval
+// before the next round of quotes, otherwise quotes are generated immediately.
+type Simulator struct {
+	Interval time.Duration
+	Realtime bool
+
+	rnd         *rand.Rand
+	instruments []simState
+	next        int
+	time        int64
+	closed      chan struct{}
+	once        sync.Once
+}
+
+// NewSimulator returns the simulator of the instruments with the random seed.
+// The same seed produces the same quotes.
+func NewSimulator(seed int64, interval time.Duration, start time.Time, instruments ...SimInstrument) (*Simulator, error) {
+	if interval <= 0 {
+		return nil, errors.New("simulator interval must be positive")
+	}
+	s := &Simulator{
+		Interval: interval,
+		rnd:      rand.New(rand.NewSource(seed)),
+		time:     start.UnixNano() / int64(time.Millisecond),
+		closed:   make(chan struct{}),
+	}
+	for _, si := range instruments {
+		if si.Start.Sign() <= 0 || si.Spread.Sign() < 0 || si.Volatility < 0 {
+			return nil, errors.Errorf("simulated instrument %s must have positive start, non-negative spread and volatility", si.Symbol)
+		}
+		s.instruments = append(s.instruments, simState{si, si.Start.Float64()})
+	}
+	if len(s.instruments) == 0 {
+		return nil, errors.New("simulator has no instruments")
+	}
+	return s, nil
+}
+
+// Next returns the next quote. It returns io.EOF after the simulator is closed.
+func (s *Simulator) Next() (Quote, error) {
+	if s.next == len(s.instruments) {
+		s.next = 0
+		s.time += int64(s.Interval / time.Millisecond)
+		if s.Realtime {
+			select {
+			case <-time.After(s.Interval):
+			case <-s.closed:
+			}
+		}
+	}
+	select {
+	case <-s.closed:
+		return Quote{}, io.EOF
+	default:
+	}
+	st := &s.instruments[s.next]
+	s.next++
+	// The price moves by exp((mu - sigma^2/2)dt + sigma*sqrt(dt)*Z) with the standard normal Z
+	dt := s.Interval.Seconds() / secondsPerYear
+	sigma := st.Volatility
+	st.value *= math.Exp((st.Drift-sigma*sigma/2)*dt + sigma*math.Sqrt(dt)*s.rnd.NormFloat64())
+	scale := st.Start.Scale
+	bid := NewPrice(int64(math.Floor(st.value*float64(pow10[scale])+0.5)), scale)
+	if bid.Sign() <= 0 {
+		bid = NewPrice(1, scale)
+	}
+	return Quote{Symbol: st.Symbol, Bid: bid, Ask: bid.Add(st.Spread), Time: s.time}, nil
+}
+
+// Close stops the simulator.
+func (s *Simulator) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
@@ -0,0 +1,115 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestProposalNext(t *testing.T) {
+	quote := func(bid int64, sec int64) Quote {
+		return Quote{DefaultSymbol, NewPrice(bid, 5), NewPrice(bid+2, 5), sec * 1000}
+	}
+	p := Proposal{
+		Type: BuyStop, State: StatePending, Price: NewPrice(123500, 5),
+		StopLoss: NewPrice(123300, 5), TakeProfit: NewPrice(123800, 5),
+		Created: 1000, PendingExp: 900, PositionExp: 3600,
+		History: []Event{{1000, NewPrice(123500, 5), StatePending}},
+	}
+	steps := []struct {
+		q     Quote
+		ok    bool
+		state byte
+		value string
+	}{
+		{quote(123400, 1100), false, StatePending, ""},
+		{quote(123500, 1200), true, StatePosition, "1.23502"},
+		{quote(123700, 1300), false, StatePosition, ""},
+		{quote(123290, 1400), true, StateStopLoss, "1.23290"},
+	}
+	for i, s := range steps {
+		ev, ok := p.Next(s.q)
+		if ok != s.ok || (ok && (ev.State != s.state || ev.Value.String() != s.value)) {
+			t.Fatalf("Step %d: unexpected event %+v, %v", i, ev, ok)
+		}
+		if ok {
+			p.State = ev.State
+			p.History = append(p.History, ev)
+		}
+	}
+	// Sell orders are closed by the ask price
+	p.Type, p.State = SellLimit, StatePosition
+	p.StopLoss, p.TakeProfit = NewPrice(123700, 5), NewPrice(123200, 5)
+	if ev, ok := p.Next(quote(123198, 1500)); !ok || ev.State != StateTakeProfit || ev.Value.String() != "1.23200" {
+		t.Errorf("Unexpected take profit: %+v, %v", ev, ok)
+	}
+	// Expirations
+	if ev, ok := p.Next(quote(123500, 1400+3601)); !ok || ev.State != StateExpiredPosition {
+		t.Errorf("Unexpected position expiration: %+v, %v", ev, ok)
+	}
+	p = Proposal{State: StatePending, Created: 1000, PendingExp: 900, Price: NewPrice(2, 0)}
+	if ev, ok := p.Next(quote(100000, 1901)); !ok || ev.State != StateExpiredPending {
+		t.Errorf("Unexpected pending expiration: %+v, %v", ev, ok)
+	}
+	p = Proposal{State: StateProposal, Deadline: 2000}
+	if ev, ok := p.Next(quote(100000, 2001)); !ok || ev.State != StateExpiredProposal {
+		t.Errorf("Unexpected proposal expiration: %+v, %v", ev, ok)
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	si, err := ParseSimInstrument("EURUSD:1.23450:0.00020:0.5")
+	if err != nil {
+		t.Fatalf("ParseSimInstrument error: %v", err)
+	}
+	start := time.Unix(1000, 0)
+	sim, err := NewSimulator(42, time.Second, start, si)
+	if err != nil {
+		t.Fatalf("NewSimulator error: %v", err)
+	}
+	// The same seed gives the same prices
+	other, _ := NewSimulator(42, time.Second, start, si)
+	for i := 0; i < 10; i++ {
+		q1, _ := sim.Next()
+		q2, _ := other.Next()
+		if q1 != q2 || q1.Time != 1000000+int64(i)*1000 || q1.Ask.Sub(q1.Bid).String() != "0.00020" {
+			t.Fatalf("Unexpected quotes: %+v, %+v", q1, q2)
+		}
+	}
+	// The pending order is filled by simulated prices
+	e := MakeTCPWSEngine(dbh, nil)
+	q, _ := sim.Next()
+	p := Proposal{
+		ID: uuid.NewV4().String(), Symbol: DefaultSymbol, Type: BuyLimit, State: StatePending,
+		Price: q.Ask.Add(NewPrice(1, 3)), StopLoss: NewPrice(1, 5), TakeProfit: NewPrice(9, 0),
+		Created: 1000, PendingExp: MaxPendingExp, PositionExp: MaxPositionExp,
+	}
+	if err = writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+		t.Fatalf("writeJSON error: %v", err)
+	}
+	sim.Close()
+	if _, err = sim.Next(); err != io.EOF {
+		t.Errorf("Next after Close expected io.EOF, got %v", err)
+	}
+	if err = e.PublishQuote(q); err != nil {
+		t.Fatalf("PublishQuote error: %v", err)
+	}
+	if err = readJSON(dbh, db.PROPOSALS, idBytes(p.ID), &p); err != nil {
+		t.Fatalf("readJSON error: %v", err)
+	}
+	if p.State != StatePosition || len(p.History) != 1 || p.History[0].Value != q.Ask {
+		t.Errorf("Unexpected proposal: %+v", p)
+	}
+}
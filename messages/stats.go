@@ -0,0 +1,339 @@
+// stats.go computes performance statistics of proposal authors and traders
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+)
+
+// Roles of users in statistics
+const (
+	// RoleAuthor is the author of proposals. Trades are positions of the proposals.
+	RoleAuthor = "author"
+	// RoleTrader is the user who joins proposals. Trades are positions of the user.
+	RoleTrader = "trader"
+)
+
+// ErrWrongRole is returned for unknown roles of statistics.
+var ErrWrongRole = errors.New("role must be author or trader")
+
+// TypeNames are the names of proposal types used in statistics.
+var TypeNames = []string{"buystop", "buylimit", "sellstop", "selllimit"}
+
+// trade is the closed position in pips.
+type trade struct {
+	Type       byte
+	Pips       Price
+	Profit     Price
+	RiskReward float64
+	Hold       int64
+	Closed     int64
+}
+
+// closedTrade returns the trade of the proposal position and the index of the event which has closed it.
+// Repeated closing events are ignored.
+func (p *Proposal) closedTrade(pr Precision) (tr trade, at int, ok bool) {
+	for i := 1; i < len(p.History); i++ {
+		open, exit := p.History[i-1], p.History[i]
+		if open.State != StatePosition {
+			continue
+		}
+		switch exit.State {
+		case StateStopLoss, StateTakeProfit, StateExpiredPosition:
+			tr = trade{Type: p.Type, Pips: p.ProfitPips(pr, open.Value, exit.Value),
+				Hold: exit.Time - open.Time, Closed: exit.Time}
+			tr.RiskReward = p.riskReward(pr, open.Value)
+			return tr, i, true
+		}
+	}
+	return
+}
+
+// riskReward returns the planned ratio of the reward to the risk of the position opened at entry.
+// It is zero if the stop loss doesn't limit the loss.
+func (p *Proposal) riskReward(pr Precision, entry Price) float64 {
+	risk := -p.ProfitPips(pr, entry, p.StopLoss).Float64()
+	if risk <= 0 {
+		return 0
+	}
+	return p.ProfitPips(pr, entry, p.TakeProfit).Float64() / risk
+}
+
+// average returns sum / n with 2 decimal digits.
+func average(sum Price, n int) Price {
+	if n == 0 {
+		return NewPrice(0, 2)
+	}
+	sum = sum.Round(2)
+	return Price{sum.Units * 10 / int64(n), 3}.Round(2)
+}
+
+// TypeStats is the performance of proposals of one type.
+type TypeStats struct {
+	Trades     int   `json:"trades"`
+	Wins       int   `json:"wins"`
+	Pips       Price `json:"pips"`
+	WinRate    Price `json:"winrate"`
+	Expectancy Price `json:"expectancy"`
+}
+
+// Stats is the performance of the author or the trader stored in STATS database.
+// Results are measured in pips of instruments, Profit is the money realized by the trader.
+// GrossLoss is positive. MaxDrawdown is the biggest fall of cumulative pips from their peak.
+// Sums are stored to update the statistics by every closed trade,
+// averages are calculated from them: WinRate is in percent, Expectancy is average pips per trade,
+// AvgRiskReward is the average planned ratio of take profit to stop loss, AvgHold is in seconds.
+type Stats struct {
+	UserID        string                `json:"userid"`
+	Role          string                `json:"role"`
+	Trades        int                   `json:"trades"`
+	Wins          int                   `json:"wins"`
+	Losses        int                   `json:"losses"`
+	Pips          Price                 `json:"pips"`
+	GrossProfit   Price                 `json:"grossprofit"`
+	GrossLoss     Price                 `json:"grossloss"`
+	Profit        Price                 `json:"profit"`
+	Peak          Price                 `json:"peak"`
+	MaxDrawdown   Price                 `json:"maxdrawdown"`
+	RiskRewardSum float64               `json:"riskrewardsum"`
+	RiskRewards   int                   `json:"riskrewards"`
+	HoldSum       int64                 `json:"holdsum"`
+	WinRate       Price                 `json:"winrate"`
+	Expectancy    Price                 `json:"expectancy"`
+	AvgWin        Price                 `json:"avgwin"`
+	AvgLoss       Price                 `json:"avgloss"`
+	AvgRiskReward float64               `json:"avgriskreward"`
+	AvgHold       int64                 `json:"avghold"`
+	ByType        map[string]*TypeStats `json:"bytype"`
+	Updated       int64                 `json:"updated"`
+}
+
+// statsKey returns the key of the statistics in STATS database: role + 0 + user ID.
+func statsKey(role, userID string) []byte {
+	return join([]byte(role), []byte{0}, idBytes(userID))
+}
+
+// newStats returns the empty statistics of the user.
+func newStats(role, userID string) Stats {
+	zero := NewPrice(0, 0)
+	st := Stats{UserID: userID, Role: role, Pips: zero, GrossProfit: zero, GrossLoss: zero,
+		Profit: NewPrice(0, MoneyDigits), Peak: zero, MaxDrawdown: zero, ByType: map[string]*TypeStats{}}
+	st.average()
+	return st
+}
+
+// add counts the closed trade.
+func (st *Stats) add(tr trade) {
+	st.Trades++
+	st.Pips = st.Pips.Add(tr.Pips)
+	st.Profit = st.Profit.Add(tr.Profit)
+	switch tr.Pips.Sign() {
+	case 1:
+		st.Wins++
+		st.GrossProfit = st.GrossProfit.Add(tr.Pips)
+	case -1:
+		st.Losses++
+		st.GrossLoss = st.GrossLoss.Sub(tr.Pips)
+	}
+	if st.Pips.Cmp(st.Peak) > 0 {
+		st.Peak = st.Pips
+	}
+	if dd := st.Peak.Sub(st.Pips); dd.Cmp(st.MaxDrawdown) > 0 {
+		st.MaxDrawdown = dd
+	}
+	if tr.RiskReward > 0 {
+		st.RiskRewardSum += tr.RiskReward
+		st.RiskRewards++
+	}
+	st.HoldSum += tr.Hold
+	if int(tr.Type) < len(TypeNames) {
+		name := TypeNames[tr.Type]
+		ts := st.ByType[name]
+		if ts == nil {
+			ts = &TypeStats{Pips: NewPrice(0, 0)}
+			st.ByType[name] = ts
+		}
+		ts.Trades++
+		ts.Pips = ts.Pips.Add(tr.Pips)
+		if tr.Pips.Sign() > 0 {
+			ts.Wins++
+		}
+		ts.WinRate = NewPrice(int64(ts.Wins)*10000/int64(ts.Trades), 2)
+		ts.Expectancy = average(ts.Pips, ts.Trades)
+	}
+	if tr.Closed > st.Updated {
+		st.Updated = tr.Closed
+	}
+	st.average()
+}
+
+// average calculates averages from sums.
+func (st *Stats) average() {
+	st.WinRate = NewPrice(0, 2)
+	if st.Trades > 0 {
+		st.WinRate = NewPrice(int64(st.Wins)*10000/int64(st.Trades), 2)
+		st.AvgHold = st.HoldSum / int64(st.Trades)
+	}
+	st.Expectancy = average(st.Pips, st.Trades)
+	st.AvgWin = average(st.GrossProfit, st.Wins)
+	st.AvgLoss = average(st.GrossLoss, st.Losses)
+	if st.RiskRewards > 0 {
+		st.AvgRiskReward = math.Round(st.RiskRewardSum/float64(st.RiskRewards)*100) / 100
+	}
+}
+
+// ReadStats returns the statistics of the user in the role. The user without closed trades gets empty statistics.
+func ReadStats(dbh db.DBHandler, role, userID string) (st Stats, err error) {
+	if role != RoleAuthor && role != RoleTrader {
+		return st, ErrWrongRole
+	}
+	err = readJSON(dbh, db.STATS, statsKey(role, userID), &st)
+	if db.IsNotFound(err) {
+		return newStats(role, userID), nil
+	}
+	return
+}
+
+// addTrade updates the statistics of the user in the role by the trade.
+func addTrade(dbh db.DBHandler, role, userID string, tr trade) error {
+	st, err := ReadStats(dbh, role, userID)
+	if err != nil {
+		return err
+	}
+	st.add(tr)
+	return writeJSON(dbh, db.STATS, statsKey(role, userID), st)
+}
+
+// precisionOf returns the precision of the instrument or DefaultPrecision if it is not in the catalogue.
+func precisionOf(dbh db.DBHandler, symbol string) (Precision, error) {
+	inst, err := LookupInstrument(dbh, symbol)
+	if err != nil || inst == nil {
+		return DefaultPrecision, err
+	}
+	return inst.Precision(), nil
+}
+
+// positionTrade returns the trade of the closed position of the proposal.
+func positionTrade(p *Proposal, pos *Position, pr Precision) trade {
+	return trade{Type: pos.Type, Pips: p.ProfitPips(pr, pos.EntryPrice, pos.ExitPrice), Profit: pos.Profit,
+		RiskReward: p.riskReward(pr, pos.EntryPrice), Hold: pos.Closed - pos.Opened, Closed: pos.Closed}
+}
+
+// recordStats updates the statistics of the author of the proposal which position has been closed
+// and of the traders whose positions have been closed with it. It must be called under accountsmu.
+func (e *TCPWSEngine) recordStats(p Proposal, closed []Position) error {
+	pr, err := precisionOf(e.db, p.Symbol)
+	if err != nil {
+		return err
+	}
+	// The trade is counted by the event which has closed it
+	if tr, at, ok := p.closedTrade(pr); ok && at == len(p.History)-1 && p.AuthorID != "" {
+		if err = addTrade(e.db, RoleAuthor, p.AuthorID, tr); err != nil {
+			return err
+		}
+	}
+	for i := range closed {
+		if err = addTrade(e.db, RoleTrader, closed[i].UserID, positionTrade(&p, &closed[i], pr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildStats recomputes the statistics from the history of all proposals and positions
+// when STATS database is empty. It returns the number of counted trades.
+func RebuildStats(dbh db.DBHandler) (int, error) {
+	empty := true
+	if err := dbh.Scan(db.STATS, nil, false, func(key, val []byte) bool {
+		empty = false
+		return false
+	}); err != nil || !empty {
+		return 0, err
+	}
+	type userTrade struct {
+		role, userID string
+		trade
+	}
+	var trades []userTrade
+	proposals := map[string]*Proposal{}
+	var jerr error
+	err := dbh.Scan(db.PROPOSALS, nil, false, func(key, val []byte) bool {
+		p := &Proposal{}
+		if jerr = json.Unmarshal(val, p); jerr != nil {
+			return false
+		}
+		proposals[p.ID] = p
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	precisions := map[string]Precision{}
+	precision := func(symbol string) (pr Precision, err error) {
+		pr, ok := precisions[symbol]
+		if !ok {
+			pr, err = precisionOf(dbh, symbol)
+			precisions[symbol] = pr
+		}
+		return
+	}
+	for _, p := range proposals {
+		pr, err := precision(p.Symbol)
+		if err != nil {
+			return 0, err
+		}
+		if tr, _, ok := p.closedTrade(pr); ok && p.AuthorID != "" {
+			trades = append(trades, userTrade{RoleAuthor, p.AuthorID, tr})
+		}
+	}
+	err = dbh.Scan(db.POSITIONS, nil, false, func(key, val []byte) bool {
+		pos := Position{}
+		if jerr = json.Unmarshal(val, &pos); jerr != nil {
+			return false
+		}
+		p := proposals[pos.ProposalID]
+		if p == nil || pos.Opened == 0 || !pos.Finished() {
+			return true
+		}
+		var pr Precision
+		if pr, jerr = precision(p.Symbol); jerr != nil {
+			return false
+		}
+		trades = append(trades, userTrade{RoleTrader, pos.UserID, positionTrade(p, &pos, pr)})
+		return true
+	})
+	if err == nil {
+		err = jerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Drawdowns depend on the order of trades
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Closed < trades[j].Closed })
+	stats := map[string]*Stats{}
+	for _, ut := range trades {
+		key := string(statsKey(ut.role, ut.userID))
+		if stats[key] == nil {
+			st := newStats(ut.role, ut.userID)
+			stats[key] = &st
+		}
+		stats[key].add(ut.trade)
+	}
+	for key, st := range stats {
+		if err = writeJSON(dbh, db.STATS, []byte(key), st); err != nil {
+			return 0, err
+		}
+	}
+	return len(trades), nil
+}
@@ -0,0 +1,86 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestStats(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	if err := SaveInstrument(dbh, DefaultInstrument); err != nil {
+		t.Fatalf("SaveInstrument error: %v", err)
+	}
+	e := MakeTCPWSEngine(dbh, nil)
+	author, trader := uuid.NewV4().String(), uuid.NewV4().String()
+	propose := func(typ byte, price, sl, tp int64) Proposal {
+		p := Proposal{
+			ID: uuid.NewV4().String(), AuthorID: author, Symbol: DefaultSymbol, Type: typ, State: StatePending,
+			Price: NewPrice(price, 5), StopLoss: NewPrice(sl, 5), TakeProfit: NewPrice(tp, 5),
+			Created: 1000, PendingExp: MaxPendingExp, PositionExp: MaxPositionExp,
+			History: []Event{{1000, NewPrice(price, 5), StatePending}},
+		}
+		if err := writeJSON(dbh, db.PROPOSALS, idBytes(p.ID), p); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+		return p
+	}
+	quote := func(bid int64, sec int64) {
+		if err := e.PublishQuote(Quote{DefaultSymbol, NewPrice(bid, 5), NewPrice(bid, 5), sec * 1000}); err != nil {
+			t.Fatalf("PublishQuote error: %v", err)
+		}
+	}
+	// The buy stop takes 50 pips of profit with 1:2 risk/reward, the sell limit loses 25 pips
+	buy := propose(BuyStop, 123500, 123250, 124000)
+	if _, err := e.JoinProposal(trader, buy.ID, NewPrice(10, 2)); err != nil {
+		t.Fatalf("JoinProposal error: %v", err)
+	}
+	quote(123500, 1100)
+	quote(124000, 1400)
+	propose(SellLimit, 124250, 124500, 123750)
+	quote(124250, 1500)
+	quote(124500, 1600)
+	st, err := ReadStats(dbh, RoleAuthor, author)
+	if err != nil {
+		t.Fatalf("ReadStats error: %v", err)
+	}
+	if st.Trades != 2 || st.Wins != 1 || st.Pips.String() != "25.0" || st.WinRate.String() != "50.00" ||
+		st.Expectancy.String() != "12.50" || st.MaxDrawdown.String() != "25.0" || st.AvgRiskReward != 2 ||
+		st.AvgHold != 200 || st.ByType["sellstop"] != nil || st.ByType["selllimit"].Pips.String() != "-25.0" {
+		t.Errorf("Unexpected author stats: %+v", st)
+	}
+	if st, err = ReadStats(dbh, RoleTrader, trader); err != nil {
+		t.Fatalf("ReadStats error: %v", err)
+	}
+	if st.Trades != 1 || st.Pips.String() != "50.0" || st.Profit.String() != "50.00" || st.AvgHold != 300 {
+		t.Errorf("Unexpected trader stats: %+v", st)
+	}
+	// The repeated closing event isn't counted
+	if err = e.UpgradeProposal(uuid.FromStringOrNil(buy.ID), Event{1700, NewPrice(124000, 5), StateTakeProfit}); err != nil {
+		t.Fatalf("UpgradeProposal error: %v", err)
+	}
+	if st, _ = ReadStats(dbh, RoleAuthor, author); st.Trades != 2 {
+		t.Errorf("Unexpected number of trades: %d", st.Trades)
+	}
+	// Statistics are rebuilt from the history
+	for _, key := range [][]byte{statsKey(RoleAuthor, author), statsKey(RoleTrader, trader)} {
+		if err = dbh.Delete(db.STATS, key); err != nil {
+			t.Fatalf("Delete error: %v", err)
+		}
+	}
+	if n, err := RebuildStats(dbh); err != nil || n != 3 {
+		t.Errorf("RebuildStats returned %d, %v", n, err)
+	}
+	if rebuilt, _ := ReadStats(dbh, RoleAuthor, author); rebuilt.Pips.String() != "25.0" || rebuilt.MaxDrawdown.String() != "25.0" {
+		t.Errorf("Unexpected rebuilt stats: %+v", rebuilt)
+	}
+	if _, err = ReadStats(dbh, "follower", author); err != ErrWrongRole {
+		t.Errorf("ReadStats expected ErrWrongRole, got %v", err)
+	}
+}
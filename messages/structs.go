@@ -8,7 +8,6 @@ import (
 	"math/rand"
 	"encoding/json"
 	"github.com/satori/go.uuid"
-	"fmt"
 	"time"
 )
 
@@ -23,14 +22,16 @@ import (
 //		id: “qweaer22drg124”,
 //		rate: 2.72
 //	}
+//	symbol: "EURUSD", // instrument of the catalogue
 //	type: 1, // 0 - buystop(buy before), 1 - buylimit(buy after), 2 - sellstop(sell after), 3 - selllimit(sell before)
-// 	state: 0, // 0 - proposal, 1 - pending order, 2 - position, 3 - expired proposal, 4 - expired pending order, 5 - expired position
-//	price: 1.2345,
+// 	state: 0, // 0 - proposal, 1 - pending order, 2 - position, 3 - expired proposal, 4 - expired pending order, 5 - expired position, 6 - closed by stop loss, 7 - closed by take profit
+//	price: "1.23450", // decimal string, numbers are accepted too
 //      goalscore: 10.24,
-//	stoploss: 1.2327, // price - 0.0020 + spread(0.0002)
-//	takeprofit: 1.2397, // price + 0.0050 + spread(0.0002)
+//	stoploss: "1.23270", // price - 0.0020 + spread(0.0002)
+//	takeprofit: "1.23970", // price + 0.0050 + spread(0.0002)
 //	score: 2.72,
 //	deadline: 1493062222, // UNIX timestamp in sec
+//	created: 1493050000, // UNIX timestamp in sec
 //	pendexp: 2400, // in sec
 //	posexp: 7320, // in sec
 // }
@@ -38,19 +39,22 @@ type Proposal struct {
 	// Static components
 	AuthorID    string `json:"authorid"`
 	ID          string `json:"id"`
+	Symbol      string `json:"symbol"`
 	Type        byte `json:"type"`
 	State       byte `json:"state"`
-	Price       float32 `json:"price"`
-	StopLoss    float32 `json:"stoploss"`
-	TakeProfit  float32 `json:"takeprofit"`
+	Price       Price `json:"price"`
+	StopLoss    Price `json:"stoploss"`
+	TakeProfit  Price `json:"takeprofit"`
 	Score       float32 `json:"score"`
 	GoalScore   float32 `json:"goalscore"`
 	Deadline    int64 `json:"deadline"`
+	Created     int64 `json:"created"`
 	PendingExp  int64 `json:"pendexp"`
 	PositionExp int64 `json:"posexp"`
 	History     []Event `json:"history"`
 	// Dynamic components
-	// Votes and involved appear right after the proposal has been triggered
+	// Votes appear right after the proposal has been triggered
+	// Involved lists the users who have joined the proposal
 	Votes       []string `json:"votes,omitempty"`
 	Involved    []string `json:"involved,omitempty"`
 }
@@ -59,6 +63,7 @@ type Proposal struct {
 type ProposalUpdate struct {
 	ID    string `json:"id"`
 	Score float32 `json:"score"`
+	State byte `json:"state"`
 }
 
 // DynProp represents dynamic proposal object.
@@ -68,6 +73,15 @@ type DynProp struct {
 	Votes []string `json:"votes"`
 }
 
+// User represents public information of the trader stored in USERS database.
+// Rate is the reputation of the trader. It defines the weight of the trader's votes.
+type User struct {
+	ID   string  `json:"id"`
+	Name string  `json:"name"`
+	Rate float64 `json:"rate"`
+	Role string  `json:"role,omitempty"`
+}
+
 // FillRandom fills the proposal object with some random data.
 // ID has 16 runes length.
 // Author
@@ -78,12 +92,14 @@ type DynProp struct {
 func (p *Proposal) FillRandom() {
 	p.AuthorID = uuid.NewV4().String()
 	p.ID = uuid.NewV4().String()
+	p.Symbol = DefaultSymbol
 	p.Type = byte(rand.Intn(4) % 256)
-	p.Price = rand.Float32()
-	p.StopLoss = rand.Float32()
-	p.TakeProfit = rand.Float32()
+	p.Price = NewPrice(rand.Int63n(200000) + 1, DefaultPrecision.Digits)
+	p.StopLoss = NewPrice(rand.Int63n(200000) + 1, DefaultPrecision.Digits)
+	p.TakeProfit = NewPrice(rand.Int63n(200000) + 1, DefaultPrecision.Digits)
 	p.Score = rand.Float32()
 	p.Deadline = rand.Int63()
+	p.Created = time.Now().Unix()
 	p.PendingExp = rand.Int63n(10000 - 900 + 1) + 900
 	p.PositionExp = rand.Int63n(10000 - 3600 + 1) + 3600
 	history := make([]Event, rand.Intn(4)+1)
@@ -95,15 +111,28 @@ func (p *Proposal) FillRandom() {
 
 // ChatMessage represents chat message which can be send via websockets from server to client.
 type ChatMessage struct {
+	ID       string `json:"id"`
 	AuthorID string `json:"authorid"`
 	Text     string `json:"text"`
 	Time	 int64  `json:"time"`
+	Room     string `json:"room,omitempty"`
+	Hidden   bool   `json:"hidden,omitempty"`
+	// Deleted marks the tombstone of the deleted message which keeps positions of other messages in the bucket
+	Deleted  bool   `json:"deleted,omitempty"`
+	// ReplyTo is the ID of the replied message and Quote is the beginning of its text
+	ReplyTo   string              `json:"replyto,omitempty"`
+	Quote     string              `json:"quote,omitempty"`
+	Mentions  []string            `json:"mentions,omitempty"`
+	Edited    int64               `json:"edited,omitempty"`
+	// Reactions maps the emoji to the users who reacted with it
+	Reactions map[string][]string `json:"reactions,omitempty"`
 }
 
 // FillRandom fills the ChatMessage object with a random data.
 // The sentence has length from 1 to 30
 // AuthorID has 16 runes length
 func (cm *ChatMessage) FillRandom() {
+	cm.ID = uuid.NewV4().String()
 	cm.AuthorID = uuid.NewV4().String()
 	n := rand.Intn(100) + 1
 	cm.Text = randSentence(n)
@@ -133,11 +162,42 @@ func (cb *ChatBucket) FillRandom(n int) {
 //	2 - add a proposal
 //	3 - add a message
 //	4 - update a proposal
+//	5 - direct message
+//	6 - moderate a chat message
+//	7 - edit or react to a chat message
+//	8 - mention of the user (unused, mentions are sent as notifications)
+//	9 - error
+//	10 - quote of the instrument
+//	11 - subscribe to quotes and candles (client --> server)
+//	12 - update of the candle
+//	13 - update of the user's position (unused, changes are sent as notifications)
+//	14 - update of ranks in the leaderboard
+//	15 - notification of the user's alert
 type Message struct {
 	Type byte `json:"type"`
 	Data interface{} `json:"data"`
 }
 
+// Message types
+const (
+	MsgAllProposals byte = iota
+	MsgAllChat
+	MsgAddProposal
+	MsgAddChat
+	MsgUpdateProposal
+	MsgDirect
+	MsgModerate
+	MsgChatDelta
+	MsgMention
+	MsgError
+	MsgQuote
+	MsgSubscribe
+	MsgCandle
+	MsgPosition
+	MsgLeaderboard
+	MsgNotification
+)
+
 // WSData stores multiple Messages. Can be Marshalled to json
 type WSData struct {
 	Data []Message
@@ -173,11 +233,6 @@ func (wsd *WSData) Jsonify() ([]byte, error) {
 	return json.Marshal(wsd)
 }
 
-// SendError wraps error message into json format.
-func SendError(sender func(content string), err error) {
-	str := fmt.Sprintf("{\"err\":\"%s\"}", err.Error())
-	sender(str)
-}
 
 // Possible runes are listed here.
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ,.")
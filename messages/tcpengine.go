@@ -5,26 +5,220 @@
 package messages
 
 import (
-	"github.com/gorilla/websocket"
+	"encoding/json"
 	"net"
+	"sync"
 	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
 )
 
 // TCPWSEngine is a gameplay engine that works with TCP trigger server.
 // It provides chatting ability, interaction with the database, websocket connections handling.
 type TCPWSEngine struct {
-	ws      []*websocket.Conn
-	trigger net.Conn
-	db      db.DBHandler
+	clients map[*Client]struct{}
+	wsmu    sync.Mutex
+	roomsmu sync.Mutex
+	chatmu  sync.Mutex
+	// accountsmu serializes changes of accounts and positions
+	accountsmu sync.Mutex
+	// risk limits positions of users, it is guarded by accountsmu
+	risk RiskRules
+	// leaderboardsmu serializes refreshes of leaderboards
+	leaderboardsmu sync.Mutex
+	// notifyseq is the sequence number of the last notification, it is guarded by notifymu
+	notifyseq int64
+	notifymu  sync.Mutex
+	// deliverymu serializes storing of new notifications and delivery of the stored ones
+	deliverymu sync.Mutex
+	trigger    net.Conn
+	db         db.DBHandler
+	// filter is a list of words which are masked in chat messages
+	filter  []string
+	quotes  *QuoteCache
+	candles *candleAggregator
+}
+
+// MakeTCPWSEngine returns the engine which works with the database dbh and the trigger connection.
+// trigger can be nil if there is no connection to the trigger server.
+func MakeTCPWSEngine(dbh db.DBHandler, trigger net.Conn) *TCPWSEngine {
+	return &TCPWSEngine{clients: map[*Client]struct{}{}, db: dbh, trigger: trigger,
+		quotes: NewQuoteCache(), candles: newCandleAggregator(dbh)}
+}
+
+// AddProposal stores the new proposal p and its dynamic part in the database.
+// The proposal gets new ID and starts in StateProposal state.
+// It is rejected if it breaks the risk rules. Followers of the author are notified.
+func (e *TCPWSEngine) AddProposal(p Proposal) (id uuid.UUID, err error) {
+	if err = e.checkProposal(&p); err != nil {
+		return
+	}
+	id = uuid.NewV4()
+	p.ID = id.String()
+	p.State = StateProposal
+	p.Created = now()
+	p.Score = 0
+	p.Votes = nil
+	p.Involved = nil
+	// Write the static part
+	var data []byte
+	data, err = json.Marshal(p)
+	if err != nil {
+		return
+	}
+	err = e.db.Write(db.PROPOSALS, id.Bytes(), data)
+	if err != nil {
+		return
+	}
+	// Write the dynamic part
+	data, err = json.Marshal(DynProp{ID: p.ID, Votes: []string{}})
+	if err != nil {
+		return
+	}
+	err = e.db.Write(db.DYNAMIC, id.Bytes(), data)
+	if err != nil {
+		return
+	}
+	e.Broadcast(Message{MsgAddProposal, p})
+	err = e.alertAuthor(p)
+	return
+}
+
+// VoteProposal adds the vote of the user userID to the proposal propID.
+// The weight of the vote depends on the reputation of the user.
+// When the score reaches the goal score the proposal becomes a pending order.
+func (e *TCPWSEngine) VoteProposal(propID, userID uuid.UUID) error {
+	p, err := e.readProposal(propID)
+	if err != nil {
+		return err
+	}
+	if err = CheckVote(p, userID.String(), now()); err != nil {
+		return err
+	}
+	// Read the voter's reputation
+	var data []byte
+	data, err = e.db.Read(db.USERS, userID.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "cannot read the voter")
+	}
+	u := User{}
+	if err = json.Unmarshal(data, &u); err != nil {
+		return err
+	}
+	// The vote and the state of the proposal are changed in one transaction
+	vote := &dynVote{UserID: userID.String(), Weight: VoteWeight(u), Goal: float64(p.GoalScore)}
+	trig := &propTrigger{Vote: vote, Time: now()}
+	err = e.db.ModifyAll(db.Change{DB: db.DYNAMIC, Key: propID.Bytes(), Modifier: vote},
+		db.Change{DB: db.PROPOSALS, Key: propID.Bytes(), Modifier: trig})
+	if err != nil {
+		return err
+	}
+	if !trig.Changed {
+		e.Broadcast(Message{MsgUpdateProposal, ProposalUpdate{p.ID, float32(vote.Result.Score), p.State}})
+		return nil
+	}
+	// The goal is reached so the proposal has become a pending order
+	e.Broadcast(Message{MsgUpdateProposal, ProposalUpdate{p.ID, trig.Result.Score, trig.Result.State}})
+	if err = e.settle(trig.Result); err != nil {
+		return err
+	}
+	return e.alertState(trig.Result)
+}
+
+// UpgradeProposal applies the event ev to the proposal propID.
+func (e *TCPWSEngine) UpgradeProposal(propID uuid.UUID, ev Event) error {
+	upg := &propUpgrade{Event: ev}
+	if err := e.db.Modify(db.PROPOSALS, propID.Bytes(), upg); err != nil {
+		return err
+	}
+	e.Broadcast(Message{MsgUpdateProposal, ProposalUpdate{upg.Result.ID, upg.Result.Score, upg.Result.State}})
+	if err := e.settle(upg.Result); err != nil {
+		return err
+	}
+	return e.alertState(upg.Result)
+}
+
+// readProposal reads the proposal with the given id from the database.
+func (e *TCPWSEngine) readProposal(id uuid.UUID) (p Proposal, err error) {
+	var data []byte
+	data, err = e.db.Read(db.PROPOSALS, id.Bytes())
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &p)
+	return
+}
+
+// AddClient registers the websocket client to receive messages.
+func (e *TCPWSEngine) AddClient(c *Client) {
+	e.wsmu.Lock()
+	e.clients[c] = struct{}{}
+	e.wsmu.Unlock()
+}
+
+// RemoveClient unregisters the websocket client.
+func (e *TCPWSEngine) RemoveClient(c *Client) {
+	e.wsmu.Lock()
+	delete(e.clients, c)
+	e.wsmu.Unlock()
+}
+
+// Broadcast sends the message m to all registered websocket clients.
+func (e *TCPWSEngine) Broadcast(m Message) {
+	e.send(m, func(c *Client) bool {
+		return true
+	})
+}
+
+// SendTo sends the message m to the websocket clients of the given users.
+func (e *TCPWSEngine) SendTo(users []string, m Message) {
+	set := make(map[string]bool, len(users))
+	for _, u := range users {
+		set[u] = true
+	}
+	e.send(m, func(c *Client) bool {
+		return c.UserID != "" && set[c.UserID]
+	})
+}
+
+// send sends the message m to the clients selected by the filter.
+// Clients which fail to receive the message are closed and unregistered.
+// It returns the number of clients which have received the message.
+func (e *TCPWSEngine) send(m Message, filter func(c *Client) bool) (sent int) {
+	data, err := json.Marshal(WSData{[]Message{m}})
+	if err != nil {
+		return
+	}
+	e.wsmu.Lock()
+	defer e.wsmu.Unlock()
+	for c := range e.clients {
+		if !filter(c) {
+			continue
+		}
+		if c.Send(data) != nil {
+			c.Close()
+			delete(e.clients, c)
+		} else {
+			sent++
+		}
+	}
+	return
 }
 
 // Close finishes all open objects.
 func (e *TCPWSEngine) Close() {
-	for _, conn := range e.ws {
-		conn.Close()
+	e.wsmu.Lock()
+	for c := range e.clients {
+		c.Close()
 	}
+	e.clients = map[*Client]struct{}{}
+	e.wsmu.Unlock()
+	e.candles.flush()
 	e.db.Close()
-	e.trigger.Close()
+	if e.trigger != nil {
+		e.trigger.Close()
+	}
 }
 
 // internalLoop accepts new websocket connections and handles basic operations with them
@@ -0,0 +1,136 @@
+// users.go registers users and checks their passwords
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"union/db"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Limits of user credentials
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+	// PasswordIterations is the number of PBKDF2 iterations of new password hashes.
+	PasswordIterations = 100000
+)
+
+var (
+	// ErrNameTaken is returned when the name is already registered.
+	ErrNameTaken = errors.New("name is already taken")
+	// ErrWrongCredentials is returned when the name or the password is wrong.
+	ErrWrongCredentials = errors.New("wrong name or password")
+)
+
+// userName is the format of user names.
+var userName = regexp.MustCompile(`^[A-Za-z0-9_]{3,32}$`)
+
+// registermu serializes registrations so that names stay unique.
+var registermu sync.Mutex
+
+// Credentials is the password hash of the user stored in PRIVATE database by the lower case name.
+// Hash is PBKDF2-HMAC-SHA256 of the password with Salt and Iterations.
+type Credentials struct {
+	UserID     string `json:"userid"`
+	Salt       []byte `json:"salt"`
+	Hash       []byte `json:"hash"`
+	Iterations int    `json:"iterations"`
+}
+
+// credentialsKey returns the key of the credentials in PRIVATE database.
+func credentialsKey(name string) []byte {
+	return []byte(strings.ToLower(name))
+}
+
+// dummyCredentials are checked for unknown names, so the time of the login doesn't reveal registered names.
+var dummyCredentials = Credentials{Salt: make([]byte, 16), Iterations: PasswordIterations}
+
+// passwordHash derives 32 bytes key from the password by PBKDF2-HMAC-SHA256.
+func passwordHash(password string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+}
+
+// newCredentials returns the credentials of the user with the random salt.
+func newCredentials(userID, password string) (Credentials, error) {
+	c := Credentials{UserID: userID, Salt: make([]byte, 16), Iterations: PasswordIterations}
+	if _, err := rand.Read(c.Salt); err != nil {
+		return c, err
+	}
+	c.Hash = passwordHash(password, c.Salt, c.Iterations)
+	return c, nil
+}
+
+// check checks the password against the credentials.
+func (c *Credentials) check(password string) bool {
+	hash := passwordHash(password, c.Salt, c.Iterations)
+	return subtle.ConstantTimeCompare(hash, c.Hash) == 1
+}
+
+// validateCredentials returns FieldErrors if the name or the password are wrong.
+func validateCredentials(name, password string) error {
+	fe := FieldErrors{}
+	if !userName.MatchString(name) {
+		fe["name"] = "must have from 3 to 32 latin letters, digits or underscores"
+	}
+	if n := utf8.RuneCountInString(password); n < MinPasswordLength || n > MaxPasswordLength {
+		fe["password"] = "must have from 8 to 128 characters"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+// Register creates the user with the name and the password. Names are case insensitive.
+func Register(dbh db.DBHandler, name, password string) (u User, err error) {
+	if err = validateCredentials(name, password); err != nil {
+		return
+	}
+	registermu.Lock()
+	defer registermu.Unlock()
+	key := credentialsKey(name)
+	if _, err = dbh.Read(db.PRIVATE, key); err == nil {
+		return u, ErrNameTaken
+	} else if !db.IsNotFound(err) {
+		return
+	}
+	u = User{ID: uuid.NewV4().String(), Name: name}
+	var c Credentials
+	if c, err = newCredentials(u.ID, password); err != nil {
+		return
+	}
+	if err = writeJSON(dbh, db.USERS, idBytes(u.ID), u); err != nil {
+		return
+	}
+	err = writeJSON(dbh, db.PRIVATE, key, c)
+	return
+}
+
+// Login returns the user with the name if the password is right.
+func Login(dbh db.DBHandler, name, password string) (u User, err error) {
+	c := Credentials{}
+	if err = readJSON(dbh, db.PRIVATE, credentialsKey(name), &c); db.IsNotFound(err) {
+		dummyCredentials.check(password)
+		return u, ErrWrongCredentials
+	} else if err != nil {
+		return
+	}
+	if !c.check(password) {
+		return u, ErrWrongCredentials
+	}
+	err = readJSON(dbh, db.USERS, idBytes(c.UserID), &u)
+	return
+}
@@ -0,0 +1,58 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"union/db"
+)
+
+func TestUsers(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	u, err := Register(dbh, "Trader_1", "secret password")
+	if err != nil {
+		t.Fatalf("Register error: %v", err)
+	}
+	stored := User{}
+	if err = readJSON(dbh, db.USERS, idBytes(u.ID), &stored); err != nil || stored.Name != "Trader_1" {
+		t.Errorf("Unexpected stored user: %+v, %v", stored, err)
+	}
+	// Names are case insensitive
+	if _, err = Register(dbh, "trader_1", "other password"); err != ErrNameTaken {
+		t.Errorf("Register expected ErrNameTaken, got %v", err)
+	}
+	_, err = Register(dbh, "a b", "short")
+	fe, _ := err.(FieldErrors)
+	if len(fe) != 2 || fe["name"] == "" || fe["password"] == "" {
+		t.Errorf("Unexpected field errors: %v", fe)
+	}
+	logged, err := Login(dbh, "TRADER_1", "secret password")
+	if err != nil || logged.ID != u.ID {
+		t.Errorf("Unexpected login: %+v, %v", logged, err)
+	}
+	if _, err = Login(dbh, "Trader_1", "wrong password"); err != ErrWrongCredentials {
+		t.Errorf("Login expected ErrWrongCredentials, got %v", err)
+	}
+	if _, err = Login(dbh, "nobody", "secret password"); err != ErrWrongCredentials {
+		t.Errorf("Login expected ErrWrongCredentials, got %v", err)
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	// Test vectors of PBKDF2-HMAC-SHA256 keep stored hashes valid
+	for _, c := range []struct {
+		iterations int
+		expected   string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	} {
+		if hash := hex.EncodeToString(passwordHash("password", []byte("salt"), c.iterations)); hash != c.expected {
+			t.Errorf("Unexpected hash of %d iterations: %s", c.iterations, hash)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// validation.go checks the consistency of objects received from clients
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Expiration bounds of proposals in seconds
+const (
+	MinPendingExp  = 900
+	MaxPendingExp  = 10000
+	MinPositionExp = 3600
+	MaxPositionExp = 10000
+)
+
+// FieldErrors maps the name of the JSON field to the description of its problem.
+type FieldErrors map[string]string
+
+// Error lists all wrong fields in alphabetical order.
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for f := range fe {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return "wrong fields: " + strings.Join(fields, ", ")
+}
+
+// CheckMarket checks the price of the pending order against the current quote of the instrument.
+// Stop orders are placed beyond the market and limit orders are placed within it.
+func (p *Proposal) CheckMarket(q Quote) error {
+	var ok bool
+	switch p.Type {
+	case BuyStop:
+		ok = p.Price.Cmp(q.Ask) > 0
+	case BuyLimit:
+		ok = p.Price.Cmp(q.Ask) < 0
+	case SellStop:
+		ok = p.Price.Cmp(q.Bid) < 0
+	case SellLimit:
+		ok = p.Price.Cmp(q.Bid) > 0
+	}
+	if !ok {
+		return FieldErrors{"price": "must be on the proper side of the market " + q.Bid.String() + "/" + q.Ask.String()}
+	}
+	return nil
+}
+
+// Validate checks the proposal created by the client at the moment now
+// and brings its prices to the precision of the instrument inst.
+// inst is nil if the symbol of the proposal is not in the catalogue.
+// It returns FieldErrors if some fields are wrong.
+func (p *Proposal) Validate(now int64, inst *Instrument) error {
+	fe := FieldErrors{}
+	pr := DefaultPrecision
+	if inst == nil {
+		fe["symbol"] = "must be a known instrument"
+	} else {
+		pr = inst.Precision()
+	}
+	prices := map[string]*Price{"price": &p.Price, "stoploss": &p.StopLoss, "takeprofit": &p.TakeProfit}
+	for field, price := range prices {
+		np, err := pr.Normalize(*price)
+		switch {
+		case err != nil:
+			fe[field] = "must have at most " + strconv.Itoa(int(pr.Digits)) + " decimal digits"
+		case np.Sign() <= 0:
+			fe[field] = "must be positive"
+		case inst != nil && !inst.OnTick(np):
+			fe[field] = "must be a multiple of the tick size " + inst.TickSize.String()
+		}
+		*price = np
+	}
+	if p.GoalScore <= 0 {
+		fe["goalscore"] = "must be positive"
+	}
+	switch p.Type {
+	case BuyStop, BuyLimit:
+		// Stop loss is below and take profit is above the price
+		if p.StopLoss.Cmp(p.Price) >= 0 {
+			fe["stoploss"] = "must be below the price for buy orders"
+		}
+		if p.TakeProfit.Cmp(p.Price) <= 0 {
+			fe["takeprofit"] = "must be above the price for buy orders"
+		}
+	case SellStop, SellLimit:
+		// Stop loss is above and take profit is below the price
+		if p.StopLoss.Cmp(p.Price) <= 0 {
+			fe["stoploss"] = "must be above the price for sell orders"
+		}
+		if p.TakeProfit.Cmp(p.Price) >= 0 {
+			fe["takeprofit"] = "must be below the price for sell orders"
+		}
+	default:
+		fe["type"] = "must be within the range [0, 3]"
+	}
+	if p.PendingExp < MinPendingExp || p.PendingExp > MaxPendingExp {
+		fe["pendexp"] = "must be within the range [900, 10000]"
+	}
+	if p.PositionExp < MinPositionExp || p.PositionExp > MaxPositionExp {
+		fe["posexp"] = "must be within the range [3600, 10000]"
+	}
+	if p.Deadline <= now {
+		fe["deadline"] = "must be in the future"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
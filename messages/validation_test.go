@@ -0,0 +1,65 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	// valid returns the buy limit order which passes the validation at the moment 100
+	valid := func() Proposal {
+		return Proposal{Symbol: DefaultSymbol, Type: BuyLimit, Price: NewPrice(12345, 4), StopLoss: NewPrice(12300, 4),
+			TakeProfit: NewPrice(12400, 4), GoalScore: 1, Deadline: 200, PendingExp: MinPendingExp, PositionExp: MinPositionExp}
+	}
+	p := valid()
+	if err := p.Validate(100, &DefaultInstrument); err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+	if p.Price.Scale != DefaultPrecision.Digits {
+		t.Errorf("The price isn't brought to the precision of the instrument: %+v", p.Price)
+	}
+	for _, c := range []struct {
+		field  string
+		change func(p *Proposal)
+	}{
+		{"price", func(p *Proposal) { p.Price = NewPrice(1234501, 6) }},
+		{"stoploss", func(p *Proposal) { p.StopLoss = NewPrice(0, 0) }},
+		{"takeprofit", func(p *Proposal) { p.TakeProfit = NewPrice(-12400, 4) }},
+		{"stoploss", func(p *Proposal) { p.StopLoss = NewPrice(12350, 4) }},
+		{"takeprofit", func(p *Proposal) { p.TakeProfit = NewPrice(12345, 4) }},
+		{"stoploss", func(p *Proposal) { p.Type = SellLimit; p.TakeProfit = NewPrice(12300, 4) }},
+		{"takeprofit", func(p *Proposal) { p.Type = SellStop; p.StopLoss = NewPrice(12400, 4) }},
+		{"type", func(p *Proposal) { p.Type = 4 }},
+		{"goalscore", func(p *Proposal) { p.GoalScore = 0 }},
+		{"pendexp", func(p *Proposal) { p.PendingExp = MinPendingExp - 1 }},
+		{"pendexp", func(p *Proposal) { p.PendingExp = MaxPendingExp + 1 }},
+		{"posexp", func(p *Proposal) { p.PositionExp = MinPositionExp - 1 }},
+		{"posexp", func(p *Proposal) { p.PositionExp = MaxPositionExp + 1 }},
+		{"deadline", func(p *Proposal) { p.Deadline = 100 }},
+	} {
+		p = valid()
+		c.change(&p)
+		fe, _ := p.Validate(100, &DefaultInstrument).(FieldErrors)
+		if len(fe) != 1 || fe[c.field] == "" {
+			t.Errorf("Expected the error of %s for %+v, got %v", c.field, p, fe)
+		}
+	}
+	// The symbol must be in the catalogue
+	p = valid()
+	if fe, _ := p.Validate(100, nil).(FieldErrors); len(fe) != 1 || fe["symbol"] == "" {
+		t.Errorf("Expected the error of symbol, got %v", fe)
+	}
+	// Prices must be on ticks of the instrument
+	quarter := Instrument{Symbol: "ES", TickSize: NewPrice(25, 2), PipSize: NewPrice(1, 0), ContractSize: 50}
+	p = Proposal{Symbol: "ES", Type: BuyStop, Price: NewPrice(425010, 2), StopLoss: NewPrice(4240, 0),
+		TakeProfit: NewPrice(4270, 0), GoalScore: 1, Deadline: 200, PendingExp: MinPendingExp, PositionExp: MinPositionExp}
+	if fe, _ := p.Validate(100, &quarter).(FieldErrors); len(fe) != 1 || fe["price"] == "" {
+		t.Errorf("Expected the error of price, got %v", fe)
+	}
+	if msg := fmt.Sprint(FieldErrors{"b": "x", "a": "y"}); msg != "wrong fields: a, b" {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+}
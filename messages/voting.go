@@ -0,0 +1,122 @@
+// voting.go describes the voting rules of proposals
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// MinVoteWeight is the weight of the vote given by the trader without reputation.
+const MinVoteWeight = 0.1
+
+var (
+	// ErrSelfVote is returned when the author votes for the own proposal.
+	ErrSelfVote = errors.New("author cannot vote for own proposal")
+	// ErrDuplicateVote is returned when the user votes for the same proposal twice.
+	ErrDuplicateVote = errors.New("user has already voted for the proposal")
+	// ErrVotingClosed is returned when the proposal doesn't accept votes anymore.
+	ErrVotingClosed = errors.New("proposal doesn't accept votes")
+)
+
+// VoteWeight returns the weight of the vote given by the user u.
+// The weight equals to the user's reputation but it can't be less than MinVoteWeight.
+func VoteWeight(u User) float64 {
+	if u.Rate < MinVoteWeight {
+		return MinVoteWeight
+	}
+	return u.Rate
+}
+
+// CheckVote validates the vote of the user userID for the proposal p at the moment now.
+func CheckVote(p Proposal, userID string, now int64) error {
+	if p.State != StateProposal || p.Deadline < now {
+		return ErrVotingClosed
+	}
+	if p.AuthorID == userID {
+		return ErrSelfVote
+	}
+	return nil
+}
+
+// dynVote is a Modifier which adds the vote to the DynProp object.
+// Reached is set when the score of DynProp has reached the goal.
+type dynVote struct {
+	UserID  string
+	Weight  float64
+	Goal    float64
+	Result  DynProp
+	Reached bool
+}
+
+// Apply adds the vote to the marshalled DynProp object.
+func (v *dynVote) Apply(data []byte) ([]byte, error) {
+	dp := DynProp{}
+	if err := json.Unmarshal(data, &dp); err != nil {
+		return nil, err
+	}
+	for _, id := range dp.Votes {
+		if id == v.UserID {
+			return nil, ErrDuplicateVote
+		}
+	}
+	dp.Votes = append(dp.Votes, v.UserID)
+	dp.Score += v.Weight
+	v.Result = dp
+	v.Reached = dp.Score >= v.Goal
+	return json.Marshal(dp)
+}
+
+// propTrigger is a Modifier which turns the proposal into the pending order.
+// Changed is set only if the proposal has been in the StateProposal state.
+// If Vote is set the trigger follows the vote applied in the same transaction:
+// the vote is rejected if the proposal doesn't collect votes anymore and the proposal
+// is changed only if the vote has reached the goal. Dyn is taken from the vote then.
+type propTrigger struct {
+	Dyn     DynProp
+	Vote    *dynVote
+	Time    int64
+	Result  Proposal
+	Changed bool
+}
+
+// Apply moves the marshalled proposal to the pending order state.
+func (t *propTrigger) Apply(data []byte) ([]byte, error) {
+	if err := json.Unmarshal(data, &t.Result); err != nil {
+		return nil, err
+	}
+	if t.Vote != nil {
+		if t.Result.State != StateProposal || t.Result.Deadline < t.Time {
+			return nil, ErrVotingClosed
+		}
+		if !t.Vote.Reached {
+			return data, nil
+		}
+		t.Dyn = t.Vote.Result
+	}
+	if t.Result.State != StateProposal {
+		return data, nil
+	}
+	t.Result.State = StatePending
+	t.Result.Score = float32(t.Dyn.Score)
+	t.Result.Votes = t.Dyn.Votes
+	t.Result.History = append(t.Result.History, Event{t.Time, t.Result.Price, StatePending})
+	t.Changed = true
+	return json.Marshal(t.Result)
+}
+
+// now returns current UNIX time of GameClock in seconds.
+// It is used for the creation, deadlines and expirations of proposals only, see wallNow.
+func now() int64 {
+	return GameClock.Now().Unix()
+}
+
+// wallNow returns current UNIX time of SystemClock in seconds.
+// Accounts, positions, chat sanctions, edit windows, notifications and risk limits
+// follow it even when the game clock replays historical market data.
+func wallNow() int64 {
+	return SystemClock.Now().Unix()
+}
@@ -0,0 +1,121 @@
+// 866
+// All Rights Reserved
+
+package messages
+
+import (
+	"encoding/json"
+	"testing"
+
+	"union/db"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestCheckVote(t *testing.T) {
+	p := Proposal{AuthorID: "author", State: StateProposal, Deadline: 100}
+	if err := CheckVote(p, "voter", 50); err != nil {
+		t.Errorf("CheckVote error: %v", err)
+	}
+	if err := CheckVote(p, "author", 50); err != ErrSelfVote {
+		t.Errorf("CheckVote expected ErrSelfVote, got %v", err)
+	}
+	if err := CheckVote(p, "voter", 150); err != ErrVotingClosed {
+		t.Errorf("CheckVote expected ErrVotingClosed after the deadline, got %v", err)
+	}
+	p.State = StatePending
+	if err := CheckVote(p, "voter", 50); err != ErrVotingClosed {
+		t.Errorf("CheckVote expected ErrVotingClosed for pending order, got %v", err)
+	}
+}
+
+func TestDynVote(t *testing.T) {
+	data, _ := json.Marshal(DynProp{ID: "prop", Votes: []string{}})
+	vote := &dynVote{UserID: "a", Weight: VoteWeight(User{Rate: 2}), Goal: 3}
+	data, err := vote.Apply(data)
+	if err != nil {
+		t.Errorf("dynVote.Apply error: %v", err)
+	}
+	if vote.Reached || vote.Result.Score != 2 {
+		t.Errorf("Unexpected vote result: %+v", vote)
+	}
+	// Duplicate vote
+	if _, err = vote.Apply(data); err != ErrDuplicateVote {
+		t.Errorf("dynVote.Apply expected ErrDuplicateVote, got %v", err)
+	}
+	// The second vote reaches the goal
+	vote = &dynVote{UserID: "b", Weight: VoteWeight(User{Rate: 1}), Goal: 3}
+	if _, err = vote.Apply(data); err != nil {
+		t.Errorf("dynVote.Apply error: %v", err)
+	}
+	if !vote.Reached || len(vote.Result.Votes) != 2 {
+		t.Errorf("Unexpected vote result: %+v", vote)
+	}
+	if VoteWeight(User{}) != MinVoteWeight {
+		t.Errorf("VoteWeight of user without reputation should be %v", MinVoteWeight)
+	}
+}
+
+func TestPropTrigger(t *testing.T) {
+	data, _ := json.Marshal(Proposal{ID: "prop", Price: NewPrice(15, 1), State: StateProposal})
+	trig := &propTrigger{Dyn: DynProp{Score: 4, Votes: []string{"a", "b"}}, Time: 10}
+	data, err := trig.Apply(data)
+	if err != nil {
+		t.Errorf("propTrigger.Apply error: %v", err)
+	}
+	if !trig.Changed || trig.Result.State != StatePending || len(trig.Result.History) != 1 {
+		t.Errorf("Unexpected trigger result: %+v", trig.Result)
+	}
+	// The second trigger doesn't change anything
+	trig = &propTrigger{Dyn: DynProp{Score: 5}, Time: 11}
+	if _, err = trig.Apply(data); err != nil || trig.Changed {
+		t.Errorf("propTrigger.Apply shouldn't change pending order: %v", err)
+	}
+	// The vote for the pending order is rejected
+	trig = &propTrigger{Vote: &dynVote{Reached: true}, Time: 11}
+	if _, err = trig.Apply(data); err != ErrVotingClosed {
+		t.Errorf("propTrigger.Apply expected ErrVotingClosed, got %v", err)
+	}
+}
+
+func TestVoteProposal(t *testing.T) {
+	dbh, closer := testDB(t)
+	defer closer()
+	e := MakeTCPWSEngine(dbh, nil)
+	author, a, b := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	for _, u := range []User{{ID: a.String(), Rate: 1}, {ID: b.String(), Rate: 1.5}} {
+		if err := writeJSON(dbh, db.USERS, idBytes(u.ID), u); err != nil {
+			t.Fatalf("writeJSON error: %v", err)
+		}
+	}
+	id, err := e.AddProposal(Proposal{AuthorID: author.String(), Symbol: DefaultSymbol, Price: NewPrice(123450, 5),
+		GoalScore: 2, Deadline: now() + 3600})
+	if err != nil {
+		t.Fatalf("AddProposal error: %v", err)
+	}
+	if err = e.VoteProposal(id, author); err != ErrSelfVote {
+		t.Errorf("VoteProposal expected ErrSelfVote, got %v", err)
+	}
+	if err = e.VoteProposal(id, a); err != nil {
+		t.Fatalf("VoteProposal error: %v", err)
+	}
+	if err = e.VoteProposal(id, a); err != ErrDuplicateVote {
+		t.Errorf("VoteProposal expected ErrDuplicateVote, got %v", err)
+	}
+	p, _ := e.readProposal(id)
+	if p.State != StateProposal {
+		t.Errorf("Unexpected state before the goal: %d", p.State)
+	}
+	// The second vote reaches the goal
+	if err = e.VoteProposal(id, b); err != nil {
+		t.Fatalf("VoteProposal error: %v", err)
+	}
+	p, _ = e.readProposal(id)
+	dyn := DynProp{}
+	if err = readJSON(dbh, db.DYNAMIC, id.Bytes(), &dyn); err != nil {
+		t.Fatalf("readJSON error: %v", err)
+	}
+	if p.State != StatePending || p.Score != 2.5 || len(p.Votes) != 2 || dyn.Score != 2.5 {
+		t.Errorf("Unexpected proposal %+v with votes %+v", p, dyn)
+	}
+}
@@ -7,9 +7,50 @@ import (
 
 func init() {
 	beego.Router("/", &controllers.MainController{})
+	// Authentication
+	beego.Router("/auth/register", &controllers.AuthController{}, "post:Register")
+	beego.Router("/auth/login", &controllers.AuthController{}, "post:Login")
+	beego.Router("/auth/logout", &controllers.AuthController{}, "post:Logout")
 	// Info controllers
 	beego.Router("/proposal", &controllers.ProposalController{})
+	beego.Router("/proposal/vote", &controllers.ProposalController{}, "post:Vote")
+	beego.Router("/proposals", &controllers.ProposalsController{})
+	beego.Router("/instruments", &controllers.InstrumentController{})
+	beego.Router("/quotes", &controllers.QuoteController{})
+	beego.Router("/candles", &controllers.CandleController{})
+	beego.Router("/replay", &controllers.ReplayController{})
+	beego.Router("/backtest", &controllers.BacktestController{})
+	beego.Router("/account", &controllers.AccountController{})
+	beego.Router("/account/join", &controllers.AccountController{}, "post:Join")
+	beego.Router("/account/leave", &controllers.AccountController{}, "post:Leave")
+	beego.Router("/account/followers", &controllers.AccountController{}, "get:Followers")
+	beego.Router("/risk", &controllers.RiskController{})
+	beego.Router("/leaderboards", &controllers.LeaderboardController{}, "get:Get;post:Refresh")
+	beego.Router("/leaderboards/seasons", &controllers.LeaderboardController{}, "get:Seasons;post:AddSeason")
+	beego.Router("/stats", &controllers.StatsController{})
+	beego.Router("/alerts", &controllers.AlertController{})
+	beego.Router("/alerts/delete", &controllers.AlertController{}, "post:Delete")
+	beego.Router("/notifications", &controllers.NotificationController{})
+	beego.Router("/notifications/read", &controllers.NotificationController{}, "post:Read")
+	beego.Router("/notifications/readall", &controllers.NotificationController{}, "post:ReadAll")
 	beego.Router("/chat", &controllers.ChatController{})
+	beego.Router("/chat/edit", &controllers.ChatController{}, "post:Edit")
+	beego.Router("/chat/react", &controllers.ChatController{}, "post:React")
+	beego.Router("/rooms", &controllers.RoomController{})
+	beego.Router("/rooms/join", &controllers.RoomController{}, "post:Join")
+	beego.Router("/rooms/leave", &controllers.RoomController{}, "post:Leave")
+	beego.Router("/dm", &controllers.DirectController{})
+	// Forum
+	beego.Router("/forum/categories", &controllers.ForumController{}, "get:Categories;post:AddCategory")
+	beego.Router("/forum/threads", &controllers.ForumController{}, "get:Threads;post:AddThread")
+	beego.Router("/forum/threads/flags", &controllers.ForumController{}, "post:ThreadFlags")
+	beego.Router("/forum/posts", &controllers.ForumController{}, "get:Posts;post:AddPost")
+	beego.Router("/forum/posts/edit", &controllers.ForumController{}, "post:EditPost")
+	// Moderation
+	beego.Router("/moderation/message", &controllers.ModerationController{}, "post:Message")
+	beego.Router("/moderation/sanction", &controllers.ModerationController{}, "post:Sanction")
+	beego.Router("/moderation/log", &controllers.ModerationController{}, "get:Log")
+	beego.Router("/moderation/role", &controllers.ModerationController{}, "post:Role")
 	// WebSocket connection
 	beego.Router("/ws", &controllers.WebSocketController{})
 }